@@ -1,68 +1,446 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
 
-	"quidque.no/ow2-ip-puller/internal/github"
+	"quidque.no/ow-vpn-shared/iprange"
+	"quidque.no/ow2-ip-puller/internal/diff"
+	"quidque.no/ow2-ip-puller/internal/httpcache"
 	"quidque.no/ow2-ip-puller/internal/output"
 	"quidque.no/ow2-ip-puller/internal/regions"
+	"quidque.no/ow2-ip-puller/internal/snapshot"
+	"quidque.no/ow2-ip-puller/internal/source"
 )
 
 const (
 	localVersionFile  = "ips_mina/IP_version.txt"
 	outputDir         = "ips_mina"
+	defaultCacheDir   = "ips_cache"
+	defaultSnapshots  = "ips_snapshots"
 	versionCheckOnly  = "check"
 	versionForceFetch = "force"
+
+	// policyStrict fails the whole update when any region fails, policyPartial keeps the
+	// previous list of the regions that failed and updates the others
+	policyStrict  = "strict"
+	policyPartial = "partial"
+
+	// What to do with a region that has a file locally but is no longer published upstream
+	removedDrop = "drop"
+	removedKeep = "keep"
 )
 
+// updateOptions are the flags that control an update
+type updateOptions struct {
+	versionAction  string
+	failurePolicy  string
+	removedRegions string
+	snapshots      *snapshot.Store
+	// pin is set while a rollback keeps the lists at an older version
+	pin *snapshot.Pin
+}
+
 func main() {
 	versionAction := flag.String("version", "", "Version action: 'check' to only check for updates, 'force' to force update")
+	sourceKind := flag.String("source", envOr("OW_IP_SOURCE", source.KindGitHub), "Where to get the IP lists: "+strings.Join(source.Kinds, ", ")+" (default: $OW_IP_SOURCE or github)")
+	sourceLocation := flag.String("source-location", os.Getenv("OW_IP_SOURCE_LOCATION"), "Base URL for github and mirror, directory for dir, zip file for archive (default: $OW_IP_SOURCE_LOCATION, or the upstream repository for github)")
+	failurePolicy := flag.String("failure-policy", policyPartial, "When a region cannot be fetched: 'strict' writes nothing, 'partial' keeps that region's previous list and updates the others")
+	removedRegions := flag.String("removed-regions", removedDrop, "When a region is no longer published upstream: 'drop' removes its list, 'keep' keeps the previous list")
+	retries := flag.Int("retries", httpcache.DefaultRetries, "How often to retry a download that failed because of the network or a server error")
+	cacheDir := flag.String("cache-dir", defaultCacheDir, "Directory for cached downloads, used for conditional requests and when the network is down ('off' to disable)")
+	snapshotDir := flag.String("snapshot-dir", defaultSnapshots, "Directory holding copies of previous IP list versions")
+	keepSnapshots := flag.Int("keep-snapshots", snapshot.DefaultKeep, "How many IP list versions to keep in the snapshot directory (0 to stop taking snapshots)")
+	listSnapshots := flag.Bool("list-snapshots", false, "List the stored IP list versions and exit")
+	rollback := flag.String("rollback", "", "Replace the IP lists with the stored snapshot of this version (or snapshot ID), pin them there and exit, without network access")
+	unpin := flag.Bool("unpin", false, "Remove the pin set by -rollback, so this and later updates replace the rolled back lists again")
+	showDiff := flag.Bool("diff", false, "Fetch the lists and print what changed compared to the local lists, without writing anything")
+	format := flag.String("format", "text", "Output format for -list-snapshots and -diff: text or json")
 	flag.Parse()
 
 	regions.InitRegionMap()
 
-	needUpdate, remoteVersion, err := checkForUpdates()
+	if *failurePolicy != policyStrict && *failurePolicy != policyPartial {
+		exitWithError(fmt.Errorf("unknown failure policy '%s' (use %s or %s)", *failurePolicy, policyStrict, policyPartial))
+	}
+	if *removedRegions != removedDrop && *removedRegions != removedKeep {
+		exitWithError(fmt.Errorf("unknown removed-regions setting '%s' (use %s or %s)", *removedRegions, removedDrop, removedKeep))
+	}
+
+	// A run stopped while swapping in new lists leaves them aside, restore them before
+	// reading the local version
+	if _, err := output.RecoverInterruptedSwap(outputDir); err != nil {
+		exitWithError(err)
+	}
+
+	snapshots := &snapshot.Store{Dir: *snapshotDir, Keep: *keepSnapshots}
+	if *listSnapshots {
+		if err := printSnapshots(snapshots, *format); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+	if *rollback != "" {
+		if err := rollbackTo(snapshots, *rollback); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+	if *unpin {
+		unpinned, err := snapshots.Unpin()
+		if err != nil {
+			exitWithError(err)
+		}
+		if unpinned {
+			fmt.Println("Removed the rollback pin, updates replace the IP lists again")
+		} else {
+			fmt.Println("IP lists are not pinned")
+		}
+	}
+	pin, err := snapshots.Pinned()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *cacheDir == "off" {
+		*cacheDir = ""
+	}
+	cache := httpcache.New(*cacheDir, source.NewHTTPClient())
+	cache.Retries = *retries
+
+	src, err := source.New(*sourceKind, *sourceLocation, cache)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *showDiff {
+		err := printDiff(src, *format)
+		if *format != "json" {
+			printDownloadSummary(cache)
+		}
+		if err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	if *keepSnapshots <= 0 {
+		snapshots = nil
+	}
+	err = run(src, updateOptions{
+		versionAction:  *versionAction,
+		failurePolicy:  *failurePolicy,
+		removedRegions: *removedRegions,
+		snapshots:      snapshots,
+		pin:            pin,
+	})
+	printDownloadSummary(cache)
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// run checks the source for a newer version and, when needed, writes its lists
+func run(src source.Source, opts updateOptions) error {
+	needUpdate, remoteVersion, err := checkForUpdates(src)
 	if err != nil {
 		fmt.Printf("Warning: Could not check for IP list updates: %v\n", err)
 	}
 
-	if *versionAction == versionCheckOnly {
+	if opts.versionAction == versionCheckOnly {
 		if needUpdate {
 			fmt.Printf("Update available: Version %s\n", remoteVersion)
-			os.Exit(0)
 		} else {
 			fmt.Printf("No updates available. Current version: %s\n", remoteVersion)
-			os.Exit(0)
 		}
+		if opts.pin != nil {
+			printPinned(opts.pin)
+		}
+		return nil
 	}
 
-	if !needUpdate && *versionAction != versionForceFetch {
+	// Also a forced update, otherwise the next start of the GUI would undo the rollback
+	if opts.pin != nil {
+		printPinned(opts.pin)
+		return nil
+	}
+
+	if !needUpdate && opts.versionAction != versionForceFetch {
 		fmt.Printf("IP lists are up to date (version %s). Use -version=force to force update.\n", remoteVersion)
-		os.Exit(0)
+		return nil
 	}
 
-	fmt.Println("Fetching IP addresses from GitHub source...")
-	ipsByRegion, err := fetchFromGitHub()
+	fmt.Printf("Fetching IP addresses from %s...\n", src.Name())
+	result, err := src.Fetch()
 	if err != nil {
-		exitWithError(fmt.Errorf("failed to fetch IP data from GitHub: %w", err))
+		return fmt.Errorf("failed to fetch IP data from %s: %w", src.Name(), err)
 	}
 
-	// Validate IPs before writing
-	ipsByRegion = validateIPs(ipsByRegion)
+	// Validate and aggregate the ranges before writing
+	ipsByRegion, counts := normalizeLists(result.Regions)
+	printListCounts(counts)
 
-	if err := output.CreateOutputDirectory(outputDir); err != nil {
-		exitWithError(fmt.Errorf("error creating output directory: %w", err))
+	outcomes := regionOutcomes(result, ipsByRegion)
+	written := len(result.Failed) == 0 || opts.failurePolicy == policyPartial
+	printUpdateSummary(outcomes, opts, written)
+	if !written {
+		return fmt.Errorf("%d region(s) could not be fetched, nothing was written (failure policy %s)", len(result.Failed), policyStrict)
+	}
+
+	lists := output.Snapshot{Version: result.Version, Regions: ipsByRegion, Source: src.Name()}
+	for _, outcome := range outcomes {
+		if outcome.err != nil || (outcome.removed && opts.removedRegions == removedKeep) {
+			delete(lists.Regions, outcome.region)
+			lists.Keep = append(lists.Keep, outcome.region)
+		}
 	}
 
-	output.WriteIPsToFilesWithDir(ipsByRegion, outputDir)
+	// Leaving the version alone makes the next run fetch again, retrying the failed regions
+	if len(result.Failed) > 0 {
+		lists.KeepVersion = true
+		fmt.Printf("Version %s not recorded, regions that failed are retried on the next run\n", result.Version)
+	}
+
+	if err := output.WriteSnapshot(outputDir, lists); err != nil {
+		return fmt.Errorf("error writing IP lists: %w", err)
+	}
 	fmt.Printf("Successfully processed IP ranges and saved to %s/ directory\n", outputDir)
+
+	// Only complete versions are worth rolling back to
+	if opts.snapshots != nil && !lists.KeepVersion {
+		saveSnapshot(opts.snapshots, result.Version, src.Name())
+	}
+	return nil
+}
+
+// printDiff fetches the lists from the source and compares them to the local lists.
+// Regions that failed to download are left out, rather than reported as removed.
+func printDiff(src source.Source, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format '%s' (use text or json)", format)
+	}
+
+	if format == "text" {
+		fmt.Printf("Fetching IP addresses from %s...\n", src.Name())
+	}
+	result, err := src.Fetch()
+	if err != nil {
+		return fmt.Errorf("failed to fetch IP data from %s: %w", src.Name(), err)
+	}
+	newLists, _ := normalizeLists(result.Regions)
+
+	current := &source.Result{Regions: map[regions.Region][]string{}}
+	if _, err := os.Stat(localVersionFile); err == nil {
+		local := &source.Dir{Path: outputDir}
+		if current, err = local.Fetch(); err != nil {
+			return fmt.Errorf("reading local IP lists: %w", err)
+		}
+	}
+
+	// Lists written before normalization was added still hold the ranges as downloaded
+	currentLists, _ := normalizeLists(current.Regions)
+
+	for region := range result.Failed {
+		delete(newLists, region)
+		delete(currentLists, region)
+	}
+
+	report := diff.Compare(current.Version, currentLists, result.Version, newLists)
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	report.WriteText(os.Stdout)
+	for _, region := range regions.Known {
+		if err, failed := result.Failed[region]; failed {
+			fmt.Printf("  %-4s not compared, download failed: %v\n", region, flattenError(err))
+		}
+	}
+	return nil
 }
 
-func checkForUpdates() (bool, string, error) {
+// saveSnapshot copies the lists just written into the snapshot store. A failure only
+// costs the ability to roll back to this version, so it is reported as a warning.
+func saveSnapshot(store *snapshot.Store, version, sourceName string) {
+	files, err := output.ReadFiles(outputDir)
+	if err != nil {
+		fmt.Printf("Warning: Could not read IP lists for snapshot: %v\n", err)
+		return
+	}
+
+	saved, created, err := store.Save(version, sourceName, files)
+	if err != nil {
+		fmt.Printf("Warning: Could not save snapshot: %v\n", err)
+		return
+	}
+	if created {
+		fmt.Printf("Saved snapshot %s\n", saved.ID)
+	}
+}
+
+func printSnapshots(store *snapshot.Store, format string) error {
+	snapshots, err := store.List()
+	if err != nil {
+		return err
+	}
+	pin, err := store.Pinned()
+	if err != nil {
+		return err
+	}
+	for i := range snapshots {
+		snapshots[i].Pinned = pin != nil && snapshots[i].ID == pin.ID
+	}
+
+	switch format {
+	case "json":
+		if snapshots == nil {
+			snapshots = []snapshot.Snapshot{}
+		}
+		data, err := json.MarshalIndent(snapshots, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "text":
+		if len(snapshots) == 0 {
+			fmt.Printf("No snapshots in %s\n", store.Dir)
+			return nil
+		}
+		current := currentVersion()
+		for _, s := range snapshots {
+			marker := " "
+			if s.Version == current {
+				marker = "*"
+			}
+			pinned := ""
+			if s.Pinned {
+				pinned = "  pinned"
+			}
+			fmt.Printf("%s %-12s %s  %d files  %s  (%s)%s\n", marker, s.Version, s.CreatedAt.Local().Format("2006-01-02 15:04:05"), len(s.Files), s.Source, s.ID, pinned)
+		}
+		if pin != nil {
+			fmt.Printf("Pinned to version %s since %s, updates are skipped until -unpin\n", pin.Version, pin.PinnedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+	default:
+		return fmt.Errorf("unknown format '%s' (use text or json)", format)
+	}
+	return nil
+}
+
+// rollbackTo replaces the IP lists with a stored snapshot after checking its checksums
+func rollbackTo(store *snapshot.Store, version string) error {
+	snap, err := store.Find(version)
+	if err != nil {
+		return err
+	}
+
+	files, err := store.Files(snap)
+	if err != nil {
+		return err
+	}
+	if err := output.WriteFiles(outputDir, files); err != nil {
+		return fmt.Errorf("error restoring snapshot %s: %w", snap.ID, err)
+	}
+	fmt.Printf("Rolled back IP lists to version %s (snapshot %s from %s)\n", snap.Version, snap.ID, snap.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+
+	if err := store.Pin(snap); err != nil {
+		return fmt.Errorf("lists rolled back, but %w; the next update replaces them", err)
+	}
+	fmt.Println("Pinned the IP lists to this version, updates leave them alone until -unpin")
+	return nil
+}
+
+// printPinned says that an update was skipped because of a rollback
+func printPinned(pin *snapshot.Pin) {
+	fmt.Printf("IP lists are pinned to version %s by a rollback (snapshot %s), not updating. Use -unpin to update again.\n", pin.Version, pin.ID)
+}
+
+// currentVersion returns the version of the local IP lists, or "" when there are none
+func currentVersion() string {
+	data, err := os.ReadFile(localVersionFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// regionOutcome is the final state of one region after an update
+type regionOutcome struct {
+	region  regions.Region
+	ranges  int
+	err     error
+	hasPrev bool
+	// removed is set for regions that have a local list but were not published
+	removed bool
+}
+
+// regionOutcomes lists every region the source returned or failed to return, and every
+// local region it no longer has, in the order of regions.Known
+func regionOutcomes(result *source.Result, ipsByRegion map[regions.Region][]string) []regionOutcome {
+	existing := make(map[regions.Region]bool)
+	for _, region := range output.ExistingRegions(outputDir) {
+		existing[region] = true
+	}
+
+	var outcomes []regionOutcome
+	for _, region := range regions.Known {
+		ips, fetched := ipsByRegion[region]
+		err, failed := result.Failed[region]
+		fetched = fetched && len(ips) > 0
+		if !fetched && !failed && !existing[region] {
+			continue
+		}
+
+		outcomes = append(outcomes, regionOutcome{
+			region:  region,
+			ranges:  len(ips),
+			err:     err,
+			hasPrev: existing[region],
+			removed: !fetched && !failed,
+		})
+	}
+	return outcomes
+}
+
+func printUpdateSummary(outcomes []regionOutcome, opts updateOptions, written bool) {
+	fmt.Printf("Update summary (failure policy: %s, removed regions: %s):\n", opts.failurePolicy, opts.removedRegions)
+	for _, outcome := range outcomes {
+		switch {
+		case outcome.removed && !written:
+			fmt.Printf("  %-4s no longer published\n", outcome.region)
+		case outcome.removed && opts.removedRegions == removedKeep:
+			fmt.Printf("  %-4s no longer published, kept previous list\n", outcome.region)
+		case outcome.removed:
+			fmt.Printf("  %-4s no longer published, list removed\n", outcome.region)
+		case outcome.err == nil && !written:
+			fmt.Printf("  %-4s fetched (%d ranges), not written\n", outcome.region, outcome.ranges)
+		case outcome.err == nil:
+			fmt.Printf("  %-4s updated (%d ranges)\n", outcome.region, outcome.ranges)
+		case opts.failurePolicy == policyStrict:
+			fmt.Printf("  %-4s failed: %v\n", outcome.region, flattenError(outcome.err))
+		case outcome.hasPrev:
+			fmt.Printf("  %-4s failed, kept previous list: %v\n", outcome.region, flattenError(outcome.err))
+		default:
+			fmt.Printf("  %-4s failed, no previous list to keep: %v\n", outcome.region, flattenError(outcome.err))
+		}
+	}
+}
+
+// flattenError puts the errors of a region with several failed files on one line
+func flattenError(err error) string {
+	return strings.ReplaceAll(err.Error(), "\n", "; ")
+}
+
+func checkForUpdates(src source.Source) (bool, string, error) {
 	// Check if we have a local version file
 	localVersion := ""
 	localData, err := os.ReadFile(localVersionFile)
@@ -71,7 +449,7 @@ func checkForUpdates() (bool, string, error) {
 	}
 
 	// Get remote version
-	remoteVersion, err := github.FetchVersionNumber()
+	remoteVersion, err := src.Version()
 	if err != nil {
 		return true, "", err
 	}
@@ -131,79 +509,77 @@ func isNewerVersion(remote, local string) (bool, error) {
 	return false, nil
 }
 
-func fetchFromGitHub() (map[regions.Region][]string, error) {
-	return github.FetchAndCategorizeIPs()
+// listCounts are the sizes of a region's list before and after normalization
+type listCounts struct {
+	before  int
+	after   int
+	invalid error
 }
 
-func validateIPs(ipsByRegion map[regions.Region][]string) map[regions.Region][]string {
-	validatedIPs := make(map[regions.Region][]string)
+// normalizeLists drops invalid ranges and replaces every region's list with the fewest
+// CIDR prefixes covering the same addresses, merging overlapping and adjacent ranges
+// and converting a-b ranges
+func normalizeLists(ipsByRegion map[regions.Region][]string) (map[regions.Region][]string, map[regions.Region]listCounts) {
+	normalized := make(map[regions.Region][]string)
+	counts := make(map[regions.Region]listCounts)
 
 	for region, ips := range ipsByRegion {
-		validIPs := make([]string, 0, len(ips))
-		for _, ip := range ips {
-			if isValidIPRange(ip) {
-				validIPs = append(validIPs, ip)
-			}
-		}
-		validatedIPs[region] = validIPs
+		ranges, invalid := iprange.ParseAll(ips)
+
+		list := iprange.NewSet(ranges).Strings()
+
+		normalized[region] = list
+		counts[region] = listCounts{before: len(ips), after: len(list), invalid: invalid}
 	}
 
-	return validatedIPs
+	return normalized, counts
 }
 
-func isValidIPRange(ipRange string) bool {
-	// Check CIDR notation (e.g., 192.168.1.0/24)
-	if strings.Contains(ipRange, "/") {
-		parts := strings.Split(ipRange, "/")
-		if len(parts) != 2 {
-			return false
+// printListCounts logs how much normalization shrank every region's list
+func printListCounts(counts map[regions.Region]listCounts) {
+	fmt.Println("Normalized ranges:")
+	for _, region := range regions.Known {
+		c, ok := counts[region]
+		if !ok {
+			continue
 		}
-
-		// Validate IP part
-		if !isValidIPv4(parts[0]) {
-			return false
+		line := fmt.Sprintf("  %-4s %d ranges -> %d CIDR prefixes", region, c.before, c.after)
+		if c.invalid != nil {
+			line += fmt.Sprintf(", skipped %v", c.invalid)
 		}
-
-		// Validate subnet part
-		subnet, err := strconv.Atoi(parts[1])
-		if err != nil || subnet < 0 || subnet > 32 {
-			return false
-		}
-
-		return true
+		fmt.Println(line)
 	}
-
-	// Check range notation (e.g., 192.168.1.1-192.168.1.10)
-	if strings.Contains(ipRange, "-") {
-		parts := strings.Split(ipRange, "-")
-		if len(parts) != 2 {
-			return false
-		}
-
-		// Validate start and end IPs
-		return isValidIPv4(parts[0]) && isValidIPv4(parts[1])
-	}
-
-	// Single IP address
-	return isValidIPv4(ipRange)
 }
 
-func isValidIPv4(ip string) bool {
-	ip = strings.TrimSpace(ip)
-	parts := strings.Split(ip, ".")
-
-	if len(parts) != 4 {
-		return false
+// printDownloadSummary reports for every URL whether its data was downloaded, confirmed
+// unchanged by the server or taken from the cache because the server was unreachable
+func printDownloadSummary(cache *httpcache.Cache) {
+	outcomes := cache.Outcomes()
+	if len(outcomes) == 0 {
+		return
 	}
 
-	for _, part := range parts {
-		num, err := strconv.Atoi(part)
-		if err != nil || num < 0 || num > 255 {
-			return false
+	fmt.Println("Download summary:")
+	for _, outcome := range outcomes {
+		line := fmt.Sprintf("  %-11s %s", outcome.Status, outcome.URL)
+		if outcome.Attempts > 1 {
+			line += fmt.Sprintf(" after %d attempts", outcome.Attempts)
 		}
+		if outcome.Error != "" {
+			line += fmt.Sprintf(" (%s)", outcome.Error)
+		}
+		if outcome.CacheError != "" {
+			line += fmt.Sprintf(" (not cached: %s)", outcome.CacheError)
+		}
+		fmt.Println(line)
 	}
+}
 
-	return true
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
 }
 
 func exitWithError(err error) {
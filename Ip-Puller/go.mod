@@ -1,3 +1,7 @@
 module quidque.no/ow2-ip-puller
 
 go 1.24.2
+
+require quidque.no/ow-vpn-shared v0.0.0
+
+replace quidque.no/ow-vpn-shared => ../shared
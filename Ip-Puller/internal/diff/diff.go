@@ -0,0 +1,192 @@
+// Package diff compares two versions of the IP lists region by region
+package diff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"quidque.no/ow-vpn-shared/iprange"
+	"quidque.no/ow2-ip-puller/internal/regions"
+)
+
+// RegionDiff lists the changes of one region as CIDR prefixes. Address counts are of
+// the merged IPv4 ranges, so overlapping ranges are only counted once. IPv6 ranges are
+// listed but not counted, as a single /64 already holds more addresses than all of IPv4.
+type RegionDiff struct {
+	Region           regions.Region `json:"region"`
+	Added            []string       `json:"added"`
+	Removed          []string       `json:"removed"`
+	AddressesBefore  uint64         `json:"addressesBefore"`
+	AddressesAfter   uint64         `json:"addressesAfter"`
+	AddressesChanged int64          `json:"addressesChanged"`
+}
+
+// Changed reports whether any range of the region was added or removed
+func (d RegionDiff) Changed() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0
+}
+
+// Move is a prefix of addresses that were removed from one region and added to another
+type Move struct {
+	Range string         `json:"range"`
+	From  regions.Region `json:"from"`
+	To    regions.Region `json:"to"`
+}
+
+// Report is the difference between two versions of the lists
+type Report struct {
+	FromVersion string       `json:"fromVersion"`
+	ToVersion   string       `json:"toVersion"`
+	Regions     []RegionDiff `json:"regions"`
+	Moved       []Move       `json:"moved"`
+}
+
+// Changed reports whether any region changed
+func (r Report) Changed() bool {
+	for _, region := range r.Regions {
+		if region.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare lists the changes from the old to the new lists of every region in either.
+// The lists are compared as sets of addresses, so splitting or merging ranges is not a
+// change, and the added and removed addresses are listed as the fewest CIDR prefixes.
+// Addresses removed from one region and added to another are listed as moved.
+func Compare(fromVersion string, old map[regions.Region][]string, toVersion string, new map[regions.Region][]string) Report {
+	report := Report{FromVersion: fromVersion, ToVersion: toVersion, Regions: []RegionDiff{}, Moved: []Move{}}
+
+	removedFrom := make(map[regions.Region]*iprange.Set)
+	addedTo := make(map[regions.Region]*iprange.Set)
+
+	for _, region := range regions.Known {
+		before, hadBefore := old[region]
+		after, hasAfter := new[region]
+		if !hadBefore && !hasAfter {
+			continue
+		}
+
+		oldSet := toSet(before)
+		newSet := toSet(after)
+		added := newSet.Difference(oldSet)
+		removed := oldSet.Difference(newSet)
+
+		d := RegionDiff{
+			Region:          region,
+			Added:           nonNil(added.Strings()),
+			Removed:         nonNil(removed.Strings()),
+			AddressesBefore: addresses(oldSet),
+			AddressesAfter:  addresses(newSet),
+		}
+		d.AddressesChanged = int64(d.AddressesAfter) - int64(d.AddressesBefore)
+		report.Regions = append(report.Regions, d)
+
+		removedFrom[region] = removed
+		addedTo[region] = added
+	}
+
+	for _, from := range regions.Known {
+		removed, ok := removedFrom[from]
+		if !ok {
+			continue
+		}
+		for _, to := range regions.Known {
+			added, ok := addedTo[to]
+			if !ok || to == from {
+				continue
+			}
+			for _, text := range removed.Intersection(added).Strings() {
+				report.Moved = append(report.Moved, Move{Range: text, From: from, To: to})
+			}
+		}
+	}
+	sort.Slice(report.Moved, func(i, j int) bool {
+		a, b := report.Moved[i], report.Moved[j]
+		if c := compareRanges(a.Range, b.Range); c != 0 {
+			return c < 0
+		}
+		return a.From < b.From || (a.From == b.From && a.To < b.To)
+	})
+
+	return report
+}
+
+// WriteText prints the report for people
+func (r Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "IP list changes %s -> %s\n", orNone(r.FromVersion), orNone(r.ToVersion))
+
+	for _, d := range r.Regions {
+		if !d.Changed() {
+			fmt.Fprintf(w, "  %-4s unchanged (%d addresses)\n", d.Region, d.AddressesAfter)
+			continue
+		}
+
+		fmt.Fprintf(w, "  %-4s +%d -%d ranges, %+d addresses (%d -> %d)\n",
+			d.Region, len(d.Added), len(d.Removed), d.AddressesChanged, d.AddressesBefore, d.AddressesAfter)
+		for _, text := range d.Added {
+			fmt.Fprintf(w, "       + %s\n", text)
+		}
+		for _, text := range d.Removed {
+			fmt.Fprintf(w, "       - %s\n", text)
+		}
+	}
+
+	if len(r.Moved) > 0 {
+		fmt.Fprintln(w, "Moved between regions:")
+		for _, move := range r.Moved {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", move.Range, move.From, move.To)
+		}
+	}
+}
+
+func orNone(version string) string {
+	if version == "" {
+		return "(none)"
+	}
+	return version
+}
+
+// toSet returns the set of the addresses of a list; ranges that do not parse are left out
+func toSet(texts []string) *iprange.Set {
+	ranges, _ := iprange.ParseAll(texts)
+	return iprange.NewSet(ranges)
+}
+
+func nonNil(texts []string) []string {
+	if texts == nil {
+		return []string{}
+	}
+	return texts
+}
+
+// addresses counts the IPv4 addresses of a set
+func addresses(set *iprange.Set) uint64 {
+	var count uint64
+	for _, r := range set.Ranges() {
+		if r.Is4() {
+			count += r.Size()
+		}
+	}
+	return count
+}
+
+// compareRanges orders ranges by address, falling back to text for ones that do not parse
+func compareRanges(a, b string) int {
+	ra, errA := iprange.Parse(a)
+	rb, errB := iprange.Parse(b)
+	if errA == nil && errB == nil {
+		if c := ra.Compare(rb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
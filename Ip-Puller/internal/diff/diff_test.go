@@ -0,0 +1,154 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"quidque.no/ow2-ip-puller/internal/regions"
+)
+
+type lists = map[regions.Region][]string
+
+// regionDiff returns the diff of one region in a report
+func regionDiff(t *testing.T, report Report, region regions.Region) RegionDiff {
+	t.Helper()
+	for _, d := range report.Regions {
+		if d.Region == region {
+			return d
+		}
+	}
+	t.Fatalf("no diff for %s in %+v", region, report.Regions)
+	return RegionDiff{}
+}
+
+func TestSplitAndMergedRangesAreUnchanged(t *testing.T) {
+	old := lists{
+		regions.EU: {"10.0.0.0/24", "10.0.1.0/24"},
+		regions.NA: {"20.0.0.0/23"},
+	}
+	new := lists{
+		regions.EU: {"10.0.0.0/23"},
+		regions.NA: {"20.0.0.0/24", "20.0.1.0-20.0.1.255", "20.0.0.7"},
+	}
+
+	report := Compare("1", old, "2", new)
+	if report.Changed() {
+		t.Errorf("report = %+v, want no change", report)
+	}
+	for _, region := range []regions.Region{regions.EU, regions.NA} {
+		d := regionDiff(t, report, region)
+		if d.AddressesBefore != 512 || d.AddressesAfter != 512 || d.AddressesChanged != 0 {
+			t.Errorf("%s addresses = %d -> %d (%+d), want 512 unchanged", region, d.AddressesBefore, d.AddressesAfter, d.AddressesChanged)
+		}
+	}
+}
+
+func TestPartialMoveBetweenRegions(t *testing.T) {
+	old := lists{
+		regions.EU: {"10.0.0.0/23"},
+		regions.NA: {"20.0.0.0/24"},
+	}
+	// Half of the EU range moved to NA, NA also gained a range of its own
+	new := lists{
+		regions.EU: {"10.0.0.0/24"},
+		regions.NA: {"20.0.0.0/24", "10.0.1.0/24", "30.0.0.0/30"},
+	}
+
+	report := Compare("1", old, "2", new)
+
+	eu := regionDiff(t, report, regions.EU)
+	if !reflect.DeepEqual(eu.Removed, []string{"10.0.1.0/24"}) || len(eu.Added) != 0 || eu.AddressesChanged != -256 {
+		t.Errorf("EU = %+v", eu)
+	}
+	na := regionDiff(t, report, regions.NA)
+	if !reflect.DeepEqual(na.Added, []string{"10.0.1.0/24", "30.0.0.0/30"}) || len(na.Removed) != 0 || na.AddressesChanged != 260 {
+		t.Errorf("NA = %+v", na)
+	}
+
+	want := []Move{{Range: "10.0.1.0/24", From: regions.EU, To: regions.NA}}
+	if !reflect.DeepEqual(report.Moved, want) {
+		t.Errorf("moved = %+v, want %+v", report.Moved, want)
+	}
+}
+
+func TestRegionOnlyInOneVersion(t *testing.T) {
+	old := lists{regions.EU: {"10.0.0.0/24"}, regions.ME: {"40.0.0.0/30"}}
+	new := lists{regions.EU: {"10.0.0.0/24"}, regions.OCE: {"50.0.0.0/31"}}
+
+	report := Compare("1", old, "2", new)
+
+	var names []regions.Region
+	for _, d := range report.Regions {
+		names = append(names, d.Region)
+	}
+	if !reflect.DeepEqual(names, []regions.Region{regions.EU, regions.ME, regions.OCE}) {
+		t.Errorf("regions = %v, want EU, ME and Oce in fetch order", names)
+	}
+
+	me := regionDiff(t, report, regions.ME)
+	if !reflect.DeepEqual(me.Removed, []string{"40.0.0.0/30"}) || me.AddressesBefore != 4 || me.AddressesAfter != 0 {
+		t.Errorf("dropped region = %+v", me)
+	}
+	oce := regionDiff(t, report, regions.OCE)
+	if !reflect.DeepEqual(oce.Added, []string{"50.0.0.0/31"}) || oce.AddressesBefore != 0 || oce.AddressesAfter != 2 {
+		t.Errorf("new region = %+v", oce)
+	}
+	if len(report.Moved) != 0 {
+		t.Errorf("moved = %+v, want none", report.Moved)
+	}
+}
+
+func TestIPv6IsListedButNotCounted(t *testing.T) {
+	old := lists{regions.EU: {"10.0.0.0/24"}}
+	new := lists{regions.EU: {"10.0.0.0/24", "2001:db8::/32"}}
+
+	eu := regionDiff(t, Compare("1", old, "2", new), regions.EU)
+	if !reflect.DeepEqual(eu.Added, []string{"2001:db8::/32"}) {
+		t.Errorf("added = %v, want the IPv6 prefix", eu.Added)
+	}
+	if eu.AddressesBefore != 256 || eu.AddressesAfter != 256 || eu.AddressesChanged != 0 {
+		t.Errorf("addresses = %d -> %d (%+d), want IPv6 not counted", eu.AddressesBefore, eu.AddressesAfter, eu.AddressesChanged)
+	}
+}
+
+func TestJSONHasEmptySlices(t *testing.T) {
+	report := Compare("", lists{}, "1", lists{regions.EU: {"10.0.0.0/24"}})
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"fromVersion":"","toVersion":"1","regions":[{"region":"EU","added":["10.0.0.0/24"],"removed":[],` +
+		`"addressesBefore":0,"addressesAfter":256,"addressesChanged":256}],"moved":[]}`
+	if string(data) != want {
+		t.Errorf("JSON = %s\nwant   %s", data, want)
+	}
+
+	data, err = json.Marshal(Compare("1", lists{}, "1", lists{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"fromVersion":"1","toVersion":"1","regions":[],"moved":[]}`; string(data) != want {
+		t.Errorf("empty JSON = %s, want %s", data, want)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	report := Compare("", lists{regions.EU: {"10.0.0.0/24"}}, "2", lists{regions.EU: {"10.0.0.0/24"}, regions.NA: {"20.0.0.0/30"}})
+
+	var out bytes.Buffer
+	report.WriteText(&out)
+	for _, line := range []string{
+		"IP list changes (none) -> 2",
+		"EU   unchanged (256 addresses)",
+		"NA   +1 -0 ranges, +4 addresses (0 -> 4)",
+		"+ 20.0.0.0/30",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("text output misses %q:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package httpcache downloads files with conditional requests. The ETag and Last-Modified
+// of every URL are kept on disk with the body, so unchanged files are not downloaded
+// again and the last copy can be used when the network is down.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status says where the data returned for a URL came from
+type Status string
+
+const (
+	// Fresh data was downloaded because the server had a new version or nothing was cached
+	Fresh Status = "fresh"
+	// Revalidated data came from the cache after the server answered 304 Not Modified
+	Revalidated Status = "revalidated"
+	// Stale data came from the cache because the server could not be reached
+	Stale Status = "stale"
+	// Failed means the request failed and nothing was cached
+	Failed Status = "failed"
+)
+
+// StatusError is returned when a server answers with anything but 200 OK or 304
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP request returned status: %d", e.Code)
+}
+
+// Default retry settings used by New
+const (
+	DefaultRetries = 3
+	DefaultBackoff = 500 * time.Millisecond
+)
+
+// Outcome is the result of the last request for a URL
+type Outcome struct {
+	URL      string `json:"url"`
+	Status   Status `json:"status"`
+	Attempts int    `json:"attempts"`
+	// Error is the failure that made a Stale or Failed outcome
+	Error string `json:"error,omitempty"`
+	// CacheError says why fresh data could not be cached, so the next run downloads it
+	// again and cannot fall back to it
+	CacheError string `json:"cacheError,omitempty"`
+}
+
+// entry is the metadata stored next to a cached body
+type entry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+// Cache downloads through an on-disk cache. With an empty Dir nothing is cached and every
+// request is a plain download. Network failures and server errors are retried Retries
+// times, waiting Backoff before the first retry and twice as long before each next one.
+type Cache struct {
+	Client  *http.Client
+	Dir     string
+	Retries int
+	Backoff time.Duration
+
+	mutex    sync.Mutex
+	outcomes map[string]Outcome
+}
+
+// New creates a cache in dir using client for requests
+func New(dir string, client *http.Client) *Cache {
+	return &Cache{
+		Client:   client,
+		Dir:      dir,
+		Retries:  DefaultRetries,
+		Backoff:  DefaultBackoff,
+		outcomes: make(map[string]Outcome),
+	}
+}
+
+// Get returns the body of url. A 304 answer or a network failure returns the cached copy
+// when there is one. Answers like 404 are returned as a StatusError, since the server
+// was reached and said the file is gone.
+func (c *Cache) Get(url string) (string, error) {
+	cached, body, hasCached := c.load(url)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("HTTP request failed: %w", err)
+	}
+	if hasCached {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	var content string
+	var resp *http.Response
+	attempts := 0
+	wait := c.Backoff
+	for {
+		attempts++
+		content, resp, err = c.do(req)
+		if err == nil || !isRetryable(err) || attempts > c.Retries {
+			break
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+
+	switch {
+	case err != nil && hasCached && isRetryable(err):
+		c.record(url, Stale, attempts, err)
+		return body, nil
+
+	case err != nil:
+		c.record(url, Failed, attempts, err)
+		return "", err
+
+	case resp.StatusCode == http.StatusNotModified && hasCached:
+		c.record(url, Revalidated, attempts, nil)
+		return body, nil
+
+	case resp.StatusCode == http.StatusNotModified:
+		err := &StatusError{URL: url, Code: resp.StatusCode}
+		c.record(url, Failed, attempts, err)
+		return "", err
+	}
+
+	cacheErr := c.store(entry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}, content)
+	outcome := Outcome{URL: url, Status: Fresh, Attempts: attempts}
+	if cacheErr != nil {
+		outcome.CacheError = cacheErr.Error()
+	}
+	c.recordOutcome(outcome)
+	return content, nil
+}
+
+// Outcomes returns the outcome of every URL requested so far, ordered by URL
+func (c *Cache) Outcomes() []Outcome {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	outcomes := make([]Outcome, 0, len(c.outcomes))
+	for _, outcome := range c.outcomes {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomes[i].URL < outcomes[j].URL
+	})
+	return outcomes
+}
+
+// do sends a request and reads the body of a 200 answer. 304 is returned without error,
+// any other status as a StatusError.
+func (c *Cache) do(req *http.Request) (string, *http.Response, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return "", resp, nil
+	default:
+		return "", resp, &StatusError{URL: req.URL.String(), Code: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", resp, fmt.Errorf("reading response body: %w", err)
+	}
+	return string(body), resp, nil
+}
+
+// isRetryable tells failures worth retrying and falling back to the cache for (no
+// connection, timeouts, rate limits, server errors) from answers that say the file does
+// not exist
+func isRetryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= http.StatusInternalServerError || status.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func (c *Cache) record(url string, status Status, attempts int, err error) {
+	outcome := Outcome{URL: url, Status: status, Attempts: attempts}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	c.recordOutcome(outcome)
+}
+
+func (c *Cache) recordOutcome(outcome Outcome) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.outcomes[outcome.URL] = outcome
+}
+
+// paths returns the metadata and body file of a URL
+func (c *Cache) paths(url string) (string, string) {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:16])
+	return filepath.Join(c.Dir, name+".json"), filepath.Join(c.Dir, name+".body")
+}
+
+func (c *Cache) load(url string) (entry, string, bool) {
+	if c.Dir == "" {
+		return entry{}, "", false
+	}
+
+	metaPath, bodyPath := c.paths(url)
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return entry{}, "", false
+	}
+	var cached entry
+	if err := json.Unmarshal(data, &cached); err != nil || cached.URL != url {
+		return entry{}, "", false
+	}
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return entry{}, "", false
+	}
+	return cached, string(body), true
+}
+
+// store writes the body before the metadata, so a crash in between leaves the old
+// validators pointing at a body that is only newer, never older
+func (c *Cache) store(cached entry, body string) error {
+	if c.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	metaPath, bodyPath := c.paths(cached.URL)
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding cache entry: %w", err)
+	}
+	if err := writeFileAtomic(bodyPath, []byte(body)); err != nil {
+		return fmt.Errorf("caching body: %w", err)
+	}
+	if err := writeFileAtomic(metaPath, data); err != nil {
+		return fmt.Errorf("caching metadata: %w", err)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
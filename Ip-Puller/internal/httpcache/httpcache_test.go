@@ -0,0 +1,232 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server answers each request with the next response of a script, repeating the last
+// one, and records the conditional request headers it received
+type server struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	responses []func(w http.ResponseWriter)
+	requests  []http.Header
+}
+
+func newServer(t *testing.T, responses ...func(w http.ResponseWriter)) *server {
+	t.Helper()
+	s := &server{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests = append(s.requests, r.Header.Clone())
+		respond := s.responses[0]
+		if len(s.responses) > 1 {
+			s.responses = s.responses[1:]
+		}
+		s.mutex.Unlock()
+		respond(w)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) requestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.requests)
+}
+
+func ok(body, etag, lastModified string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if lastModified != "" {
+			w.Header().Set("Last-Modified", lastModified)
+		}
+		w.Write([]byte(body))
+	}
+}
+
+func status(code int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) { w.WriteHeader(code) }
+}
+
+func newCache(t *testing.T, s *server, dir string) *Cache {
+	t.Helper()
+	cache := New(dir, s.Client())
+	cache.Backoff = time.Millisecond
+	return cache
+}
+
+func outcome(t *testing.T, cache *Cache, url string) Outcome {
+	t.Helper()
+	for _, outcome := range cache.Outcomes() {
+		if outcome.URL == url {
+			return outcome
+		}
+	}
+	t.Fatalf("no outcome for %s", url)
+	return Outcome{}
+}
+
+const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+func TestGetRevalidatesWithValidators(t *testing.T) {
+	dir := t.TempDir()
+	s := newServer(t, ok("1.2.3.0/24\n", `"v1"`, lastModified), status(http.StatusNotModified))
+	url := s.URL + "/EU.txt"
+
+	first := newCache(t, s, dir)
+	if body, err := first.Get(url); err != nil || body != "1.2.3.0/24\n" {
+		t.Fatalf("first Get = %q, %v", body, err)
+	}
+	if got := outcome(t, first, url); got.Status != Fresh || got.Attempts != 1 {
+		t.Errorf("first outcome = %+v, want fresh", got)
+	}
+	if header := s.requests[0]; header.Get("If-None-Match") != "" || header.Get("If-Modified-Since") != "" {
+		t.Errorf("first request sent validators: %v", header)
+	}
+
+	second := newCache(t, s, dir)
+	if body, err := second.Get(url); err != nil || body != "1.2.3.0/24\n" {
+		t.Fatalf("second Get = %q, %v", body, err)
+	}
+	if got := outcome(t, second, url); got.Status != Revalidated {
+		t.Errorf("second outcome = %+v, want revalidated", got)
+	}
+	if header := s.requests[1]; header.Get("If-None-Match") != `"v1"` || header.Get("If-Modified-Since") != lastModified {
+		t.Errorf("second request headers = %v, want the stored ETag and Last-Modified", header)
+	}
+}
+
+func TestGetStaleOnServerError(t *testing.T) {
+	dir := t.TempDir()
+	s := newServer(t, ok("1.2.3.0/24\n", `"v1"`, ""), status(http.StatusServiceUnavailable))
+	url := s.URL + "/EU.txt"
+
+	if _, err := newCache(t, s, dir).Get(url); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newCache(t, s, dir)
+	body, err := cache.Get(url)
+	if err != nil || body != "1.2.3.0/24\n" {
+		t.Fatalf("Get = %q, %v, want the cached list", body, err)
+	}
+	got := outcome(t, cache, url)
+	if got.Status != Stale || got.Attempts != DefaultRetries+1 || got.Error == "" {
+		t.Errorf("outcome = %+v, want stale after %d attempts", got, DefaultRetries+1)
+	}
+	if count := s.requestCount(); count != 1+DefaultRetries+1 {
+		t.Errorf("server saw %d requests, want %d", count, 1+DefaultRetries+1)
+	}
+}
+
+func TestGetStaleOnNetworkError(t *testing.T) {
+	dir := t.TempDir()
+	s := newServer(t, ok("1.2.3.0/24\n", "", lastModified))
+	url := s.URL + "/EU.txt"
+
+	if _, err := newCache(t, s, dir).Get(url); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	cache := newCache(t, s, dir)
+	cache.Retries = 1
+	body, err := cache.Get(url)
+	if err != nil || body != "1.2.3.0/24\n" {
+		t.Fatalf("Get = %q, %v, want the cached list", body, err)
+	}
+	if got := outcome(t, cache, url); got.Status != Stale || got.Attempts != 2 {
+		t.Errorf("outcome = %+v, want stale after 2 attempts", got)
+	}
+}
+
+func TestGetNotFoundIgnoresCache(t *testing.T) {
+	dir := t.TempDir()
+	s := newServer(t, ok("1.2.3.0/24\n", `"v1"`, ""), status(http.StatusNotFound))
+	url := s.URL + "/EU.txt"
+
+	if _, err := newCache(t, s, dir).Get(url); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newCache(t, s, dir)
+	body, err := cache.Get(url)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound || body != "" {
+		t.Fatalf("Get = %q, %v, want a 404 StatusError", body, err)
+	}
+	if got := outcome(t, cache, url); got.Status != Failed || got.Attempts != 1 {
+		t.Errorf("outcome = %+v, want failed without retries", got)
+	}
+}
+
+func TestGetRetriesUntilSuccess(t *testing.T) {
+	s := newServer(t,
+		status(http.StatusBadGateway),
+		status(http.StatusTooManyRequests),
+		ok("1.2.3.0/24\n", "", ""),
+	)
+	url := s.URL + "/EU.txt"
+
+	cache := newCache(t, s, "")
+	if body, err := cache.Get(url); err != nil || body != "1.2.3.0/24\n" {
+		t.Fatalf("Get = %q, %v", body, err)
+	}
+	if got := outcome(t, cache, url); got.Status != Fresh || got.Attempts != 3 {
+		t.Errorf("outcome = %+v, want fresh after 3 attempts", got)
+	}
+}
+
+func TestGetFailsWithoutCache(t *testing.T) {
+	s := newServer(t, status(http.StatusInternalServerError))
+	url := s.URL + "/EU.txt"
+
+	cache := newCache(t, s, t.TempDir())
+	cache.Retries = 2
+	if _, err := cache.Get(url); err == nil {
+		t.Fatal("Get succeeded")
+	}
+	if got := outcome(t, cache, url); got.Status != Failed || got.Attempts != 3 {
+		t.Errorf("outcome = %+v, want failed after 3 attempts", got)
+	}
+}
+
+func TestStoreWritesBodyBeforeMetadata(t *testing.T) {
+	dir := t.TempDir()
+	s := newServer(t, ok("1.2.3.0/24\n", `"v1"`, ""), ok("5.6.7.0/24\n", `"v2"`, ""))
+	url := s.URL + "/EU.txt"
+
+	if _, err := newCache(t, s, dir).Get(url); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in place of the metadata file makes only the metadata write fail
+	cache := newCache(t, s, dir)
+	metaPath, bodyPath := cache.paths(url)
+	if err := os.Remove(metaPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(metaPath+"/blocked", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if body, err := cache.Get(url); err != nil || body != "5.6.7.0/24\n" {
+		t.Fatalf("Get = %q, %v", body, err)
+	}
+	if got := outcome(t, cache, url); got.Status != Fresh || got.CacheError == "" {
+		t.Errorf("outcome = %+v, want fresh with the cache error", got)
+	}
+	if body, err := os.ReadFile(bodyPath); err != nil || string(body) != "5.6.7.0/24\n" {
+		t.Errorf("cached body = %q, %v, want the new body written first", body, err)
+	}
+}
@@ -4,14 +4,43 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"quidque.no/ow-vpn-shared/iprange"
 	"quidque.no/ow2-ip-puller/internal/regions"
 )
 
 // Default output directory
 const DefaultOutputDir = "ips"
 
+// VersionFileName is written last into every snapshot
+const VersionFileName = "IP_version.txt"
+
+// Suffixes of the sibling directories used while replacing the output directory
+const (
+	stagingSuffix  = ".staging"
+	previousSuffix = ".previous"
+)
+
+// Snapshot is the complete content of the output directory after an update
+type Snapshot struct {
+	Version string
+	Regions map[regions.Region][]string
+
+	// Source describes where the lists came from, for the file headers
+	Source string
+
+	// Keep lists regions whose current file is carried over unchanged, for example
+	// because they failed to download
+	Keep []regions.Region
+
+	// KeepVersion carries over the current version file instead of writing Version, so
+	// the next run does not consider the update done
+	KeepVersion bool
+}
+
 // CreateOutputDirectory creates the output directory
 func CreateOutputDirectory(dirName string) error {
 	if dirName == "" {
@@ -20,64 +49,366 @@ func CreateOutputDirectory(dirName string) error {
 	return os.MkdirAll(dirName, 0755)
 }
 
-// WriteIPsToFiles writes IPs to files by region using the default output directory
-func WriteIPsToFiles(ipsByRegion map[regions.Region][]string) {
-	WriteIPsToFilesWithDir(ipsByRegion, DefaultOutputDir)
+// ExistingRegions returns the regions that have a file in the output directory
+func ExistingRegions(dirName string) []regions.Region {
+	var existing []regions.Region
+	for _, region := range regions.Known {
+		if _, err := os.Stat(regionFilePath(dirName, region)); err == nil {
+			existing = append(existing, region)
+		}
+	}
+	return existing
 }
 
-// WriteIPsToFilesWithDir writes IPs to files by region using the specified output directory
-func WriteIPsToFilesWithDir(ipsByRegion map[regions.Region][]string, dirName string) {
+// WriteSnapshot replaces the output directory with a snapshot, see WriteFiles
+func WriteSnapshot(dirName string, snapshot Snapshot) error {
 	if dirName == "" {
 		dirName = DefaultOutputDir
 	}
 
-	for region, ips := range ipsByRegion {
-		// Skip unknown region
-		if region == regions.UNK {
+	files := make(map[string][]byte)
+	for _, region := range snapshot.Keep {
+		data, err := os.ReadFile(regionFilePath(dirName, region))
+		if os.IsNotExist(err) {
 			continue
 		}
+		if err != nil {
+			return fmt.Errorf("keeping previous %s list: %w", region, err)
+		}
+		files[regionFileName(region)] = data
+	}
+
+	var written []regions.Region
+	counts := make(map[regions.Region]int)
+	for _, region := range regions.Known {
+		ips := snapshot.Regions[region]
 
 		// Skip if no IPs in this region
 		if len(ips) == 0 {
 			continue
 		}
 
-		// Create the file content
-		content := createFileContent(ips)
+		sorted := sortedUnique(ips)
+		header := fileHeader{Region: region, Source: snapshot.Source, Version: snapshot.Version, Generated: time.Now().UTC(), Count: len(sorted)}
+		content := createFileContent(header, sorted)
+
+		// An unchanged list keeps its file, including the generation time, so the file
+		// only changes when the lists do
+		if current, err := os.ReadFile(regionFilePath(dirName, region)); err == nil && sameList(string(current), content) {
+			content = string(current)
+		}
+
+		files[regionFileName(region)] = []byte(content)
+		counts[region] = len(sorted)
+		written = append(written, region)
+	}
+
+	if snapshot.KeepVersion {
+		data, err := os.ReadFile(filepath.Join(dirName, VersionFileName))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("keeping previous version file: %w", err)
+		}
+		if err == nil {
+			files[VersionFileName] = data
+		}
+	} else {
+		files[VersionFileName] = []byte(snapshot.Version + "\n")
+	}
+
+	if err := WriteFiles(dirName, files); err != nil {
+		return err
+	}
+	for _, region := range written {
+		fmt.Printf("Successfully wrote %d IPs to %s\n", counts[region], regionFilePath(dirName, region))
+	}
+	return nil
+}
+
+// WriteFiles replaces the region lists and version file of the output directory with
+// files, keyed by file name. Every file is written to a staging directory first, the
+// version file last, and the staging directory is then renamed into place, so a file
+// never holds a mix of old and new lists. Between moving the current directory aside and
+// renaming the staging directory into place the output directory does not exist; readers
+// then find the old lists in the directory with the .previous suffix. Files in the
+// output directory that are not region files are carried over.
+func WriteFiles(dirName string, files map[string][]byte) error {
+	if _, err := RecoverInterruptedSwap(dirName); err != nil {
+		return err
+	}
+
+	staging := dirName + stagingSuffix
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("removing old staging directory: %w", err)
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return fmt.Errorf("creating staging directory: %w", err)
+	}
+
+	if err := stage(dirName, staging, files); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	if err := swap(dirName, staging); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	return nil
+}
+
+// ReadFiles returns the region lists and version file of the output directory, keyed by
+// file name
+func ReadFiles(dirName string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, name := range managedFileNames() {
+		data, err := os.ReadFile(filepath.Join(dirName, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// stage writes files into the staging directory
+func stage(dirName, staging string, files map[string][]byte) error {
+	if err := carryOverOtherFiles(dirName, staging); err != nil {
+		return err
+	}
+
+	for name, data := range files {
+		if name == VersionFileName {
+			continue
+		}
+		if err := writeFileSynced(filepath.Join(staging, name), data); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
 
-		// Write to file
-		writeRegionFile(region, content, dirName)
+	// The version file goes last: a staging directory with a version file is complete
+	if data, ok := files[VersionFileName]; ok {
+		if err := writeFileSynced(filepath.Join(staging, VersionFileName), data); err != nil {
+			return fmt.Errorf("writing version file: %w", err)
+		}
 	}
+	return nil
 }
 
-// createFileContent creates file content from IP list
-func createFileContent(ips []string) string {
-	// Remove duplicates
-	uniqueIPs := make(map[string]bool)
+// Windows refuses to rename a directory while another process, such as the sidecar
+// reading a list, has a file in it open. Those renames are retried with a doubling delay.
+const renameAttempts = 8
+
+// rename, renameBlocked and renameRetryDelay are replaced in tests
+var (
+	rename           = os.Rename
+	renameBlocked    = isSharingViolation
+	renameRetryDelay = 50 * time.Millisecond
+)
+
+// renameRetrying renames from to to, retrying while another process blocks the rename
+func renameRetrying(from, to string) error {
+	delay := renameRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := rename(from, to)
+		if err == nil || attempt == renameAttempts || !renameBlocked(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// swap moves the current directory aside, renames the staging directory into its place
+// and then removes the old one
+func swap(dirName, staging string) error {
+	previous := dirName + previousSuffix
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("removing old previous directory: %w", err)
+	}
+
+	hadCurrent := true
+	if err := renameRetrying(dirName, previous); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("moving current IP lists aside: %w", err)
+		}
+		hadCurrent = false
+	}
+
+	if err := renameRetrying(staging, dirName); err != nil {
+		if hadCurrent {
+			renameRetrying(previous, dirName)
+		}
+		return fmt.Errorf("moving new IP lists into place: %w", err)
+	}
+
+	if hadCurrent {
+		if err := os.RemoveAll(previous); err != nil {
+			fmt.Printf("Warning: Could not remove %s: %v\n", previous, err)
+		}
+	}
+	return nil
+}
+
+// RecoverInterruptedSwap restores the previous output directory when an earlier run was
+// stopped after moving it aside but before the new one was in place
+func RecoverInterruptedSwap(dirName string) (bool, error) {
+	previous := dirName + previousSuffix
+	if _, err := os.Stat(dirName); err == nil {
+		return false, nil
+	}
+	if _, err := os.Stat(previous); err != nil {
+		return false, nil
+	}
+
+	if err := renameRetrying(previous, dirName); err != nil {
+		return false, fmt.Errorf("restoring IP lists from interrupted update: %w", err)
+	}
+	fmt.Printf("Restored %s from an interrupted update\n", dirName)
+	return true, nil
+}
+
+// carryOverOtherFiles copies files that the puller does not manage into the staging directory
+func carryOverOtherFiles(dirName, staging string) error {
+	entries, err := os.ReadDir(dirName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading current IP lists: %w", err)
+	}
+
+	managed := make(map[string]bool)
+	for _, name := range managedFileNames() {
+		managed[name] = true
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || managed[entry.Name()] {
+			continue
+		}
+		if err := copyFile(filepath.Join(dirName, entry.Name()), filepath.Join(staging, entry.Name())); err != nil {
+			return fmt.Errorf("carrying over %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// fileHeader is written as comments at the top of every region file
+type fileHeader struct {
+	Region    regions.Region
+	Source    string
+	Version   string
+	Generated time.Time
+	Count     int
+}
+
+// generatedPrefix starts the only header line that changes between runs with the same lists
+const generatedPrefix = "# Generated: "
+
+// createFileContent writes the header followed by one range per line
+func createFileContent(header fileHeader, ips []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Overwatch server IP ranges: %s\n", header.Region)
+	fmt.Fprintf(&b, "# Source: %s\n", header.Source)
+	fmt.Fprintf(&b, "# Version: %s\n", header.Version)
+	fmt.Fprintf(&b, "%s%s\n", generatedPrefix, header.Generated.Format(time.RFC3339))
+	fmt.Fprintf(&b, "# Count: %d\n", header.Count)
 	for _, ip := range ips {
-		uniqueIPs[ip] = true
+		b.WriteString(ip)
+		b.WriteString("\n")
 	}
+	return b.String()
+}
 
-	// Convert back to slice
-	var uniqueIPsList []string
-	for ip := range uniqueIPs {
-		uniqueIPsList = append(uniqueIPsList, ip)
+// sortedUnique removes duplicates and orders ranges by address, so the same lists always
+// produce the same file
+func sortedUnique(ips []string) []string {
+	seen := make(map[string]bool, len(ips))
+	unique := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if !seen[ip] {
+			seen[ip] = true
+			unique = append(unique, ip)
+		}
 	}
 
-	// Create content
-	content := strings.Join(uniqueIPsList, "\n")
-	if len(content) > 0 && !strings.HasSuffix(content, "\n") {
-		content += "\n"
+	sort.SliceStable(unique, func(i, j int) bool {
+		a, errA := iprange.Parse(unique[i])
+		b, errB := iprange.Parse(unique[j])
+		switch {
+		case errA == nil && errB == nil:
+			if c := a.Compare(b); c != 0 {
+				return c < 0
+			}
+			return unique[i] < unique[j]
+		case errA == nil:
+			return true
+		case errB == nil:
+			return false
+		}
+		return unique[i] < unique[j]
+	})
+	return unique
+}
+
+// sameList compares two region files, ignoring the generation time
+func sameList(a, b string) bool {
+	return withoutGenerated(a) == withoutGenerated(b)
+}
+
+func withoutGenerated(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, generatedPrefix) {
+			kept = append(kept, line)
+		}
 	}
-	return content
+	return strings.Join(kept, "")
 }
 
-// writeRegionFile writes the region file
-func writeRegionFile(region regions.Region, content string, dirName string) {
-	filename := filepath.Join(dirName, fmt.Sprintf("%s.txt", region))
-	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
-		fmt.Printf("Error writing to file %s: %v\n", filename, err)
-	} else {
-		fmt.Printf("Successfully wrote %d IPs to %s\n", strings.Count(content, "\n"), filename)
+// managedFileNames are the files an update replaces: every region list and the version file
+func managedFileNames() []string {
+	names := []string{VersionFileName}
+	for _, region := range regions.Known {
+		names = append(names, regionFileName(region))
+	}
+	return names
+}
+
+func regionFileName(region regions.Region) string {
+	return fmt.Sprintf("%s.txt", region)
+}
+
+func regionFilePath(dirName string, region regions.Region) string {
+	return filepath.Join(dirName, regionFileName(region))
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(to, data)
+}
+
+// writeFileSynced flushes the file to disk, so the rename that publishes it cannot
+// overtake its content after a crash
+func writeFileSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
 	}
+	return file.Close()
 }
@@ -0,0 +1,101 @@
+package output
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var errBlocked = errors.New("file in use")
+
+// blockRenames makes the next count renames fail as if another process had a file open
+// and returns a pointer to the number of renames attempted
+func blockRenames(t *testing.T, count int) *int {
+	t.Helper()
+	attempts := 0
+	rename = func(from, to string) error {
+		attempts++
+		if attempts <= count {
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: errBlocked}
+		}
+		return os.Rename(from, to)
+	}
+	renameBlocked = func(err error) bool { return errors.Is(err, errBlocked) }
+	renameRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		rename = os.Rename
+		renameBlocked = isSharingViolation
+		renameRetryDelay = 50 * time.Millisecond
+	})
+	return &attempts
+}
+
+func writeVersion(t *testing.T, dir, version string) {
+	t.Helper()
+	if err := WriteFiles(dir, map[string][]byte{VersionFileName: []byte(version + "\n"), "EU.txt": []byte("1.2.3.0/24\n")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readVersion(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, VersionFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteFilesRetriesBlockedRenames(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ips")
+	writeVersion(t, dir, "1.0")
+
+	attempts := blockRenames(t, 3)
+	writeVersion(t, dir, "1.1")
+
+	if got := readVersion(t, dir); got != "1.1\n" {
+		t.Errorf("version = %q, want 1.1", got)
+	}
+	// Three blocked attempts, then moving the current lists aside and the new ones in
+	if *attempts != 5 {
+		t.Errorf("renames = %d, want 5", *attempts)
+	}
+	if _, err := os.Stat(dir + previousSuffix); !os.IsNotExist(err) {
+		t.Errorf("previous directory left behind: %v", err)
+	}
+}
+
+func TestWriteFilesGivesUpOnPersistentBlock(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ips")
+	writeVersion(t, dir, "1.0")
+
+	blockRenames(t, renameAttempts)
+	err := WriteFiles(dir, map[string][]byte{VersionFileName: []byte("1.1\n")})
+	if !errors.Is(err, errBlocked) {
+		t.Fatalf("WriteFiles error = %v, want the blocked rename", err)
+	}
+	if got := readVersion(t, dir); got != "1.0\n" {
+		t.Errorf("version = %q, want the old lists kept", got)
+	}
+	if _, err := os.Stat(dir + stagingSuffix); !os.IsNotExist(err) {
+		t.Errorf("staging directory left behind: %v", err)
+	}
+}
+
+func TestRecoverInterruptedSwap(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ips")
+	writeVersion(t, dir, "1.0")
+	if err := os.Rename(dir, dir+previousSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := RecoverInterruptedSwap(dir)
+	if err != nil || !restored {
+		t.Fatalf("RecoverInterruptedSwap = %v, %v", restored, err)
+	}
+	if got := readVersion(t, dir); got != "1.0\n" {
+		t.Errorf("version = %q, want 1.0", got)
+	}
+}
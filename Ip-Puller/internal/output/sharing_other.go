@@ -0,0 +1,9 @@
+//go:build !windows
+
+package output
+
+// isSharingViolation reports whether err means another process has the file open. Other
+// systems allow renaming open files and directories.
+func isSharingViolation(err error) bool {
+	return false
+}
@@ -0,0 +1,20 @@
+package output
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes for a file or directory another process has open
+const (
+	errorSharingViolation = syscall.Errno(32)
+	errorLockViolation    = syscall.Errno(33)
+)
+
+// isSharingViolation reports whether err means another process has the file or a file
+// in the directory open. Renaming a directory with an open file fails with access denied.
+func isSharingViolation(err error) bool {
+	return errors.Is(err, errorSharingViolation) ||
+		errors.Is(err, errorLockViolation) ||
+		errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+}
@@ -18,6 +18,9 @@ const (
 	UNK Region = "Unknown" // Unknown
 )
 
+// Known lists every region the lists are split into, in the order files are fetched
+var Known = []Region{EU, NA, SA, AFR, AS, ME, OCE}
+
 // Prefix represents an IP prefix with country code
 type Prefix struct {
 	Prefix      string
@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PinName is the file in the snapshot directory that records a rollback
+const PinName = "pin.json"
+
+// Pin keeps the IP lists at a rolled back version. Updates leave the lists alone while
+// it exists, so a forced update does not undo the rollback.
+type Pin struct {
+	Version  string    `json:"version"`
+	ID       string    `json:"id"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// Pin records that the IP lists were rolled back to snapshot
+func (s *Store) Pin(snapshot *Snapshot) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("creating snapshot directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(Pin{Version: snapshot.Version, ID: snapshot.ID, PinnedAt: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding pin: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, PinName), data, 0644); err != nil {
+		return fmt.Errorf("writing pin: %w", err)
+	}
+	return nil
+}
+
+// Pinned returns the pin, or nil when the IP lists are not pinned
+func (s *Store) Pinned() (*Pin, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, PinName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading pin: %w", err)
+	}
+
+	var pin Pin
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return nil, fmt.Errorf("reading pin %s: %w", filepath.Join(s.Dir, PinName), err)
+	}
+	return &pin, nil
+}
+
+// Unpin removes the pin and reports whether there was one
+func (s *Store) Unpin() (bool, error) {
+	err := os.Remove(filepath.Join(s.Dir, PinName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("removing pin: %w", err)
+	}
+	return true, nil
+}
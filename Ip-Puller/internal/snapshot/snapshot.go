@@ -0,0 +1,243 @@
+// Package snapshot keeps copies of previous IP list versions, so a bad upstream list can
+// be rolled back without network access
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestName describes the snapshot inside every snapshot directory
+	ManifestName = "manifest.json"
+
+	// DefaultKeep is how many snapshots are kept by default
+	DefaultKeep = 5
+)
+
+// File is one IP list file in a snapshot
+type File struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// Manifest describes a snapshot
+type Manifest struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Source    string    `json:"source"`
+	Files     []File    `json:"files"`
+}
+
+// Snapshot is a stored copy of the IP lists
+type Snapshot struct {
+	Manifest
+	// ID is the name of the snapshot directory
+	ID string `json:"id"`
+	// Pinned is set by listings for the snapshot the IP lists are pinned to
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// Store keeps the newest Keep snapshots in Dir
+type Store struct {
+	Dir  string
+	Keep int
+}
+
+// unsafeChars are replaced in versions used as directory names
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Save stores files as a snapshot of version. Nothing is stored when the newest snapshot
+// already has the same version and content; that snapshot is returned with false.
+func (s *Store) Save(version, source string, files map[string][]byte) (*Snapshot, bool, error) {
+	manifest := Manifest{
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+		Source:    source,
+		Files:     describe(files),
+	}
+
+	existing, err := s.List()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(existing) > 0 && existing[0].Version == version && sameFiles(existing[0].Files, manifest.Files) {
+		return &existing[0], false, nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, false, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+
+	// Two snapshots of a version within a millisecond get a counter instead of sharing
+	// a directory
+	base := fmt.Sprintf("%s_%s", unsafeChars.ReplaceAllString(version, "_"), manifest.CreatedAt.Format("20060102-150405.000"))
+	id := base
+	for n := 2; ; n++ {
+		err := os.Mkdir(filepath.Join(s.Dir, id), 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, false, fmt.Errorf("creating snapshot directory: %w", err)
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	dir := filepath.Join(s.Dir, id)
+
+	for name, data := range files {
+		if !validFileName(name) {
+			os.RemoveAll(dir)
+			return nil, false, fmt.Errorf("invalid snapshot file name '%s'", name)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			os.RemoveAll(dir)
+			return nil, false, fmt.Errorf("writing snapshot file %s: %w", name, err)
+		}
+	}
+
+	// The manifest goes last, a directory without one is an incomplete snapshot
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, false, fmt.Errorf("encoding snapshot manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestName), data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, false, fmt.Errorf("writing snapshot manifest: %w", err)
+	}
+
+	if err := s.prune(); err != nil {
+		fmt.Printf("Warning: Could not remove old snapshots: %v\n", err)
+	}
+	return &Snapshot{Manifest: manifest, ID: id}, true, nil
+}
+
+// List returns the stored snapshots, newest first
+func (s *Store) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot directory: %w", err)
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.Dir, entry.Name(), ManifestName))
+		if err != nil {
+			continue
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			fmt.Printf("Warning: Skipping snapshot %s: %v\n", entry.Name(), err)
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Manifest: manifest, ID: entry.Name()})
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Find returns the newest snapshot of a version, or the snapshot with that ID
+func (s *Store) Find(version string) (*Snapshot, error) {
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Version == version || snapshot.ID == version {
+			return &snapshot, nil
+		}
+	}
+	return nil, fmt.Errorf("no snapshot of version %s", version)
+}
+
+// Files reads the files of a snapshot, refusing it when a checksum does not match or the
+// manifest names a file outside the snapshot directory
+func (s *Store) Files(snapshot *Snapshot) (map[string][]byte, error) {
+	files := make(map[string][]byte, len(snapshot.Files))
+	for _, file := range snapshot.Files {
+		if !validFileName(file.Name) {
+			return nil, fmt.Errorf("snapshot %s names an invalid file '%s'", snapshot.ID, file.Name)
+		}
+		data, err := os.ReadFile(filepath.Join(s.Dir, snapshot.ID, file.Name))
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot file %s: %w", file.Name, err)
+		}
+		if sum := checksum(data); sum != file.SHA256 {
+			return nil, fmt.Errorf("snapshot file %s is corrupt: SHA-256 %s, expected %s", file.Name, sum, file.SHA256)
+		}
+		files[file.Name] = data
+	}
+	return files, nil
+}
+
+// prune removes the oldest snapshots beyond Keep
+func (s *Store) prune() error {
+	if s.Keep <= 0 {
+		return nil
+	}
+
+	snapshots, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots[min(s.Keep, len(snapshots)):] {
+		if err := os.RemoveAll(filepath.Join(s.Dir, snapshot.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describe lists files ordered by name with their checksums
+func describe(files map[string][]byte) []File {
+	described := make([]File, 0, len(files))
+	for name, data := range files {
+		described = append(described, File{Name: name, SHA256: checksum(data), Size: len(data)})
+	}
+	sort.Slice(described, func(i, j int) bool {
+		return described[i].Name < described[j].Name
+	})
+	return described
+}
+
+// validFileName accepts a single base name, so a file stays inside its snapshot directory
+// with either path separator
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\:`)
+}
+
+func sameFiles(a, b []File) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,161 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lists(version, eu string) map[string][]byte {
+	return map[string][]byte{
+		"IP_version.txt": []byte(version + "\n"),
+		"EU.txt":         []byte(eu),
+	}
+}
+
+func save(t *testing.T, store *Store, version, eu string) (*Snapshot, bool) {
+	t.Helper()
+	snap, saved, err := store.Save(version, "test", lists(version, eu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap, saved
+}
+
+func TestSaveAndRestore(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), Keep: DefaultKeep}
+	save(t, store, "1.0", "1.2.3.0/24\n")
+	save(t, store, "1.1", "5.6.7.0/24\n")
+
+	snap, err := store.Find("1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := store.Files(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, lists("1.0", "1.2.3.0/24\n")) {
+		t.Errorf("files of 1.0 = %q", files)
+	}
+
+	// Snapshots are found by ID as well
+	if byID, err := store.Find(snap.ID); err != nil || byID.Version != "1.0" {
+		t.Errorf("Find(%s) = %+v, %v", snap.ID, byID, err)
+	}
+	if _, err := store.Find("9.9"); err == nil {
+		t.Error("Find of an unknown version succeeded")
+	}
+}
+
+func TestSaveSkipsSameContent(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), Keep: DefaultKeep}
+	first, saved := save(t, store, "1.0", "1.2.3.0/24\n")
+	if !saved {
+		t.Fatal("first Save stored nothing")
+	}
+
+	again, saved := save(t, store, "1.0", "1.2.3.0/24\n")
+	if saved || again.ID != first.ID {
+		t.Errorf("Save of the same lists = %s, %v, want %s not saved again", again.ID, saved, first.ID)
+	}
+
+	// The same version with different lists is a new snapshot
+	if _, saved := save(t, store, "1.0", "5.6.7.0/24\n"); !saved {
+		t.Error("Save of changed lists stored nothing")
+	}
+	if snapshots, _ := store.List(); len(snapshots) != 2 {
+		t.Errorf("snapshots = %d, want 2", len(snapshots))
+	}
+}
+
+func TestSavePrunesToKeep(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), Keep: 2}
+	for _, version := range []string{"1", "2", "3", "4"} {
+		save(t, store, version, version+".0.0.0/8\n")
+	}
+
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, snap := range snapshots {
+		versions = append(versions, snap.Version)
+	}
+	if !reflect.DeepEqual(versions, []string{"4", "3"}) {
+		t.Errorf("kept versions = %v, want the newest two", versions)
+	}
+	if entries, _ := os.ReadDir(store.Dir); len(entries) != 2 {
+		t.Errorf("snapshot directory holds %d entries, want 2", len(entries))
+	}
+}
+
+func TestFilesRejectsCorruptSnapshot(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), Keep: DefaultKeep}
+	snap, _ := save(t, store, "1.0", "1.2.3.0/24\n")
+
+	if err := os.WriteFile(filepath.Join(store.Dir, snap.ID, "EU.txt"), []byte("6.6.6.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Files(snap); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("Files of a changed snapshot = %v, want a checksum error", err)
+	}
+}
+
+func TestFilesRejectsNamesOutsideTheSnapshot(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), Keep: DefaultKeep}
+	snap, _ := save(t, store, "1.0", "1.2.3.0/24\n")
+
+	// A file next to the snapshot directory that a manifest could point at
+	outside := []byte("secret\n")
+	if err := os.WriteFile(filepath.Join(store.Dir, "outside.txt"), outside, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../outside.txt", `..\outside.txt`, "..", "", "C:outside.txt"} {
+		forged := *snap
+		forged.Files = []File{{Name: name, SHA256: checksum(outside), Size: len(outside)}}
+		if _, err := store.Files(&forged); err == nil || !strings.Contains(err.Error(), "invalid file") {
+			t.Errorf("Files with %q = %v, want it rejected", name, err)
+		}
+	}
+
+	if _, _, err := store.Save("2.0", "test", map[string][]byte{"../EU.txt": []byte("x")}); err == nil {
+		t.Error("Save of a file outside the snapshot succeeded")
+	}
+}
+
+func TestPinAndUnpin(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), Keep: DefaultKeep}
+	if pin, err := store.Pinned(); err != nil || pin != nil {
+		t.Fatalf("Pinned before pinning = %+v, %v", pin, err)
+	}
+
+	snap, _ := save(t, store, "1.0", "1.2.3.0/24\n")
+	if err := store.Pin(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	pin, err := store.Pinned()
+	if err != nil || pin == nil || pin.Version != "1.0" || pin.ID != snap.ID || pin.PinnedAt.IsZero() {
+		t.Fatalf("Pinned = %+v, %v", pin, err)
+	}
+
+	// The pin file is not taken for a snapshot
+	if snapshots, _ := store.List(); len(snapshots) != 1 {
+		t.Errorf("snapshots = %d, want 1", len(snapshots))
+	}
+
+	if removed, err := store.Unpin(); err != nil || !removed {
+		t.Errorf("Unpin = %v, %v", removed, err)
+	}
+	if pin, err := store.Pinned(); err != nil || pin != nil {
+		t.Errorf("Pinned after Unpin = %+v, %v", pin, err)
+	}
+	if removed, err := store.Unpin(); err != nil || removed {
+		t.Errorf("second Unpin = %v, %v, want nothing to remove", removed, err)
+	}
+}
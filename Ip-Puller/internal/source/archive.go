@@ -0,0 +1,69 @@
+package source
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+)
+
+// Archive reads a zip file shipped with the application, laid out like a Dir source.
+// Files may sit in a subdirectory of the archive; only their names are used.
+type Archive struct {
+	Path string
+}
+
+func (a *Archive) Name() string {
+	return fmt.Sprintf("archive (%s)", a.Path)
+}
+
+func (a *Archive) Version() (string, error) {
+	files, err := a.read()
+	if err != nil {
+		return "", err
+	}
+	content, ok := files[VersionFileName]
+	if !ok {
+		return "", fmt.Errorf("%s: no %s found", a.Path, VersionFileName)
+	}
+	return parseVersion(content)
+}
+
+func (a *Archive) Fetch() (*Result, error) {
+	files, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := categorizeFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a.Path, err)
+	}
+	return result, nil
+}
+
+func (a *Archive) read() (map[string]string, error) {
+	reader, err := zip.OpenReader(a.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive: %w", err)
+	}
+	defer reader.Close()
+
+	files := make(map[string]string)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from archive: %w", file.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from archive: %w", file.Name, err)
+		}
+		files[baseName(file.Name)] = string(content)
+	}
+	return files, nil
+}
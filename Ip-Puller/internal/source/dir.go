@@ -0,0 +1,50 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir reads a local directory holding IP_version.txt and the IP files, either in the
+// upstream naming or as <region>.txt, so teams can work offline
+type Dir struct {
+	Path string
+}
+
+func (d *Dir) Name() string {
+	return fmt.Sprintf("directory (%s)", d.Path)
+}
+
+func (d *Dir) Version() (string, error) {
+	content, err := os.ReadFile(filepath.Join(d.Path, VersionFileName))
+	if err != nil {
+		return "", fmt.Errorf("error reading version file: %w", err)
+	}
+	return parseVersion(string(content))
+}
+
+func (d *Dir) Fetch() (*Result, error) {
+	entries, err := os.ReadDir(d.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading IP directory: %w", err)
+	}
+
+	files := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(d.Path, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", entry.Name(), err)
+		}
+		files[entry.Name()] = string(content)
+	}
+
+	result, err := categorizeFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.Path, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"quidque.no/ow2-ip-puller/internal/httpcache"
+	"quidque.no/ow2-ip-puller/internal/regions"
+)
+
+const (
+	// GitHubBaseURL is the ip_lists directory of the upstream Overwatch Server Selector repository
+	GitHubBaseURL = "https://raw.githubusercontent.com/foryVERX/Overwatch-Server-Selector/main/ip_lists"
+
+	urlsContainerName = "urlsContainer.txt"
+)
+
+// GitHub reads the upstream repository, where urlsContainer.txt lists the URL of every
+// IP file. Relative entries are resolved against BaseURL.
+type GitHub struct {
+	HTTP    *httpcache.Cache
+	BaseURL string
+}
+
+func (g *GitHub) Name() string {
+	return fmt.Sprintf("GitHub (%s)", g.BaseURL)
+}
+
+func (g *GitHub) Version() (string, error) {
+	content, err := g.HTTP.Get(joinURL(g.BaseURL, VersionFileName))
+	if err != nil {
+		return "", fmt.Errorf("error fetching version file: %w", err)
+	}
+	return parseVersion(content)
+}
+
+func (g *GitHub) Fetch() (*Result, error) {
+	urlsContent, err := g.HTTP.Get(joinURL(g.BaseURL, urlsContainerName))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching URLs container: %w", err)
+	}
+
+	version, err := g.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, url := range parseURLs(urlsContent) {
+		if !strings.Contains(url, "://") {
+			url = joinURL(g.BaseURL, url)
+		}
+		if isIPFileName(getFilenameFromURL(url)) {
+			urls = append(urls, url)
+		}
+	}
+
+	result := &Result{Version: version, Regions: make(map[regions.Region][]string)}
+	for _, fetched := range fetchRegions(g.HTTP, urls) {
+		if fetched.region != regions.UNK {
+			result.addFetched(fetched)
+		}
+	}
+
+	return result, nil
+}
+
+type fetchResult struct {
+	url    string
+	region regions.Region
+	ips    []string
+	err    error
+}
+
+// fetchRegions downloads the IP files of known regions concurrently. Results come back in
+// the order of urls, so ranges are appended in the same order on every run.
+func fetchRegions(cache *httpcache.Cache, urls []string) []fetchResult {
+	results := make([]fetchResult, len(urls))
+	semaphore := make(chan struct{}, MaxConcurrentFetch)
+	var wg sync.WaitGroup
+
+	for i, url := range urls {
+		region := regionFromFileName(getFilenameFromURL(url))
+		results[i] = fetchResult{url: url, region: region}
+		if region == regions.UNK {
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *fetchResult) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			content, err := cache.Get(result.url)
+			if err != nil {
+				result.err = fmt.Errorf("error fetching from %s: %w", result.url, err)
+				return
+			}
+			result.ips = parseIPs(content)
+		}(&results[i])
+	}
+
+	wg.Wait()
+	return results
+}
+
+func parseURLs(content string) []string {
+	lines := strings.Split(content, "\n")
+	result := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,56 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"quidque.no/ow2-ip-puller/internal/httpcache"
+	"quidque.no/ow2-ip-puller/internal/regions"
+)
+
+// Mirror reads a web server that serves the puller's own output layout: IP_version.txt
+// and one <region>.txt per region, for example a copy of the ips directory. Regions the
+// mirror does not have (404) are skipped.
+type Mirror struct {
+	HTTP    *httpcache.Cache
+	BaseURL string
+}
+
+func (m *Mirror) Name() string {
+	return fmt.Sprintf("mirror (%s)", m.BaseURL)
+}
+
+func (m *Mirror) Version() (string, error) {
+	content, err := m.HTTP.Get(joinURL(m.BaseURL, VersionFileName))
+	if err != nil {
+		return "", fmt.Errorf("error fetching version file: %w", err)
+	}
+	return parseVersion(content)
+}
+
+func (m *Mirror) Fetch() (*Result, error) {
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(regions.Known))
+	for _, region := range regions.Known {
+		urls = append(urls, joinURL(m.BaseURL, string(region)+".txt"))
+	}
+
+	result := &Result{Version: version, Regions: make(map[regions.Region][]string)}
+	for _, fetched := range fetchRegions(m.HTTP, urls) {
+		var status *httpcache.StatusError
+		if errors.As(fetched.err, &status) && status.Code == http.StatusNotFound {
+			continue
+		}
+		result.addFetched(fetched)
+	}
+
+	if len(result.Regions) == 0 && len(result.Failed) == 0 {
+		return nil, fmt.Errorf("mirror %s has no region files", m.BaseURL)
+	}
+	return result, nil
+}
@@ -0,0 +1,291 @@
+// Package source fetches the Overwatch server IP lists. Every source returns the same
+// categorized ranges and version, so the puller can read from GitHub, a self-hosted
+// mirror, a local directory or a bundled archive.
+package source
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"quidque.no/ow2-ip-puller/internal/httpcache"
+	"quidque.no/ow2-ip-puller/internal/regions"
+)
+
+const (
+	TimeoutSeconds     = 30
+	MaxConcurrentFetch = 10
+
+	// VersionFileName holds the list version in every source layout
+	VersionFileName = "IP_version.txt"
+)
+
+// Source kinds accepted by New
+const (
+	KindGitHub  = "github"
+	KindDir     = "dir"
+	KindMirror  = "mirror"
+	KindArchive = "archive"
+)
+
+// Kinds lists every source kind, in the order shown in help texts
+var Kinds = []string{KindGitHub, KindDir, KindMirror, KindArchive}
+
+// Source provides the IP lists and their version
+type Source interface {
+	// Name describes the source in log output, e.g. "GitHub (https://...)"
+	Name() string
+	// Version returns the version of the lists without fetching them
+	Version() (string, error)
+	// Fetch returns the lists categorized by region
+	Fetch() (*Result, error)
+}
+
+// Result is a set of IP lists. Failed holds the regions that could not be fetched
+// completely; their entry in Regions, if any, only has the files that did arrive.
+type Result struct {
+	Version string
+	Regions map[regions.Region][]string
+	Failed  map[regions.Region]error
+}
+
+// addFetched adds the outcome of one region file download
+func (r *Result) addFetched(fetched fetchResult) {
+	if fetched.err != nil {
+		if r.Failed == nil {
+			r.Failed = make(map[regions.Region]error)
+		}
+		r.Failed[fetched.region] = errors.Join(r.Failed[fetched.region], fetched.err)
+		return
+	}
+
+	if len(fetched.ips) > 0 {
+		r.Regions[fetched.region] = append(r.Regions[fetched.region], fetched.ips...)
+	}
+}
+
+// New creates the source of the given kind. location is the base URL for github and
+// mirror, and a path for dir and archive; github falls back to the upstream repository.
+// The HTTP sources download through cache.
+func New(kind, location string, cache *httpcache.Cache) (Source, error) {
+	switch kind {
+	case KindGitHub, "":
+		if location == "" {
+			location = GitHubBaseURL
+		}
+		return &GitHub{HTTP: cache, BaseURL: location}, nil
+	case KindMirror:
+		if location == "" {
+			return nil, errors.New("the mirror source needs a base URL")
+		}
+		return &Mirror{HTTP: cache, BaseURL: location}, nil
+	case KindDir:
+		if location == "" {
+			return nil, errors.New("the dir source needs a directory")
+		}
+		return &Dir{Path: location}, nil
+	case KindArchive:
+		if location == "" {
+			return nil, errors.New("the archive source needs a zip file")
+		}
+		return &Archive{Path: location}, nil
+	default:
+		return nil, fmt.Errorf("unknown source '%s' (use %s)", kind, strings.Join(Kinds, ", "))
+	}
+}
+
+// NewHTTPClient returns the client the HTTP sources download with
+func NewHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: time.Duration(TimeoutSeconds) * time.Second,
+	}
+}
+
+// joinURL appends a file name to a base URL, with or without a trailing slash
+func joinURL(base, name string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.ReplaceAll(name, " ", "%20")
+}
+
+// parseVersion trims a version file and rejects an empty one
+func parseVersion(content string) (string, error) {
+	version := strings.TrimSpace(content)
+	if version == "" {
+		return "", fmt.Errorf("empty version number received")
+	}
+	return version, nil
+}
+
+// categorizeFiles builds a result from the files of a directory or archive, keyed by
+// file name. Files that are not IP lists of a known region are skipped.
+func categorizeFiles(files map[string]string) (*Result, error) {
+	content, ok := files[VersionFileName]
+	if !ok {
+		return nil, fmt.Errorf("no %s found", VersionFileName)
+	}
+	version, err := parseVersion(content)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := &Result{Version: version, Regions: make(map[regions.Region][]string)}
+	for _, name := range names {
+		if !isIPFileName(name) {
+			continue
+		}
+		region := regionFromFileName(name)
+		if region == regions.UNK {
+			continue
+		}
+		if ips := parseIPs(files[name]); len(ips) > 0 {
+			result.Regions[region] = append(result.Regions[region], ips...)
+		}
+	}
+	return result, nil
+}
+
+func getFilenameFromURL(url string) string {
+	parts := strings.Split(url, "/")
+	filename := parts[len(parts)-1]
+	return strings.ReplaceAll(filename, "%20", " ")
+}
+
+func isIPFileName(filename string) bool {
+	return strings.HasSuffix(filename, ".txt") &&
+		!strings.Contains(filename, "BlockingConfig") &&
+		!strings.Contains(filename, "IP_version") &&
+		!strings.Contains(filename, "pinglist") &&
+		!strings.Contains(filename, "urlsContainer")
+}
+
+// regionFromFileName recognizes both the upstream file names and the <region>.txt files
+// the puller writes, so a puller output directory can itself serve as a source
+func regionFromFileName(filename string) regions.Region {
+	for _, region := range regions.Known {
+		if strings.EqualFold(filename, string(region)+".txt") {
+			return region
+		}
+	}
+
+	if strings.Contains(filename, "Ip_ranges_EU") {
+		return regions.EU
+	} else if strings.Contains(filename, "Ip_ranges_NA_") {
+		return regions.NA
+	} else if strings.Contains(filename, "Ip_ranges_Brazil") ||
+		strings.Contains(filename, "Ip_ranges_SA") {
+		return regions.SA
+	} else if strings.Contains(filename, "Ip_ranges_AS_") ||
+		strings.Contains(filename, "Ip_ranges_AS") {
+		return regions.AS
+	} else if strings.Contains(filename, "Ip_ranges_ME") {
+		return regions.ME
+	} else if strings.Contains(filename, "Ip_ranges_Australia") ||
+		strings.Contains(filename, "Ip_ranges_OCE") ||
+		strings.Contains(filename, "Ip_ranges_Oce") {
+		return regions.OCE
+	} else if strings.Contains(filename, "Ip_ranges_AFR") ||
+		strings.Contains(filename, "Ip_ranges_Afr") {
+		return regions.AFR
+	}
+
+	if strings.Contains(filename, "cfg - EU") {
+		return regions.EU
+	} else if strings.Contains(filename, "cfg - NA") {
+		return regions.NA
+	} else if strings.Contains(filename, "cfg - Other - Brazil") {
+		return regions.SA
+	} else if strings.Contains(filename, "cfg - Asia") {
+		return regions.AS
+	} else if strings.Contains(filename, "cfg - Other - Bahrain") ||
+		strings.Contains(filename, "cfg - Other - KSA") ||
+		strings.Contains(filename, "cfg - Other - Qatar") {
+		return regions.ME
+	} else if strings.Contains(filename, "cfg - Other - Australia") {
+		return regions.OCE
+	}
+
+	return regions.UNK
+}
+
+func parseIPs(content string) []string {
+	lines := strings.Split(content, "\n")
+	result := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if looksLikeIPRange(line) {
+			result = append(result, normalizeIPRange(line))
+		}
+	}
+
+	return result
+}
+
+func looksLikeIPRange(line string) bool {
+	if strings.Contains(line, "ipRangeName") {
+		return false
+	}
+	if strings.Contains(line, ".") {
+		return strings.Contains(line, "/") ||
+			strings.Contains(line, "-") ||
+			!strings.Contains(line, " ")
+	}
+	return looksLikeIPv6Range(line)
+}
+
+// looksLikeIPv6Range accepts lines made only of the characters of IPv6 ranges, since a
+// colon alone also matches "key: value" lines
+func looksLikeIPv6Range(line string) bool {
+	if !strings.Contains(line, ":") {
+		return false
+	}
+	for _, r := range line {
+		if !strings.ContainsRune("0123456789abcdefABCDEF:/- ", r) {
+			return false
+		}
+	}
+	return !strings.Contains(line, " ") || strings.Contains(line, "-")
+}
+
+func normalizeIPRange(ipRange string) string {
+	// Already has subnet mask
+	if strings.Contains(ipRange, "/") {
+		return ipRange
+	}
+
+	// IP range with dash
+	if strings.Contains(ipRange, "-") {
+		return ipRange
+	}
+
+	// Single IP - add /32 subnet mask
+	if strings.Count(ipRange, ".") == 3 && !strings.Contains(ipRange, ":") {
+		return ipRange + "/32"
+	}
+
+	// Single IPv6 address - add /128
+	if addr, err := netip.ParseAddr(ipRange); err == nil && addr.Is6() {
+		return ipRange + "/128"
+	}
+
+	return ipRange
+}
+
+// baseName returns the last element of a slash separated archive or URL path
+func baseName(name string) string {
+	return path.Base(strings.ReplaceAll(name, `\`, "/"))
+}
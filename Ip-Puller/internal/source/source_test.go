@@ -0,0 +1,265 @@
+package source
+
+import (
+	"archive/zip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"quidque.no/ow2-ip-puller/internal/httpcache"
+	"quidque.no/ow2-ip-puller/internal/regions"
+)
+
+// fileServer serves files by URL path, answers paths in status with that status code
+// and every other path with 404. It records the paths that were requested.
+type fileServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	files     map[string]string
+	status    map[string]int
+	requested []string
+}
+
+func serveFiles(t *testing.T, files map[string]string, status map[string]int) *fileServer {
+	t.Helper()
+	server := &fileServer{files: files, status: status}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+
+		server.requested = append(server.requested, r.URL.Path)
+		if code, ok := server.status[r.URL.Path]; ok {
+			w.WriteHeader(code)
+			return
+		}
+		content, ok := server.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// set adds or replaces the file served at path
+func (s *fileServer) set(path, content string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files[path] = content
+}
+
+// newCache downloads without a cache directory and without retries
+func newCache(server *fileServer) *httpcache.Cache {
+	cache := httpcache.New("", server.Client())
+	cache.Retries = 0
+	return cache
+}
+
+const euList = "# Europe\n1.2.3.0/24\n\n5.6.7.8\n2001:db8::1\nipRangeName: EU\n10.0.0.1-10.0.0.9\n"
+
+var euRanges = []string{"1.2.3.0/24", "5.6.7.8/32", "2001:db8::1/128", "10.0.0.1-10.0.0.9"}
+
+func TestGitHubFetch(t *testing.T) {
+	server := serveFiles(t, map[string]string{
+		"/ip_lists/IP_version.txt":     "2.3\n",
+		"/ip_lists/Ip_ranges_EU.txt":   euList,
+		"/other/Ip_ranges_NA_East.txt": "8.8.8.0/24\n",
+		"/ip_lists/cfg - Asia.txt":     "9.9.9.9\n",
+	}, nil)
+	// One entry is absolute, so the container needs the address of the running server
+	server.set("/ip_lists/urlsContainer.txt", "Ip_ranges_EU.txt\n"+server.URL+"/other/Ip_ranges_NA_East.txt\ncfg - Asia.txt\nBlockingConfig.txt\nIp_ranges_Mars.txt\n")
+
+	source := &GitHub{HTTP: newCache(server), BaseURL: server.URL + "/ip_lists/"}
+
+	version, err := source.Version()
+	if err != nil || version != "2.3" {
+		t.Fatalf("Version() = %q, %v, want 2.3", version, err)
+	}
+
+	result, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Version != "2.3" || len(result.Failed) != 0 {
+		t.Errorf("result version %q, failed %v", result.Version, result.Failed)
+	}
+
+	want := map[regions.Region][]string{
+		regions.EU: euRanges,
+		regions.NA: {"8.8.8.0/24"},
+		regions.AS: {"9.9.9.9/32"},
+	}
+	if !reflect.DeepEqual(result.Regions, want) {
+		t.Errorf("regions = %v, want %v", result.Regions, want)
+	}
+
+	// Neither the config file nor the file of an unknown region is downloaded
+	for _, path := range server.requested {
+		if strings.Contains(path, "BlockingConfig") || strings.Contains(path, "Mars") {
+			t.Errorf("requested %s", path)
+		}
+	}
+}
+
+func TestGitHubFetchFailedRegion(t *testing.T) {
+	server := serveFiles(t, map[string]string{
+		"/IP_version.txt":    "2.3",
+		"/urlsContainer.txt": "Ip_ranges_EU.txt\nIp_ranges_NA_West.txt\n",
+		"/Ip_ranges_EU.txt":  "1.2.3.0/24\n",
+	}, nil)
+
+	result, err := (&GitHub{HTTP: newCache(server), BaseURL: server.URL}).Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status *httpcache.StatusError
+	if !errors.As(result.Failed[regions.NA], &status) || status.Code != http.StatusNotFound {
+		t.Errorf("NA failure = %v, want a 404 StatusError", result.Failed[regions.NA])
+	}
+	if !reflect.DeepEqual(result.Regions[regions.EU], []string{"1.2.3.0/24"}) {
+		t.Errorf("EU = %v", result.Regions[regions.EU])
+	}
+}
+
+func TestGitHubFetchWithoutContainer(t *testing.T) {
+	server := serveFiles(t, map[string]string{"/IP_version.txt": "2.3"}, nil)
+
+	if _, err := (&GitHub{HTTP: newCache(server), BaseURL: server.URL}).Fetch(); err == nil {
+		t.Error("Fetch without urlsContainer.txt succeeded")
+	}
+}
+
+func TestMirrorFetch(t *testing.T) {
+	server := serveFiles(t, map[string]string{
+		"/ips/IP_version.txt": "2.4",
+		"/ips/EU.txt":         euList,
+		"/ips/NA.txt":         "8.8.8.0/24\n",
+	}, map[string]int{
+		"/ips/ME.txt": http.StatusInternalServerError,
+	})
+
+	result, err := (&Mirror{HTTP: newCache(server), BaseURL: server.URL + "/ips"}).Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Version != "2.4" {
+		t.Errorf("version = %q, want 2.4", result.Version)
+	}
+
+	want := map[regions.Region][]string{regions.EU: euRanges, regions.NA: {"8.8.8.0/24"}}
+	if !reflect.DeepEqual(result.Regions, want) {
+		t.Errorf("regions = %v, want %v", result.Regions, want)
+	}
+
+	// Missing regions are skipped, server errors fail the region
+	if len(result.Failed) != 1 || result.Failed[regions.ME] == nil {
+		t.Errorf("failed = %v, want only ME", result.Failed)
+	}
+}
+
+func TestMirrorWithoutRegions(t *testing.T) {
+	server := serveFiles(t, map[string]string{"/IP_version.txt": "2.4"}, nil)
+
+	_, err := (&Mirror{HTTP: newCache(server), BaseURL: server.URL}).Fetch()
+	if err == nil || !strings.Contains(err.Error(), "no region files") {
+		t.Errorf("Fetch() error = %v, want no region files", err)
+	}
+}
+
+func TestDirFetch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"IP_version.txt":        "2.5\n",
+		"EU.txt":                euList,
+		"Ip_ranges_NA_West.txt": "8.8.8.0/24\n",
+		"notes.txt":             "1.1.1.1\n",
+		"pinglist.txt":          "2.2.2.2\n",
+		"sub/NA.txt":            "3.3.3.3\n",
+	})
+
+	source := &Dir{Path: dir}
+	if version, err := source.Version(); err != nil || version != "2.5" {
+		t.Fatalf("Version() = %q, %v, want 2.5", version, err)
+	}
+
+	result, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[regions.Region][]string{regions.EU: euRanges, regions.NA: {"8.8.8.0/24"}}
+	if !reflect.DeepEqual(result.Regions, want) {
+		t.Errorf("regions = %v, want %v", result.Regions, want)
+	}
+
+	if _, err := (&Dir{Path: filepath.Join(dir, "sub")}).Fetch(); err == nil || !strings.Contains(err.Error(), VersionFileName) {
+		t.Errorf("Fetch() without version file error = %v", err)
+	}
+}
+
+func TestArchiveFetch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ips.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	for name, content := range map[string]string{
+		"ips/IP_version.txt": "2.6\n",
+		"ips/EU.txt":         euList,
+		`ips\Oce.txt`:        "4.4.4.0/24\n",
+		"ips/readme.md":      "# lists\n",
+	} {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if _, err := archive.Create("ips/empty/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	source := &Archive{Path: path}
+	if version, err := source.Version(); err != nil || version != "2.6" {
+		t.Fatalf("Version() = %q, %v, want 2.6", version, err)
+	}
+
+	result, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[regions.Region][]string{regions.EU: euRanges, regions.OCE: {"4.4.4.0/24"}}
+	if !reflect.DeepEqual(result.Regions, want) {
+		t.Errorf("regions = %v, want %v", result.Regions, want)
+	}
+
+	if _, err := (&Archive{Path: filepath.Join(t.TempDir(), "missing.zip")}).Fetch(); err == nil {
+		t.Error("Fetch() of a missing archive succeeded")
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
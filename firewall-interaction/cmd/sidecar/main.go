@@ -7,12 +7,21 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 	"time"
 
+	"quidque.no/ow-firewall-sidecar/internal/api"
+	"quidque.no/ow-firewall-sidecar/internal/command"
 	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-firewall-sidecar/internal/control"
 	"quidque.no/ow-firewall-sidecar/internal/firewall"
+	"quidque.no/ow-firewall-sidecar/internal/process"
+	"quidque.no/ow-firewall-sidecar/internal/report"
+	"quidque.no/ow-firewall-sidecar/internal/watchdog"
+	"quidque.no/ow-vpn-shared/configstore"
+	"quidque.no/ow-vpn-shared/procwatch"
 )
 
 func main() {
@@ -22,17 +31,72 @@ func main() {
 		os.Exit(config.ExitErrorAdminRights)
 	}
 
-	action := flag.String("action", "", "Action to perform: block, unblock, unblock-all, status, set-path, get-path")
-	region := flag.String("region", "", "Region to block/unblock (EU, NA, AS, etc.)")
+	action := flag.String("action", "", "Action to perform: block, unblock, unblock-all, status, set-path, get-path, clear-path, set-persist, purge-all, discover, list, show, export, import, regions, history")
+	region := flag.String("region", "", "Region to block/unblock/show (EU, NA, AS, etc.), or the path for set-path and on/off for set-persist")
 	ipDir := flag.String("ip-dir", config.DefaultIPListDir, "Directory containing IP list files")
+	format := flag.String("format", "", "Output format for status, discover, list, show, export, import, regions and history: text, json, yaml")
+	file := flag.String("file", "", "Snapshot file to read for the import action")
+	edition := flag.String("edition", "", "With clear-path, the edition to forget: battlenet or steam (default: all)")
+	discoveryRoot := flag.String("discovery-root", "", "With discover, search a fixture directory laid out as <root>/<drive letter>/... instead of this PC")
+	purge := flag.Bool("purge", false, "With unblock-all, also turn persistent blocks off and forget the saved blocks")
+	controlAddr := flag.String("control", "", "Local control endpoint for daemon mode (named pipe on Windows, Unix socket elsewhere), 'off' to disable (default: "+defaultControlAddress("<namespace>")+")")
+	controlTokenFile := flag.String("control-token-file", config.DefaultControlTokenFile, "File the control endpoint access token is written to, relative to the config directory")
+	apiAddr := flag.String("api", "", "Serve the HTTP API in daemon mode on this loopback address, e.g. 127.0.0.1:8765")
+	apiTokenFile := flag.String("api-token-file", config.DefaultAPITokenFile, "File the HTTP API bearer token is read from or generated into, relative to the config directory")
+	backendName := flag.String("backend", firewall.BackendNetsh, "Firewall backend: netsh or memory")
+	configDir := flag.String("config-dir", "", "Directory holding config.json and the other state files (default: $OW_VPN_CONFIG_DIR or the per-user data directory)")
+	configScope := flag.String("config-scope", "", "Default config directory when -config-dir is not given: user or machine (default: $OW_VPN_CONFIG_SCOPE or user)")
+	namespace := flag.String("namespace", "", "Rule namespace to use instead of the one generated for this installation, e.g. for a separate profile")
+	heartbeatGrace := flag.Duration("heartbeat-grace", config.DefaultHeartbeatGrace, "Time without a heartbeat after which the daemon client is considered lost, 0 to disable")
+	watchProcess := flag.Bool("watch-process", true, "In daemon mode, watch for Overwatch starting and exiting and report it as game-started/game-exited events")
+	onClientLost := flag.String("on-client-lost", config.ClientLostUnblockAll, "What to do when the daemon client is lost: unblock-all or keep")
 	flag.Parse()
 
-	fw := firewall.New()
+	if *onClientLost != config.ClientLostUnblockAll && *onClientLost != config.ClientLostKeep {
+		fmt.Printf("ERROR: Unknown on-client-lost policy '%s'\n", *onClientLost)
+		flag.Usage()
+		os.Exit(config.ExitErrorInvalidArgs)
+	}
+
+	backend, err := firewall.NewBackend(*backendName)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(config.ExitErrorInvalidArgs)
+	}
+	store, err := openConfigStore(*configDir, *configScope)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(config.ExitErrorInvalidArgs)
+	}
+
+	fw := firewall.NewWithBackend(backend, store)
+	if *namespace != "" {
+		if err := fw.UseNamespace(*namespace); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			os.Exit(config.ExitErrorInvalidArgs)
+		}
+	}
+
+	if *controlAddr == "" {
+		*controlAddr = defaultControlAddress(fw.Namespace())
+	}
 
 	setupCleanupHandler(fw)
 
+	var purgeOption bool
+	*action, purgeOption = command.ParseOptions(*action)
+
 	if flag.Arg(0) == "daemon" {
-		runDaemonMode(fw, *ipDir)
+		runDaemonMode(fw, daemonOptions{
+			ipDir:            *ipDir,
+			controlAddr:      *controlAddr,
+			controlTokenFile: store.Path(*controlTokenFile),
+			apiAddr:          *apiAddr,
+			apiTokenFile:     store.Path(*apiTokenFile),
+			heartbeatGrace:   *heartbeatGrace,
+			onClientLost:     *onClientLost,
+			watchProcess:     *watchProcess,
+		})
 		return
 	}
 
@@ -42,13 +106,85 @@ func main() {
 		os.Exit(config.ExitErrorInvalidArgs)
 	}
 
-	if (*action == config.ActionBlock || *action == config.ActionUnblock) && *region == "" {
-		fmt.Println("ERROR: Region is required for block/unblock actions")
+	if command.TakesRegion(*action) && *region == "" {
+		fmt.Println("ERROR: Region is required for block/unblock/show actions")
 		flag.Usage()
 		os.Exit(config.ExitErrorInvalidArgs)
 	}
 
-	executeAction(fw, *action, *region, *ipDir)
+	req := command.Request{
+		Action: *action,
+		Purge:  *purge || purgeOption,
+		IPDir:  *ipDir,
+		Format: *format,
+	}
+	switch *action {
+	case config.ActionImport:
+		if *file == "" {
+			fmt.Println("ERROR: File is required for import action")
+			flag.Usage()
+			os.Exit(config.ExitErrorInvalidArgs)
+		}
+		req.Argument = *file
+	case config.ActionClearPath:
+		req.Argument = *edition
+	case config.ActionDiscover:
+		req.Argument = *discoveryRoot
+	case config.ActionSetPath, config.ActionSetPersist:
+		// These have always taken their value through -region
+		req.Argument = *region
+	default:
+		req.Region = *region
+	}
+
+	executeAction(fw, req)
+}
+
+// openConfigStore opens the shared config directory, importing a config.json left in the
+// working directory by older versions and upgrading it to the current schema
+func openConfigStore(dir, scope string) (*configstore.Store, error) {
+	resolved, err := configstore.ResolveDir(dir, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := configstore.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	if imported, err := store.ImportShared(); err != nil {
+		fmt.Printf("Warning: Failed to take over the config shared by earlier versions: %v\n", err)
+	} else if imported {
+		fmt.Printf("Moved the config shared by earlier versions into %s\n", store.Dir())
+	}
+
+	if legacy, err := filepath.Abs(configstore.FileName); err == nil && legacy != store.Path(configstore.FileName) {
+		imported, err := store.ImportLegacy(legacy)
+		if err != nil {
+			fmt.Printf("Warning: Failed to import %s: %v\n", legacy, err)
+		} else if imported {
+			fmt.Printf("Imported %s into %s\n", legacy, store.Dir())
+		}
+	}
+
+	migration, err := store.Migrate()
+	if err != nil {
+		return nil, fmt.Errorf("config in %s: %w", store.Dir(), err)
+	}
+	if migration.Migrated() {
+		fmt.Printf("Migrated config from schema %d to %d (backup: %s)\n", migration.From, migration.To, migration.Backup)
+	}
+
+	return store, nil
+}
+
+// defaultControlAddress returns the control endpoint of a rule namespace
+func defaultControlAddress(namespace string) string {
+	if runtime.GOOS == "windows" {
+		return config.ControlPipePrefix + namespace
+	}
+	return config.ControlSocketPrefix + namespace + ".sock"
 }
 
 func setupCleanupHandler(fw *firewall.Firewall) {
@@ -57,150 +193,241 @@ func setupCleanupHandler(fw *firewall.Firewall) {
 
 	go func() {
 		<-c
+		if fw.Persistent() {
+			fmt.Println("Shutting down, persistent blocks enabled, leaving firewall rules in place")
+			os.Exit(config.ExitSuccess)
+		}
 		fmt.Println("Shutting down, cleaning up firewall rules...")
 		fw.UnblockAll()
 		os.Exit(config.ExitSuccess)
 	}()
 }
 
-func runDaemonMode(fw *firewall.Firewall, ipDir string) {
+// stdinQueueSize is how many commands from the parent can wait while one runs before
+// reading stdin, and with it heartbeats, stalls
+const stdinQueueSize = 256
+
+type daemonOptions struct {
+	ipDir            string
+	controlAddr      string
+	controlTokenFile string
+	apiAddr          string
+	apiTokenFile     string
+	heartbeatGrace   time.Duration
+	onClientLost     string
+	watchProcess     bool
+}
+
+func runDaemonMode(fw *firewall.Firewall, opts daemonOptions) {
 	fmt.Println("Starting firewall sidecar daemon")
 
-	absIPDir, err := filepath.Abs(ipDir)
+	absIPDir, err := filepath.Abs(opts.ipDir)
 	if err != nil {
 		fmt.Printf("ERROR: Failed to resolve IP directory path: %v\n", err)
 		os.Exit(config.ExitErrorIPListRead)
 	}
 
+	var hub *control.Hub
+	hub = control.NewHub(func(source, line string) string {
+		return command.ExecuteDaemon(fw, hub, source, line, absIPDir)
+	})
+
+	// The watchdog is armed by the first heartbeat, so parents that never send one
+	// are only detected when they close stdin. Only the client that armed it keeps it
+	// alive, so a control client cannot hide that the parent stopped sending heartbeats.
+	heartbeat := watchdog.New(opts.heartbeatGrace,
+		func(silence time.Duration) {
+			handleClientLost(fw, hub, opts.onClientLost, fmt.Sprintf("no heartbeat for %s", silence.Round(time.Second)))
+		},
+		func(silence time.Duration) {
+			fmt.Printf("Watchdog: heartbeat resumed after %s\n", silence.Round(time.Second))
+		})
+	if opts.heartbeatGrace > 0 {
+		fmt.Printf("Watchdog: heartbeat grace period %s, on client lost: %s\n", opts.heartbeatGrace, opts.onClientLost)
+	}
+	hub.SetHeartbeat(heartbeat.Beat)
+
+	restorePersistentBlocks(fw, absIPDir)
+
+	// Events caused by any client are reported to the parent process as well
+	events, _ := hub.Subscribe()
 	go func() {
-		for {
-			if _, err := os.Stdin.Stat(); err != nil {
-				fmt.Println("Parent process closed connection, cleaning up...")
-				fw.UnblockAll()
-				fmt.Println("Cleanup completed, exiting...")
-				os.Exit(config.ExitSuccess)
-			}
-			time.Sleep(5 * time.Second)
+		for event := range events {
+			fmt.Println(event)
 		}
 	}()
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		command := strings.TrimSpace(scanner.Text())
-
-		if command == "" {
-			continue
+	server := startControlServer(hub, opts.controlAddr, opts.controlTokenFile)
+	startAPIServer(hub, opts.apiAddr, opts.apiTokenFile)
+	var watcher *procwatch.Watcher
+	if opts.watchProcess {
+		watcher = startProcessWatcher(hub)
+	}
+	exitDaemon := func(code int) {
+		if watcher != nil {
+			watcher.Stop()
 		}
-
-		parts := strings.Split(command, "|")
-
-		action := parts[0]
-		var region string
-		var customIPDir string
-
-		if len(parts) > 1 {
-			region = parts[1]
+		if server != nil {
+			server.Close()
 		}
-		if len(parts) > 2 {
-			customIPDir = parts[2]
+		os.Exit(code)
+	}
+
+	// Stdin is read on its own goroutine so heartbeats are seen while a command runs;
+	// the other commands are run here in the order they arrived
+	commands := make(chan string, stdinQueueSize)
+	var readErr error
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if line == config.ActionHeartbeat {
+				heartbeat.Beat("stdin")
+				continue
+			}
+			commands <- line
 		}
+		readErr = scanner.Err()
+		close(commands)
+	}()
 
-		if action == "exit" {
+	for line := range commands {
+		if strings.Split(line, "|")[0] == "exit" {
+			heartbeat.Stop()
+			if fw.Persistent() {
+				fmt.Println("Received exit command, persistent blocks enabled, leaving firewall rules in place")
+				exitDaemon(config.ExitSuccess)
+			}
 			fmt.Println("Received exit command, cleaning up...")
-			fw.UnblockAll()
+			hub.Execute("stdin", config.ActionUnblockAll)
 			fmt.Println("Cleanup completed, exiting...")
-			os.Exit(config.ExitSuccess)
+			exitDaemon(config.ExitSuccess)
 		}
 
-		if customIPDir != "" {
-			result := executeActionWithResult(fw, action, region, customIPDir)
-			fmt.Println(result)
-		} else {
-			result := executeActionWithResult(fw, action, region, absIPDir)
-			fmt.Println(result)
-		}
+		fmt.Println(hub.Execute("stdin", line))
 	}
 
-	fmt.Println("Parent process closed connection, cleaning up...")
-	fw.UnblockAll()
-	fmt.Println("Cleanup completed, exiting...")
+	heartbeat.Stop()
+	handleClientLost(fw, hub, opts.onClientLost, "parent process closed connection")
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("ERROR: Error reading input: %v\n", err)
-		os.Exit(config.ExitErrorInvalidArgs)
+	if readErr != nil {
+		fmt.Printf("ERROR: Error reading input: %v\n", readErr)
+		exitDaemon(config.ExitErrorInvalidArgs)
 	}
 
-	os.Exit(config.ExitSuccess)
+	exitDaemon(config.ExitSuccess)
 }
 
-func executeActionWithResult(fw *firewall.Firewall, action, region, ipDir string) string {
-	absIPDir, err := filepath.Abs(ipDir)
+// startProcessWatcher publishes an event whenever Overwatch starts or exits. Failing to
+// watch is not fatal, clients then just do not get the events.
+func startProcessWatcher(hub *control.Hub) *procwatch.Watcher {
+	watcher := process.NewWatcher()
+	events, err := watcher.Start()
 	if err != nil {
-		return fmt.Sprintf("ERROR: Failed to resolve IP directory path: %v", err)
+		fmt.Printf("Warning: Not watching the Overwatch process: %v\n", err)
+		return nil
 	}
+	if watcher.EventDriven() {
+		fmt.Println("Watching the Overwatch process through process events")
+	} else {
+		fmt.Printf("Watching the Overwatch process by polling every %s\n", procwatch.DefaultInterval)
+	}
+
+	go func() {
+		for event := range events {
+			eventType := control.EventGameStarted
+			if event.Type == procwatch.Exited {
+				eventType = control.EventGameExited
+				fmt.Printf("Overwatch exited (PID %d)\n", event.Process.PID)
+			} else {
+				fmt.Printf("Overwatch started (PID %d): %s\n", event.Process.PID, event.Process.Path)
+			}
 
-	if action != config.ActionSetPath &&
-		action != config.ActionGetPath &&
-		action != config.ActionUnblockAll &&
-		action != config.ActionStatus {
-		if !fw.HasOverwatchPath() {
-			return "ERROR: Overwatch path not configured. Please detect Overwatch path first."
+			hub.Publish(control.Event{
+				Type:    eventType,
+				Source:  "process-watcher",
+				Time:    event.Time,
+				Process: event.Process,
+			})
 		}
+	}()
+	return watcher
+}
+
+// handleClientLost applies the on-client-lost policy and logs why it was triggered
+func handleClientLost(fw *firewall.Firewall, hub *control.Hub, policy, reason string) {
+	fmt.Printf("Watchdog: client lost (%s), applying policy '%s'\n", reason, policy)
+
+	if policy == config.ClientLostKeep {
+		fmt.Println("Watchdog: keeping firewall rules in place")
+		return
+	}
+	if fw.Persistent() {
+		fmt.Println("Watchdog: persistent blocks enabled, keeping firewall rules in place")
+		return
 	}
 
-	switch action {
-	case config.ActionBlock:
-		result := fmt.Sprintf("Blocking IPs for region %s from directory %s...\n", region, absIPDir)
-		if err := fw.BlockIPs(region, absIPDir); err != nil {
-			return fmt.Sprintf("%sERROR: Failed to block IPs: %v", result, err)
-		}
-		return result + "Successfully blocked IPs."
+	fmt.Println(hub.Execute("watchdog", config.ActionUnblockAll))
+	fmt.Println("Watchdog: cleanup completed")
+}
 
-	case config.ActionUnblock:
-		result := fmt.Sprintf("Unblocking IPs for region %s...\n", region)
-		if err := fw.UnblockIPs(region); err != nil {
-			return fmt.Sprintf("%sERROR: Failed to unblock IPs: %v", result, err)
-		}
-		return result + "Successfully unblocked IPs."
+// restorePersistentBlocks re-verifies the saved blocks and re-applies missing ones
+func restorePersistentBlocks(fw *firewall.Firewall, absIPDir string) {
+	if !fw.Persistent() {
+		return
+	}
 
-	case config.ActionUnblockAll:
-		result := "Unblocking all IPs...\n"
-		if err := fw.UnblockAll(); err != nil {
-			return fmt.Sprintf("%sERROR: Failed to unblock all IPs: %v", result, err)
-		}
-		return result + "Successfully unblocked all IPs."
+	fmt.Println("Persistent blocks enabled, verifying saved blocks...")
+	result, err := fw.RestorePersistent(absIPDir)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to restore persistent blocks: %v\n", err)
+		return
+	}
+	fmt.Println(command.Render(result, report.FormatText))
+}
 
-	case config.ActionSetPath:
-		if region == "" {
-			return "ERROR: Path parameter is required for set-path action"
-		}
+func startControlServer(hub *control.Hub, address, tokenFile string) *control.Server {
+	if address == "" || address == "off" {
+		fmt.Println("Control endpoint disabled")
+		return nil
+	}
 
-		if err := fw.SetOverwatchPath(region); err != nil {
-			return fmt.Sprintf("ERROR: Failed to set Overwatch path: %v", err)
-		}
-		return fmt.Sprintf("Overwatch path set to: %s", region)
+	server, err := control.NewServer(hub, address, tokenFile)
+	if err != nil {
+		fmt.Printf("Warning: Failed to start control endpoint: %v\n", err)
+		return nil
+	}
 
-	case config.ActionGetPath:
-		path := fw.GetOverwatchPath()
-		if path == "" {
-			return "Overwatch path not configured"
-		}
-		return fmt.Sprintf("Current Overwatch path: %s", path)
+	fmt.Printf("Control endpoint listening on %s (token in %s)\n", server.Addr(), tokenFile)
+	go server.Serve()
+	return server
+}
 
-	case config.ActionStatus:
-		pathStatus := ""
-		if !fw.HasOverwatchPath() {
-			pathStatus = " - Overwatch path not configured"
-		}
-		return "Status: Ready" + pathStatus
+func startAPIServer(hub *control.Hub, address, tokenFile string) {
+	if address == "" {
+		return
+	}
 
-	default:
-		return fmt.Sprintf("ERROR: Unknown action '%s'", action)
+	token, err := api.LoadOrCreateToken(tokenFile)
+	if err != nil {
+		fmt.Printf("Warning: Failed to start HTTP API: %v\n", err)
+		return
 	}
+
+	server := api.NewServer(hub, token)
+	go func() {
+		fmt.Printf("HTTP API listening on http://%s (token in %s)\n", address, tokenFile)
+		if err := server.ListenAndServe(address); err != nil {
+			fmt.Printf("Warning: HTTP API stopped: %v\n", err)
+		}
+	}()
 }
 
-func executeAction(fw *firewall.Firewall, action, region, ipDir string) {
-	result := executeActionWithResult(fw, action, region, ipDir)
+func executeAction(fw *firewall.Firewall, req command.Request) {
+	result := command.Execute(fw, req)
 	fmt.Println(result)
 
 	if strings.Contains(result, "ERROR:") {
@@ -1,3 +1,11 @@
 module quidque.no/ow-firewall-sidecar
 
 go 1.24.2
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require golang.org/x/sys v0.30.0
+
+require quidque.no/ow-vpn-shared v0.0.0
+
+replace quidque.no/ow-vpn-shared => ../shared
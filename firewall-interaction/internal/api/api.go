@@ -0,0 +1,228 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"quidque.no/ow-firewall-sidecar/internal/command"
+	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-firewall-sidecar/internal/control"
+)
+
+// source identifies API requests in events and logs
+const source = "api"
+
+const keepAliveInterval = 30 * time.Second
+
+var validRegion = regexp.MustCompile(config.RegionNamePattern)
+
+// Server exposes the daemon protocol as an HTTP API protected by a bearer token
+type Server struct {
+	hub   *control.Hub
+	token string
+	mux   *http.ServeMux
+}
+
+func NewServer(hub *control.Hub, token string) *Server {
+	s := &Server{
+		hub:   hub,
+		token: token,
+		mux:   http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /api/status", s.handleQuery(config.ActionStatus))
+	s.mux.HandleFunc("GET /api/regions", s.handleQuery(config.ActionRegions))
+	s.mux.HandleFunc("GET /api/history", s.handleQuery(config.ActionHistory))
+	s.mux.HandleFunc("POST /api/regions/{region}/block", s.handleRegionAction(config.ActionBlock))
+	s.mux.HandleFunc("POST /api/regions/{region}/unblock", s.handleRegionAction(config.ActionUnblock))
+	s.mux.HandleFunc("POST /api/unblock-all", s.handleUnblockAll)
+	s.mux.HandleFunc("POST /api/heartbeat", s.handleHeartbeat)
+	s.mux.HandleFunc("GET /api/events", s.handleEvents)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr, which must be a loopback address
+func (s *Server) ListenAndServe(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid api address %s: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("api address must be a loopback IP, got %s", host)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// LoadOrCreateToken reads the API token from path, generating and saving one on first run
+func LoadOrCreateToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading api token file: %w", err)
+	}
+
+	token, err := control.GenerateToken()
+	if err != nil {
+		return "", fmt.Errorf("generating api token: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("writing api token file: %w", err)
+	}
+	return token, nil
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.token)) == 1
+}
+
+func (s *Server) handleQuery(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := s.hub.Execute(source, fmt.Sprintf("%s|||json", action))
+		if message, failed := strings.CutPrefix(result, "ERROR: "); failed {
+			writeError(w, http.StatusInternalServerError, message)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, result)
+	}
+}
+
+func (s *Server) handleRegionAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		region := r.PathValue("region")
+		if !validRegion.MatchString(region) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid region '%s'", region))
+			return
+		}
+		s.runCommand(w, fmt.Sprintf("%s|%s", action, region))
+	}
+}
+
+func (s *Server) handleUnblockAll(w http.ResponseWriter, r *http.Request) {
+	s.runCommand(w, config.ActionUnblockAll)
+}
+
+// handleHeartbeat keeps the daemon's client-lost watchdog from firing
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	s.runCommand(w, control.CommandHeartbeat)
+}
+
+func (s *Server) runCommand(w http.ResponseWriter, command string) {
+	result := s.hub.Execute(source, command)
+
+	if idx := strings.Index(result, "ERROR: "); idx >= 0 {
+		message := result[idx+len("ERROR: "):]
+		writeError(w, errorStatus(message), message)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"result": result})
+}
+
+// errorStatus answers failures the client can fix with a client error and everything
+// else with 500. An action that needs the Overwatch path answers 409 while it is not
+// configured, as the route exists but the sidecar is not in a state to run it.
+func errorStatus(message string) int {
+	switch {
+	case strings.HasPrefix(message, command.ErrorUnknownRegion):
+		return http.StatusBadRequest
+	case strings.HasPrefix(message, command.ErrorPathNotConfigured):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleEvents streams state changes from every client and game process events as
+// Server-Sent Events
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events, unsubscribe := s.hub.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data := strings.TrimPrefix(event, control.EventPrefix)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType(data), data)
+			flusher.Flush()
+		}
+	}
+}
+
+// eventType returns the SSE event name for an encoded control event
+func eventType(data string) string {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil || event.Type == "" {
+		return control.EventStateChanged
+	}
+	return event.Type
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
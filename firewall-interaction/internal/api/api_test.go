@@ -0,0 +1,295 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"quidque.no/ow-firewall-sidecar/internal/command"
+	"quidque.no/ow-firewall-sidecar/internal/control"
+	"quidque.no/ow-firewall-sidecar/internal/firewall"
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+const testToken = "test-token"
+
+// newTestServer serves the API for a firewall on the memory backend with an EU list.
+// With withPath the config names an existing Overwatch.exe, so blocks can be applied.
+func newTestServer(t *testing.T, withPath bool) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+
+	store, err := configstore.Open(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipDir := filepath.Join(dir, "ips")
+	if err := os.MkdirAll(ipDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ipDir, "EU.txt"), []byte("1.2.3.0/24\n2001:db8::/32\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if withPath {
+		// Stored with its file info, so loading the config does not verify the PE again
+		exe := filepath.Join(dir, "Overwatch.exe")
+		if err := os.WriteFile(exe, []byte("MZ"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stat, err := os.Stat(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Update(func(cfg *configstore.Config) error {
+			cfg.SetTarget(configstore.Target{
+				Edition: configstore.EditionBattleNet,
+				Path:    exe,
+				Info:    &configstore.ExecutableInfo{Path: exe, Size: stat.Size(), ModTime: stat.ModTime()},
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fw := firewall.NewWithBackend(firewall.NewMemoryBackend(), store)
+	var hub *control.Hub
+	hub = control.NewHub(func(source, line string) string {
+		return command.ExecuteDaemon(fw, hub, source, line, ipDir)
+	})
+
+	server := httptest.NewServer(NewServer(hub, testToken))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func request(t *testing.T, server *httptest.Server, method, path, token string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("%s %s: decoding response: %v", method, path, err)
+	}
+	return resp.StatusCode, body
+}
+
+// blockedRegions returns the regions /api/status reports as blocked
+func blockedRegions(t *testing.T, server *httptest.Server) []string {
+	t.Helper()
+
+	status, body := request(t, server, http.MethodGet, "/api/status", testToken)
+	if status != http.StatusOK {
+		t.Fatalf("GET /api/status = %d %v", status, body)
+	}
+
+	regions := []string{}
+	for _, region := range body["regions"].([]interface{}) {
+		regions = append(regions, region.(map[string]interface{})["region"].(string))
+	}
+	return regions
+}
+
+func TestUnauthorized(t *testing.T) {
+	server := newTestServer(t, true)
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"missing", ""},
+		{"wrong token", "Bearer wrong"},
+		{"empty token", "Bearer "},
+		{"other scheme", "Basic " + testToken},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/regions/EU/block", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", tt.name, resp.StatusCode)
+		}
+		if resp.Header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: WWW-Authenticate = %q, want Bearer", tt.name, resp.Header.Get("WWW-Authenticate"))
+		}
+	}
+
+	if regions := blockedRegions(t, server); len(regions) != 0 {
+		t.Errorf("unauthorized requests blocked %v", regions)
+	}
+}
+
+func TestBlockUnblockStatus(t *testing.T) {
+	server := newTestServer(t, true)
+
+	status, body := request(t, server, http.MethodPost, "/api/regions/EU/block", testToken)
+	if status != http.StatusOK || !strings.Contains(body["result"].(string), "Successfully blocked") {
+		t.Fatalf("block EU = %d %v", status, body)
+	}
+	if regions := blockedRegions(t, server); len(regions) != 1 || regions[0] != "EU" {
+		t.Fatalf("blocked regions after block = %v, want [EU]", regions)
+	}
+
+	status, body = request(t, server, http.MethodPost, "/api/regions/EU/unblock", testToken)
+	if status != http.StatusOK {
+		t.Fatalf("unblock EU = %d %v", status, body)
+	}
+	if regions := blockedRegions(t, server); len(regions) != 0 {
+		t.Fatalf("blocked regions after unblock = %v, want none", regions)
+	}
+
+	request(t, server, http.MethodPost, "/api/regions/EU/block", testToken)
+	status, body = request(t, server, http.MethodPost, "/api/unblock-all", testToken)
+	if status != http.StatusOK {
+		t.Fatalf("unblock-all = %d %v", status, body)
+	}
+	if regions := blockedRegions(t, server); len(regions) != 0 {
+		t.Fatalf("blocked regions after unblock-all = %v, want none", regions)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	withPath := newTestServer(t, true)
+	withoutPath := newTestServer(t, false)
+
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		path   string
+		want   int
+	}{
+		{"invalid region name", withPath, "/api/regions/E.U/block", http.StatusBadRequest},
+		{"region without ip list", withPath, "/api/regions/XX/block", http.StatusBadRequest},
+		{"path not configured", withoutPath, "/api/regions/EU/block", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		status, body := request(t, tt.server, http.MethodPost, tt.path, testToken)
+		if status != tt.want {
+			t.Errorf("%s: status = %d %v, want %d", tt.name, status, body, tt.want)
+		}
+		if message, _ := body["error"].(string); message == "" {
+			t.Errorf("%s: response %v has no error message", tt.name, body)
+		}
+	}
+
+	if got := errorStatus("Failed to unblock all IPs: access denied"); got != http.StatusInternalServerError {
+		t.Errorf("other failures answer %d, want 500", got)
+	}
+
+	// Unknown routes still answer 404
+	req, err := http.NewRequest(http.MethodPost, withPath.URL+"/api/regions/EU/unknown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := withPath.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown route answered %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	server := newTestServer(t, true)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /api/events = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	// The comment sent on connect means the subscription is in place
+	if line := <-lines; line != ": connected" {
+		t.Fatalf("first line = %q, want ': connected'", line)
+	}
+
+	if status, body := request(t, server, http.MethodPost, "/api/regions/EU/block", testToken); status != http.StatusOK {
+		t.Fatalf("block EU = %d %v", status, body)
+	}
+
+	timeout := time.After(5 * time.Second)
+	eventName := ""
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("event stream ended before the state-changed event")
+			}
+			if name, found := strings.CutPrefix(line, "event: "); found {
+				eventName = name
+				continue
+			}
+			data, found := strings.CutPrefix(line, "data: ")
+			if !found {
+				continue
+			}
+			if eventName != control.EventStateChanged {
+				t.Fatalf("got event %q, want %s", eventName, control.EventStateChanged)
+			}
+
+			var event control.Event
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("decoding event %s: %v", data, err)
+			}
+			if event.Action != "block" || event.Region != "EU" || event.Source != source {
+				t.Fatalf("event = %+v, want block of EU from %s", event, source)
+			}
+			return
+
+		case <-timeout:
+			t.Fatal("no state-changed event within 5s")
+		}
+	}
+}
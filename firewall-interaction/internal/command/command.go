@@ -0,0 +1,319 @@
+// Package command runs the actions of the sidecar protocol. The command line, stdin in
+// daemon mode, the control endpoint and the HTTP API all go through it.
+package command
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-firewall-sidecar/internal/control"
+	"quidque.no/ow-firewall-sidecar/internal/firewall"
+	"quidque.no/ow-firewall-sidecar/internal/report"
+	"quidque.no/ow-firewall-sidecar/internal/snapshot"
+	"quidque.no/ow-vpn-shared/discovery"
+)
+
+// Failures that are the caller's to fix start with these messages after "ERROR: ", so
+// the HTTP API can answer them with a client error
+const (
+	ErrorPathNotConfigured = "Overwatch path not configured"
+	ErrorUnknownRegion     = "Unknown region"
+)
+
+// Request is one action with its parameters
+type Request struct {
+	Action string
+	// Region is the region of block, unblock and show
+	Region string
+	// Argument is the parameter of the other actions that take one: the path for
+	// set-path, the snapshot file for import, the edition for clear-path, the fixture
+	// root for discover and on or off for set-persist
+	Argument string
+	// Purge makes unblock-all also turn persistent blocks off
+	Purge  bool
+	IPDir  string
+	Format string
+}
+
+// TakesRegion reports whether the action works on a region rather than an argument
+func TakesRegion(action string) bool {
+	return action == config.ActionBlock || action == config.ActionUnblock || action == config.ActionShow
+}
+
+// ParseOptions splits options such as "unblock-all --purge" off the action and reports
+// whether the purge option was given
+func ParseOptions(action string) (string, bool) {
+	action, option, found := strings.Cut(strings.TrimSpace(action), " ")
+	return action, found && action == config.ActionUnblockAll && strings.TrimSpace(option) == config.PurgeOption
+}
+
+// ExecuteDaemon runs one action|parameter|ip-dir|format command and broadcasts the new
+// state when the command changed it. The parameter is the region for the actions that
+// take one and the argument of the others.
+func ExecuteDaemon(fw *firewall.Firewall, hub *control.Hub, source, command, absIPDir string) string {
+	parts := strings.Split(command, "|")
+
+	var parameter string
+	req := Request{IPDir: absIPDir}
+
+	if len(parts) > 1 {
+		parameter = parts[1]
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		req.IPDir = parts[2]
+	}
+	if len(parts) > 3 {
+		req.Format = parts[3]
+	}
+	req.Action, req.Purge = ParseOptions(parts[0])
+
+	switch {
+	case TakesRegion(req.Action):
+		req.Region = parameter
+	case req.Action == config.ActionUnblockAll:
+		req.Purge = req.Purge || parameter == config.PurgeOption
+	default:
+		req.Argument = parameter
+	}
+
+	result := Execute(fw, req)
+
+	if isStateChange(req.Action) && !strings.Contains(result, "ERROR:") {
+		hub.Publish(control.Event{
+			Type:   control.EventStateChanged,
+			Action: req.Action,
+			Region: req.Region,
+			Source: source,
+			Status: fw.Status(absIPDir),
+		})
+	}
+
+	return result
+}
+
+func isStateChange(action string) bool {
+	switch action {
+	case config.ActionBlock, config.ActionUnblock, config.ActionUnblockAll, config.ActionSetPath, config.ActionClearPath, config.ActionSetPersist, config.ActionPurgeAll, config.ActionImport:
+		return true
+	default:
+		return false
+	}
+}
+
+// Execute runs one action and returns its output. Failures are reported as a line
+// starting with "ERROR: ", which may follow lines of progress output.
+func Execute(fw *firewall.Firewall, req Request) string {
+	action, region, argument := req.Action, req.Region, req.Argument
+
+	absIPDir, err := filepath.Abs(req.IPDir)
+	if err != nil {
+		return fmt.Sprintf("ERROR: Failed to resolve IP directory path: %v", err)
+	}
+
+	format := req.Format
+	if format == "" {
+		format = defaultFormat(action)
+	}
+
+	if action != config.ActionSetPath &&
+		action != config.ActionGetPath &&
+		action != config.ActionClearPath &&
+		action != config.ActionSetPersist &&
+		action != config.ActionPurgeAll &&
+		action != config.ActionDiscover &&
+		action != config.ActionUnblockAll &&
+		action != config.ActionStatus &&
+		action != config.ActionList &&
+		action != config.ActionShow &&
+		action != config.ActionExport &&
+		action != config.ActionImport &&
+		action != config.ActionRegions &&
+		action != config.ActionHistory {
+		if !fw.HasOverwatchPath() {
+			return "ERROR: " + ErrorPathNotConfigured + ". Please detect Overwatch path first."
+		}
+	}
+
+	switch action {
+	case config.ActionBlock:
+		result := fmt.Sprintf("Blocking IPs for region %s from directory %s...\n", region, absIPDir)
+		if err := fw.BlockIPs(region, absIPDir); err != nil {
+			if errors.Is(err, firewall.ErrNoIPList) || errors.Is(err, firewall.ErrInvalidRegion) {
+				return fmt.Sprintf("%sERROR: %s '%s': %v", result, ErrorUnknownRegion, region, err)
+			}
+			return fmt.Sprintf("%sERROR: Failed to block IPs: %v", result, err)
+		}
+		return result + "Successfully blocked IPs."
+
+	case config.ActionUnblock:
+		result := fmt.Sprintf("Unblocking IPs for region %s...\n", region)
+		if err := fw.UnblockIPs(region); err != nil {
+			return fmt.Sprintf("%sERROR: Failed to unblock IPs: %v", result, err)
+		}
+		return result + "Successfully unblocked IPs."
+
+	case config.ActionUnblockAll:
+		if req.Purge {
+			result := "Purging all OW-VPN rules and persistent blocks...\n"
+			if err := fw.Purge(); err != nil {
+				return fmt.Sprintf("%sERROR: Failed to purge: %v", result, err)
+			}
+			return result + "Successfully unblocked all IPs and turned persistent blocks off."
+		}
+
+		result := "Unblocking all IPs...\n"
+		if err := fw.UnblockAll(); err != nil {
+			return fmt.Sprintf("%sERROR: Failed to unblock all IPs: %v", result, err)
+		}
+		return result + "Successfully unblocked all IPs."
+
+	case config.ActionSetPath:
+		if argument == "" {
+			return "ERROR: Path parameter is required for set-path action"
+		}
+
+		target, err := fw.SetOverwatchPath(argument)
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to set Overwatch path: %v", err)
+		}
+		return fmt.Sprintf("Overwatch path set to: %s", target.Path)
+
+	case config.ActionClearPath:
+		cleared, err := fw.ClearTargets(argument)
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to clear Overwatch path: %v", err)
+		}
+		result := "Overwatch paths cleared"
+		if argument != "" {
+			result = fmt.Sprintf("Overwatch %s path cleared", argument)
+		}
+		if len(cleared.Updated) > 0 {
+			result += fmt.Sprintf("\nRegions still blocked for the remaining paths: %s", strings.Join(cleared.Updated, ", "))
+		}
+		if len(cleared.Unblocked) > 0 {
+			result += fmt.Sprintf("\nRegions unblocked, no configured path is left: %s", strings.Join(cleared.Unblocked, ", "))
+		}
+		return result
+
+	case config.ActionPurgeAll:
+		result := "Removing OW-VPN rules of every namespace...\n"
+		removed, err := fw.PurgeAllNamespaces()
+		if err != nil {
+			return fmt.Sprintf("%sERROR: Failed to purge all namespaces: %v", result, err)
+		}
+		return fmt.Sprintf("%sSuccessfully removed %d rules from all namespaces.", result, removed)
+
+	case config.ActionSetPersist:
+		var enabled bool
+		switch argument {
+		case "on":
+			enabled = true
+		case "off":
+			enabled = false
+		default:
+			return "ERROR: set-persist requires 'on' or 'off'"
+		}
+
+		if err := fw.SetPersistent(enabled); err != nil {
+			return fmt.Sprintf("ERROR: Failed to change persistent blocks: %v", err)
+		}
+		if enabled {
+			return "Persistent blocks enabled: blocks are kept when the sidecar exits"
+		}
+		return "Persistent blocks disabled: blocks are removed when the sidecar exits"
+
+	case config.ActionDiscover:
+		env := discovery.DefaultEnvironment()
+		if argument != "" {
+			env = discovery.FixtureEnvironment(argument)
+		}
+		return Render(fw.Discover(env), format)
+
+	case config.ActionGetPath:
+		targets := fw.Targets()
+		if len(targets) == 0 {
+			return "Overwatch path not configured"
+		}
+		lines := make([]string, 0, len(targets))
+		for _, target := range targets {
+			lines = append(lines, fmt.Sprintf("Current Overwatch path: %s", target.Path))
+		}
+		return strings.Join(lines, "\n")
+
+	case config.ActionStatus:
+		return Render(fw.Status(absIPDir), format)
+
+	case config.ActionList:
+		rules, err := fw.ListRules()
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to list rules: %v", err)
+		}
+		return Render(rules, format)
+
+	case config.ActionShow:
+		if region == "" {
+			return "ERROR: Region parameter is required for show action"
+		}
+		ranges, err := fw.ShowRegion(region)
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to show region %s: %v", region, err)
+		}
+		return Render(ranges, format)
+
+	case config.ActionExport:
+		snap, err := fw.Export(absIPDir)
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to export block state: %v", err)
+		}
+		return Render(snap, format)
+
+	case config.ActionRegions:
+		regions, err := fw.AvailableRegions(absIPDir)
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to list regions: %v", err)
+		}
+		return Render(regions, format)
+
+	case config.ActionHistory:
+		return Render(fw.History(), format)
+
+	case config.ActionImport:
+		if argument == "" {
+			return "ERROR: Snapshot file parameter is required for import action"
+		}
+		snap, err := snapshot.Load(argument)
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to load snapshot: %v", err)
+		}
+		result, err := fw.Import(snap, absIPDir)
+		if err != nil {
+			return fmt.Sprintf("ERROR: Failed to import snapshot: %v", err)
+		}
+		return Render(result, format)
+
+	default:
+		return fmt.Sprintf("ERROR: Unknown action '%s'", action)
+	}
+}
+
+// defaultFormat keeps status and export machine-readable while inspection output stays human-readable
+func defaultFormat(action string) string {
+	switch action {
+	case config.ActionStatus, config.ActionExport:
+		return report.FormatJSON
+	default:
+		return report.FormatText
+	}
+}
+
+// Render formats the result of an action as text, JSON or YAML
+func Render(v interface{}, format string) string {
+	result, err := report.Render(v, format)
+	if err != nil {
+		return fmt.Sprintf("ERROR: Failed to render output: %v", err)
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"quidque.no/ow-firewall-sidecar/internal/control"
+	"quidque.no/ow-firewall-sidecar/internal/firewall"
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// newTestDaemon runs daemon commands against a firewall on the memory backend with an EU
+// list and a configured Overwatch.exe, and returns the directory the files live in
+func newTestDaemon(t *testing.T) (func(line string) string, string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	store, err := configstore.Open(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipDir := filepath.Join(dir, "ips")
+	if err := os.MkdirAll(ipDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ipDir, "EU.txt"), []byte("1.2.3.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored with its file info, so loading the config does not verify the PE again
+	exe := filepath.Join(dir, "Overwatch.exe")
+	if err := os.WriteFile(exe, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Update(func(cfg *configstore.Config) error {
+		cfg.SetTarget(configstore.Target{
+			Edition: configstore.EditionBattleNet,
+			Path:    exe,
+			Info:    &configstore.ExecutableInfo{Path: exe, Size: stat.Size(), ModTime: stat.ModTime()},
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fw := firewall.NewWithBackend(firewall.NewMemoryBackend(), store)
+	hub := control.NewHub(nil)
+	return func(line string) string {
+		return ExecuteDaemon(fw, hub, "test", line, ipDir)
+	}, dir
+}
+
+func TestImportTakesTheFileAsArgument(t *testing.T) {
+	run, dir := newTestDaemon(t)
+
+	file := filepath.Join(dir, "eu.yaml")
+	snapshot := "schemaVersion: 1\nregions:\n  - name: EU\n"
+	if err := os.WriteFile(file, []byte(snapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := run("import|" + file + "||json")
+	if strings.Contains(result, "ERROR:") || !strings.Contains(result, `"EU"`) {
+		t.Fatalf("import = %s", result)
+	}
+	if status := run("status"); !strings.Contains(status, `"regions":[{"region":"EU"`) {
+		t.Errorf("status after import = %s", status)
+	}
+}
+
+func TestRegionNamesCannotLeaveTheIPListDirectory(t *testing.T) {
+	run, dir := newTestDaemon(t)
+
+	// A list outside the IP list directory that a ../ name would reach
+	if err := os.WriteFile(filepath.Join(dir, "outside.txt"), []byte("9.9.9.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := run("block|../outside"); !strings.Contains(result, "ERROR: "+ErrorUnknownRegion) {
+		t.Errorf("block|../outside = %s", result)
+	}
+
+	file := filepath.Join(dir, "escape.yaml")
+	snapshot := "schemaVersion: 1\nregions:\n  - name: ../outside\n"
+	if err := os.WriteFile(file, []byte(snapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result := run("import|" + file); !strings.Contains(result, "invalid region name '../outside'") {
+		t.Errorf("import of ../outside = %s", result)
+	}
+
+	if status := run("status"); !strings.Contains(status, `"regions":[]`) {
+		t.Errorf("status = %s, want no blocked regions", status)
+	}
+}
+
+func TestArgumentsDoNotBecomeRegions(t *testing.T) {
+	run, _ := newTestDaemon(t)
+
+	if result := run("set-persist|on"); !strings.Contains(result, "Persistent blocks enabled") {
+		t.Fatalf("set-persist|on = %s", result)
+	}
+	if result := run("clear-path|steam"); result != "Overwatch steam path cleared" {
+		t.Fatalf("clear-path|steam = %s", result)
+	}
+	if result := run("block|EU"); strings.Contains(result, "ERROR:") {
+		t.Fatalf("block|EU = %s", result)
+	}
+	if result := run("unblock-all|--purge"); !strings.Contains(result, "turned persistent blocks off") {
+		t.Errorf("unblock-all|--purge = %s", result)
+	}
+}
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 const (
 	OverwatchProcessName   = "Overwatch.exe"
 	FirewallRulePrefix     = "OW-VPN-"
@@ -13,6 +15,16 @@ const (
 	ExitErrorInvalidArgs   = 5
 )
 
+const (
+	// The default control endpoints end in the rule namespace, so the daemons of two
+	// installations do not take over each other's endpoint
+	ControlPipePrefix       = `\\.\pipe\ow-vpn-sidecar-`
+	ControlSocketPrefix     = "/tmp/ow-vpn-sidecar-"
+	DefaultControlTokenFile = "control.token"
+	DefaultAPITokenFile     = "api.token"
+	DefaultBlocksFile       = "blocks.json"
+)
+
 const (
 	ActionBlock      = "block"
 	ActionUnblock    = "unblock"
@@ -20,10 +32,34 @@ const (
 	ActionStatus     = "status"
 	ActionSetPath    = "set-path"
 	ActionGetPath    = "get-path"
+	ActionClearPath  = "clear-path"
+	ActionList       = "list"
+	ActionShow       = "show"
+	ActionExport     = "export"
+	ActionImport     = "import"
+	ActionRegions    = "regions"
+	ActionHistory    = "history"
+	ActionHeartbeat  = "heartbeat"
+	ActionSetPersist = "set-persist"
+	ActionPurgeAll   = "purge-all"
+	ActionDiscover   = "discover"
 )
 
-type Config struct {
-	OverwatchPath    string `json:"overwatchPath"`
-	UseGithubSource  bool   `json:"useGithubSource"`
-	InitialSetupDone bool   `json:"initialSetupDone"`
-}
+// PurgeOption makes unblock-all remove every rule and turn persistent blocks off
+const PurgeOption = "--purge"
+
+// RegionNamePattern matches region codes and custom list names. Region codes name the
+// list file in the IP list directory, so they cannot contain path separators or dots.
+const RegionNamePattern = `^[A-Za-z0-9_-]{1,32}$`
+
+const (
+	ClientLostUnblockAll  = "unblock-all"
+	ClientLostKeep        = "keep"
+	DefaultHeartbeatGrace = 15 * time.Second
+)
+
+const (
+	ProtocolAny = "any"
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
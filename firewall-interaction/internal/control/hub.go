@@ -0,0 +1,117 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EventPrefix starts every event line written to subscribers
+	EventPrefix = "EVENT "
+
+	// CommandHeartbeat tells the daemon its client is still alive. It is answered without
+	// waiting for running commands, so a long block or import cannot starve the watchdog.
+	CommandHeartbeat = "heartbeat"
+
+	EventStateChanged = "state-changed"
+	EventGameStarted  = "game-started"
+	EventGameExited   = "game-exited"
+)
+
+// Event is broadcast to every subscriber after a command changed the firewall state, or
+// when the game process started or exited
+type Event struct {
+	Type    string      `json:"type"`
+	Action  string      `json:"action,omitempty"`
+	Region  string      `json:"region,omitempty"`
+	Source  string      `json:"source"`
+	Time    time.Time   `json:"time"`
+	Process interface{} `json:"process,omitempty"`
+	Status  interface{} `json:"status,omitempty"`
+}
+
+// Handler executes one protocol command sent by source and returns its output
+type Handler func(source, command string) string
+
+// Hub serializes commands from every client and fans out events to subscribers
+type Hub struct {
+	handler     Handler
+	heartbeat   func(source string)
+	commandLock sync.Mutex
+	subsMutex   sync.Mutex
+	subscribers map[chan string]struct{}
+}
+
+func NewHub(handler Handler) *Hub {
+	return &Hub{
+		handler:     handler,
+		subscribers: make(map[chan string]struct{}),
+	}
+}
+
+// SetHeartbeat sets the function heartbeats from every client are passed to, together
+// with the client that sent them. It must be called before clients connect.
+func (h *Hub) SetHeartbeat(beat func(source string)) {
+	h.heartbeat = beat
+}
+
+// Execute runs a command, waiting for any command from another client to finish first.
+// Heartbeats are handled at once.
+func (h *Hub) Execute(source, command string) string {
+	if strings.TrimSpace(command) == CommandHeartbeat {
+		if h.heartbeat != nil {
+			h.heartbeat(source)
+		}
+		return "OK"
+	}
+
+	h.commandLock.Lock()
+	defer h.commandLock.Unlock()
+	return h.handler(source, command)
+}
+
+// Subscribe registers a new event subscriber. The returned function unsubscribes it.
+func (h *Hub) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, 32)
+
+	h.subsMutex.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.subsMutex.Unlock()
+
+	return ch, func() {
+		h.subsMutex.Lock()
+		defer h.subsMutex.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends an event to every subscriber. Slow subscribers miss events rather than block commands.
+func (h *Hub) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("Warning: Failed to encode event: %v\n", err)
+		return
+	}
+	line := EventPrefix + string(data)
+
+	h.subsMutex.Lock()
+	defer h.subsMutex.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- line:
+		default:
+			fmt.Println("Warning: Dropped event for slow control client")
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatDoesNotWaitForRunningCommand(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	hub := NewHub(func(source, command string) string {
+		close(started)
+		<-release
+		return "done"
+	})
+
+	beats := make(chan string, 1)
+	hub.SetHeartbeat(func(source string) { beats <- source })
+
+	go hub.Execute("test", "block|EU")
+	<-started
+	defer close(release)
+
+	result := make(chan string, 1)
+	go func() { result <- hub.Execute("test", " heartbeat ") }()
+
+	select {
+	case got := <-result:
+		if got != "OK" {
+			t.Errorf("heartbeat answered %q, want OK", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat waited for the running command")
+	}
+
+	select {
+	case source := <-beats:
+		if source != "test" {
+			t.Errorf("heartbeat passed to the watchdog from %q, want test", source)
+		}
+	default:
+		t.Error("heartbeat was not passed to the watchdog")
+	}
+}
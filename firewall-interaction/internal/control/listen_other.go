@@ -0,0 +1,50 @@
+//go:build !windows
+
+package control
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+type unixListener struct {
+	listener net.Listener
+	path     string
+}
+
+// Listen creates a Unix domain socket at path that only the current user can connect to
+func Listen(path string) (Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("control path exists and is not a socket: %s", path)
+		}
+		os.Remove(path)
+	}
+
+	// The socket is created with the permissions left by the umask, so it is restricted
+	// before Listen; a chmod afterwards would let other users connect in between
+	restore := restrictUmask()
+	listener, err := net.Listen("unix", path)
+	restore()
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+
+	return &unixListener{listener: listener, path: path}, nil
+}
+
+func (l *unixListener) Accept() (io.ReadWriteCloser, error) {
+	return l.listener.Accept()
+}
+
+func (l *unixListener) Close() error {
+	err := l.listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+func (l *unixListener) Addr() string {
+	return l.path
+}
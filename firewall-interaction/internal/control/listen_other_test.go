@@ -0,0 +1,35 @@
+//go:build unix
+
+package control
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenCreatesSocketForCurrentUserOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	listener, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+
+	// The umask is restored after the socket is created
+	old := syscall.Umask(0)
+	syscall.Umask(old)
+	if old == 0177 {
+		t.Error("umask left at 0177 after Listen")
+	}
+}
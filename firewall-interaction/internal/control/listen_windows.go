@@ -0,0 +1,262 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeSecurity grants access to SYSTEM, the Administrators group and the user the sidecar
+// runs as. The sidecar usually runs elevated, and the user's own SID is what lets the
+// GUI and tools like a Stream Deck script connect from a non-elevated process. Other
+// users of the machine cannot open the pipe, and every client still has to present the
+// token from the token file.
+const pipeSecurity = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;%s)"
+
+const pipeBufferSize = 4096
+
+var errPipeClosed = errors.New("pipe closed")
+
+type pipeListener struct {
+	name       string
+	attributes *windows.SecurityAttributes
+	mutex      sync.Mutex
+	pending    windows.Handle
+	accepting  bool
+	closed     bool
+}
+
+// Listen creates a named pipe such as \\.\pipe\ow-vpn-sidecar-1a2b3c4d that
+// administrators and the current user can open
+func Listen(name string) (Listener, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("looking up the current user: %w", err)
+	}
+	sd, err := windows.SecurityDescriptorFromString(fmt.Sprintf(pipeSecurity, user.User.Sid.String()))
+	if err != nil {
+		return nil, fmt.Errorf("creating pipe security descriptor: %w", err)
+	}
+
+	l := &pipeListener{
+		name: name,
+		attributes: &windows.SecurityAttributes{
+			Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+			SecurityDescriptor: sd,
+		},
+		pending: windows.InvalidHandle,
+	}
+
+	// Create the first instance up front so the pipe exists as soon as Listen returns
+	handle, err := l.createInstance(true)
+	if err != nil {
+		return nil, err
+	}
+	l.pending = handle
+
+	return l, nil
+}
+
+// createInstance creates a pipe instance for overlapped I/O, so a connection can be
+// written to while a read on it is pending
+func (l *pipeListener) createInstance(first bool) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(l.name)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+
+	handle, err := windows.CreateNamedPipe(name,
+		flags,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		l.attributes)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("creating named pipe %s: %w", l.name, err)
+	}
+	return handle, nil
+}
+
+func (l *pipeListener) Accept() (io.ReadWriteCloser, error) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil, fmt.Errorf("listener closed")
+	}
+	if l.pending == windows.InvalidHandle {
+		handle, err := l.createInstance(false)
+		if err != nil {
+			l.mutex.Unlock()
+			return nil, err
+		}
+		l.pending = handle
+	}
+	handle := l.pending
+	l.accepting = true
+	l.mutex.Unlock()
+
+	// Close cancels the wait through the pending handle
+	var done uint32
+	err := waitOverlapped(handle, &done, l.isClosed, func(ov *windows.Overlapped, _ *uint32) error {
+		return windows.ConnectNamedPipe(handle, ov)
+	})
+	if err == windows.ERROR_PIPE_CONNECTED {
+		err = nil
+	}
+
+	l.mutex.Lock()
+	l.pending = windows.InvalidHandle
+	l.accepting = false
+	if err == nil && l.closed {
+		err = errPipeClosed
+	}
+	l.mutex.Unlock()
+
+	if err != nil {
+		windows.CloseHandle(handle)
+		return nil, fmt.Errorf("waiting for pipe client: %w", err)
+	}
+	return &pipeConn{handle: handle}, nil
+}
+
+func (l *pipeListener) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.closed = true
+	if l.pending == windows.InvalidHandle {
+		return nil
+	}
+	if l.accepting {
+		// Accept closes the handle once the cancelled wait returns
+		windows.CancelIoEx(l.pending, nil)
+		return nil
+	}
+	windows.CloseHandle(l.pending)
+	l.pending = windows.InvalidHandle
+	return nil
+}
+
+func (l *pipeListener) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+func (l *pipeListener) Addr() string {
+	return l.name
+}
+
+// pipeConn is a connected pipe instance. Reads and writes are overlapped, so the
+// subscriber goroutine can send events while the connection waits for the next command.
+type pipeConn struct {
+	handle    windows.Handle
+	closing   atomic.Bool
+	running   sync.RWMutex
+	closeOnce sync.Once
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	for {
+		n, err := c.do(func(ov *windows.Overlapped, done *uint32) error {
+			return windows.ReadFile(c.handle, b, done, ov)
+		})
+		switch {
+		case err == windows.ERROR_BROKEN_PIPE || err == windows.ERROR_PIPE_NOT_CONNECTED || err == errPipeClosed:
+			return 0, io.EOF
+		case err != nil:
+			return n, err
+		case n > 0:
+			return n, nil
+		}
+		// A client writing zero bytes completes a read without data; wait for more
+	}
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := c.do(func(ov *windows.Overlapped, done *uint32) error {
+			return windows.WriteFile(c.handle, b[written:], done, ov)
+		})
+		written += n
+		if err == windows.ERROR_NO_DATA || err == windows.ERROR_BROKEN_PIPE || err == errPipeClosed {
+			return written, os.ErrClosed
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close cancels pending reads and writes, waits for them to return and closes the pipe.
+// Data already written stays readable by the client.
+func (c *pipeConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.closing.Store(true)
+		windows.CancelIoEx(c.handle, nil)
+
+		c.running.Lock()
+		defer c.running.Unlock()
+		err = windows.CloseHandle(c.handle)
+	})
+	return err
+}
+
+// do runs one overlapped operation unless the connection is closing
+func (c *pipeConn) do(start func(ov *windows.Overlapped, done *uint32) error) (int, error) {
+	c.running.RLock()
+	defer c.running.RUnlock()
+
+	var n uint32
+	err := waitOverlapped(c.handle, &n, c.closing.Load, start)
+	return int(n), err
+}
+
+// waitOverlapped starts an overlapped operation on handle and waits for it to complete.
+// An operation that starts after closing began is cancelled here, as the CancelIoEx of
+// the closer may have run before it started. The OVERLAPPED structure lives on the heap,
+// where the kernel can write to it while the goroutine stack moves.
+func waitOverlapped(handle windows.Handle, done *uint32, closing func() bool, start func(ov *windows.Overlapped, done *uint32) error) error {
+	if closing() {
+		return errPipeClosed
+	}
+
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return fmt.Errorf("creating event: %w", err)
+	}
+	defer windows.CloseHandle(event)
+
+	ov := &windows.Overlapped{HEvent: event}
+	err = start(ov, done)
+	if err == windows.ERROR_IO_PENDING {
+		if closing() {
+			windows.CancelIoEx(handle, ov)
+		}
+		err = windows.GetOverlappedResult(handle, ov, done, true)
+	}
+	if err == windows.ERROR_OPERATION_ABORTED {
+		err = errPipeClosed
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package control
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// ResponseEnd terminates the response to every command sent over the control endpoint
+	ResponseEnd = "END"
+
+	commandAuth      = "auth"
+	commandSubscribe = "subscribe"
+	commandExit      = "exit"
+)
+
+// Listener accepts client connections on a platform specific local endpoint
+type Listener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+	Addr() string
+}
+
+// Server exposes the daemon protocol on a local control endpoint. Clients must
+// authenticate with the token from the token file before sending commands.
+type Server struct {
+	hub       *Hub
+	listener  Listener
+	token     string
+	tokenFile string
+	idMutex   sync.Mutex
+	nextID    int
+}
+
+// NewServer starts listening on address and writes a fresh access token to tokenFile
+func NewServer(hub *Hub, address, tokenFile string) (*Server, error) {
+	token, err := GenerateToken()
+	if err != nil {
+		return nil, fmt.Errorf("generating control token: %w", err)
+	}
+
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("writing control token file: %w", err)
+	}
+
+	listener, err := Listen(address)
+	if err != nil {
+		os.Remove(tokenFile)
+		return nil, err
+	}
+
+	return &Server{
+		hub:       hub,
+		listener:  listener,
+		token:     token,
+		tokenFile: tokenFile,
+	}, nil
+}
+
+// Addr returns the address of the control endpoint
+func (s *Server) Addr() string {
+	return s.listener.Addr()
+}
+
+// Serve accepts clients until the listener is closed
+func (s *Server) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn, s.clientID())
+	}
+}
+
+// Close stops accepting clients and removes the token file
+func (s *Server) Close() error {
+	os.Remove(s.tokenFile)
+	return s.listener.Close()
+}
+
+func (s *Server) clientID() string {
+	s.idMutex.Lock()
+	defer s.idMutex.Unlock()
+	s.nextID++
+	return fmt.Sprintf("control-%d", s.nextID)
+}
+
+func (s *Server) handleConn(conn io.ReadWriteCloser, source string) {
+	defer conn.Close()
+
+	var writeMutex sync.Mutex
+	writeLines := func(lines ...string) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(conn, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(conn)
+
+	if !scanner.Scan() || !s.authorized(scanner.Text()) {
+		writeLines("ERROR: unauthorized", ResponseEnd)
+		fmt.Printf("Rejected unauthorized control client %s\n", source)
+		return
+	}
+	writeLines("OK", ResponseEnd)
+	fmt.Printf("Control client %s connected\n", source)
+
+	var unsubscribe func()
+	defer func() {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+		fmt.Printf("Control client %s disconnected\n", source)
+	}()
+
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+
+		switch command {
+		case commandSubscribe:
+			if unsubscribe == nil {
+				var events <-chan string
+				events, unsubscribe = s.hub.Subscribe()
+				go func() {
+					for event := range events {
+						if err := writeLines(event); err != nil {
+							return
+						}
+					}
+				}()
+			}
+			writeLines("OK", ResponseEnd)
+
+		case commandExit:
+			writeLines("ERROR: exit is only accepted from the parent process", ResponseEnd)
+
+		default:
+			if err := writeLines(s.hub.Execute(source, command), ResponseEnd); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) authorized(line string) bool {
+	command, token, found := strings.Cut(strings.TrimSpace(line), "|")
+	if !found || command != commandAuth {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// GenerateToken returns a random hex encoded access token
+func GenerateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
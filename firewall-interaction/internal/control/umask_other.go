@@ -0,0 +1,8 @@
+//go:build !unix
+
+package control
+
+// restrictUmask does nothing on systems without a umask
+func restrictUmask() (restore func()) {
+	return func() {}
+}
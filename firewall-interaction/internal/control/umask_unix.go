@@ -0,0 +1,13 @@
+//go:build unix
+
+package control
+
+import "syscall"
+
+// restrictUmask makes files created until restore is called readable and writable by
+// the current user only. The umask is process wide, so files other goroutines create
+// meanwhile are restricted as well.
+func restrictUmask() (restore func()) {
+	old := syscall.Umask(0177)
+	return func() { syscall.Umask(old) }
+}
@@ -0,0 +1,114 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	BackendNetsh  = "netsh"
+	BackendMemory = "memory"
+)
+
+// Backend creates and removes block rules in a concrete firewall
+type Backend interface {
+	Name() string
+	AddRule(rule Rule) error
+	// DeleteRule removes every rule with the name
+	DeleteRule(name string) error
+	// RuleNames lists the name of every rule matching prefix, once per rule, so a name
+	// shared by the rules of several programs is listed several times
+	RuleNames(prefix string) ([]string, error)
+	Rules(prefix string) ([]Rule, error)
+}
+
+// NewBackend returns the backend with the given name
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case BackendNetsh:
+		return NewNetshBackend(), nil
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown firewall backend '%s'", name)
+	}
+}
+
+// MemoryBackend keeps rules in memory. It never touches the system firewall and is
+// used for tests and dry runs.
+type MemoryBackend struct {
+	mutex sync.Mutex
+	rules []Rule
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+func (m *MemoryBackend) Name() string {
+	return BackendMemory
+}
+
+func (m *MemoryBackend) AddRule(rule Rule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name cannot be empty")
+	}
+
+	rule.RemoteIPs = append([]string(nil), rule.RemoteIPs...)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+// DeleteRule removes every rule with the given name, like netsh does
+func (m *MemoryBackend) DeleteRule(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	kept := m.rules[:0]
+	found := false
+	for _, rule := range m.rules {
+		if rule.Name == name {
+			found = true
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	m.rules = kept
+
+	if !found {
+		return fmt.Errorf("no rules match the specified criteria: %s", name)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) RuleNames(prefix string) ([]string, error) {
+	rules, _ := m.Rules(prefix)
+
+	names := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		names = append(names, rule.Name)
+	}
+	return names, nil
+}
+
+func (m *MemoryBackend) Rules(prefix string) ([]Rule, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rules := make([]Rule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		if strings.HasPrefix(rule.Name, prefix) {
+			rule.RemoteIPs = append([]string(nil), rule.RemoteIPs...)
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+	return rules, nil
+}
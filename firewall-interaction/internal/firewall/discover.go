@@ -0,0 +1,54 @@
+package firewall
+
+import (
+	"errors"
+
+	"quidque.no/ow-firewall-sidecar/internal/gamefile"
+	"quidque.no/ow-vpn-shared/discovery"
+)
+
+// DiscoveredExecutable is a discovery candidate with the result of verifying it
+type DiscoveredExecutable struct {
+	discovery.Candidate `yaml:",inline"`
+	Verified            bool   `json:"verified" yaml:"verified"`
+	Problem             string `json:"problem,omitempty" yaml:"problem,omitempty"`
+}
+
+// DiscoveryResult lists the Overwatch installs found without the game running
+type DiscoveryResult struct {
+	Candidates []DiscoveredExecutable `json:"candidates" yaml:"candidates"`
+	Warnings   []string               `json:"warnings" yaml:"warnings"`
+}
+
+// Discover searches env for Overwatch installs and verifies each candidate the way
+// set-path would, without changing the configured path
+func (f *Firewall) Discover(env discovery.Environment) DiscoveryResult {
+	result := DiscoveryResult{
+		Candidates: []DiscoveredExecutable{},
+		Warnings:   []string{},
+	}
+
+	var allowlist []string
+	if cfg, err := f.store.Load(); err == nil {
+		allowlist = cfg.ExecutableAllowlist
+	}
+
+	candidates, warnings := discovery.Discover(env, discovery.Overwatch)
+	for _, warning := range warnings {
+		result.Warnings = append(result.Warnings, warning.Error())
+	}
+
+	for _, candidate := range candidates {
+		discovered := DiscoveredExecutable{Candidate: candidate, Verified: true}
+		if _, err := gamefile.Verify(env.Local(candidate.Path), gamefile.Overwatch, allowlist); err != nil {
+			discovered.Verified = false
+			discovered.Problem = err.Error()
+			var refused *gamefile.VerificationError
+			if errors.As(err, &refused) {
+				discovered.Problem = refused.Reason
+			}
+		}
+		result.Candidates = append(result.Candidates, discovered)
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"quidque.no/ow-vpn-shared/configstore"
+	"quidque.no/ow-vpn-shared/discovery"
+)
+
+// discoveryFixtures are the fixture trees of the shared discovery package. Their
+// Overwatch.exe files are minimal PE files with an Overwatch version resource.
+var discoveryFixtures = filepath.Join("..", "..", "..", "shared", "discovery", "testdata")
+
+func TestDiscoverVerifiesCandidates(t *testing.T) {
+	store, err := configstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := NewWithBackend(NewMemoryBackend(), store)
+
+	for _, fixture := range []string{"battlenet", "steam"} {
+		result := fw.Discover(discovery.FixtureEnvironment(filepath.Join(discoveryFixtures, fixture)))
+		if len(result.Candidates) != 1 || len(result.Warnings) != 0 {
+			t.Fatalf("%s: discovered %+v", fixture, result)
+		}
+		if candidate := result.Candidates[0]; !candidate.Verified || candidate.Problem != "" {
+			t.Errorf("%s: %s not verified: %s", fixture, candidate.Path, candidate.Problem)
+		}
+	}
+}
+
+func TestDiscoverReportsUnverifiedCandidates(t *testing.T) {
+	store, err := configstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := NewWithBackend(NewMemoryBackend(), store)
+
+	root := t.TempDir()
+	exe := filepath.Join(root, "C", "Program Files (x86)", "Overwatch", "_retail_", "Overwatch.exe")
+	if err := os.MkdirAll(filepath.Dir(exe), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(exe, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := fw.Discover(discovery.FixtureEnvironment(root))
+	if len(result.Candidates) != 1 {
+		t.Fatalf("discovered %+v", result)
+	}
+	candidate := result.Candidates[0]
+	if candidate.Verified || !strings.HasPrefix(candidate.Problem, "not a Windows executable") {
+		t.Errorf("candidate = %+v, want unverified as not a Windows executable", candidate)
+	}
+}
@@ -2,24 +2,37 @@ package firewall
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
-	"syscall"
+	"time"
 
 	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-firewall-sidecar/internal/gamefile"
+	"quidque.no/ow-vpn-shared/configstore"
+	"quidque.no/ow-vpn-shared/iprange"
 )
 
 type Firewall struct {
-	rulePrefix   string
-	exePath      string
-	exePathMutex sync.RWMutex
-	configFile   string
+	backend       Backend
+	namespace     string
+	rulePrefix    string
+	targets       []configstore.Target
+	targetsMutex  sync.RWMutex
+	store         *configstore.Store
+	blocksFile    string
+	persistent    bool
+	unrestored    map[string]RegionStatus
+	regions       map[string]RegionStatus
+	pending       map[*Operation]*Operation
+	lastOperation *Operation
+	history       []Operation
+	stateMutex    sync.Mutex
 }
 
 const (
@@ -28,37 +41,53 @@ const (
 	defaultBatchSize = 25
 )
 
-func New() *Firewall {
+func New(store *configstore.Store) *Firewall {
+	return NewWithBackend(NewNetshBackend(), store)
+}
+
+// NewWithBackend creates a firewall that manages its rules through the given backend and
+// keeps its settings in store
+func NewWithBackend(backend Backend, store *configstore.Store) *Firewall {
 	fw := &Firewall{
+		backend:    backend,
 		rulePrefix: config.FirewallRulePrefix,
-		exePath:    "",
-		configFile: "config.json",
+		targets:    []configstore.Target{},
+		store:      store,
+		blocksFile: store.Path(config.DefaultBlocksFile),
+		unrestored: make(map[string]RegionStatus),
+		regions:    make(map[string]RegionStatus),
+		pending:    make(map[*Operation]*Operation),
 	}
 
-	fw.loadPathFromConfig()
+	fw.loadConfig()
+	fw.loadNamespace()
 	return fw
 }
 
-func (f *Firewall) loadPathFromConfig() bool {
-	data, err := os.ReadFile(f.configFile)
+func (f *Firewall) loadConfig() bool {
+	cfg, err := f.store.Load()
 	if err != nil {
+		fmt.Printf("Warning: Failed to load config: %v\n", err)
 		return false
 	}
 
-	var cfg config.Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return false
-	}
+	f.stateMutex.Lock()
+	f.persistent = cfg.PersistentBlocks
+	f.stateMutex.Unlock()
 
-	if cfg.OverwatchPath != "" && fileExists(cfg.OverwatchPath) {
-		f.exePathMutex.Lock()
-		f.exePath = cfg.OverwatchPath
-		f.exePathMutex.Unlock()
-		fmt.Printf("Loaded Overwatch path: %s\n", cfg.OverwatchPath)
-		return true
-	}
+	return f.loadTargets(cfg)
+}
 
-	return false
+// verifyExecutable checks that path is the Overwatch executable, printing any warnings
+func (f *Firewall) verifyExecutable(path string, allowlist []string) (*configstore.ExecutableInfo, error) {
+	info, err := gamefile.Verify(path, gamefile.Overwatch, allowlist)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range info.Warnings {
+		fmt.Printf("Warning: %s: %s\n", filepath.Base(path), warning)
+	}
+	return info, nil
 }
 
 func fileExists(filename string) bool {
@@ -69,132 +98,140 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-func (f *Firewall) updateConfigFile(path string) {
-	data, err := os.ReadFile(f.configFile)
-	if err == nil {
-		var cfg config.Config
-		if err := json.Unmarshal(data, &cfg); err == nil {
-			cfg.OverwatchPath = path
-			if newData, err := json.MarshalIndent(cfg, "", "  "); err == nil {
-				os.WriteFile(f.configFile, newData, 0644)
-			}
-		}
-	} else {
-		cfg := config.Config{
-			OverwatchPath:    path,
-			UseGithubSource:  false,
-			InitialSetupDone: true,
-		}
-		if newData, err := json.MarshalIndent(cfg, "", "  "); err == nil {
-			os.WriteFile(f.configFile, newData, 0644)
-		}
+// updateConfig applies update to the shared config, keeping settings written by the GUI
+func (f *Firewall) updateConfig(update func(cfg *configstore.Config)) {
+	_, err := f.store.Update(func(cfg *configstore.Config) error {
+		update(cfg)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Warning: Failed to save config: %v\n", err)
 	}
 }
 
-func (f *Firewall) SetOverwatchPath(path string) error {
-	if path == "" {
-		return fmt.Errorf("path cannot be empty")
+func (f *Firewall) BlockIPs(region string, ipListDir string) (err error) {
+	done := f.beginOperation(config.ActionBlock, region)
+	defer func() { done(err) }()
+
+	if !f.HasOverwatchPath() {
+		return fmt.Errorf("overwatch path not configured")
 	}
 
-	if !fileExists(path) {
-		return fmt.Errorf("path does not exist: %s", path)
+	validIPs, err := readRegionIPs(region, ipListDir)
+	if err != nil {
+		return err
 	}
 
-	f.exePathMutex.Lock()
-	defer f.exePathMutex.Unlock()
+	fmt.Printf("Found %d valid IPs to block for region %s\n", len(validIPs), region)
 
-	fmt.Printf("Setting Overwatch path to: %s\n", path)
-	f.exePath = path
+	programs := f.TargetPaths()
+	if len(programs) == 0 {
+		return fmt.Errorf("no configured overwatch executable exists anymore")
+	}
 
-	f.updateConfigFile(path)
+	if err := f.removeRules(region); err != nil {
+		fmt.Printf("Warning: Failed to clean up existing rules: %v\n", err)
+	}
+	f.recordUnblocked(region)
+
+	totalSuccessRules, err := f.createRules(region, validIPs, programs, config.ProtocolAny)
+	if err != nil {
+		return err
+	}
+
+	f.recordBlocked(RegionStatus{
+		Region:    region,
+		RuleCount: totalSuccessRules,
+		IPCount:   len(validIPs),
+		IPListDir: ipListDir,
+		BlockedAt: time.Now(),
+	})
+
+	fmt.Printf("Successfully blocked %d IPs (%d IPv6) for region %s (%d rules created)\n", len(validIPs), countIPv6(validIPs), region, totalSuccessRules/2)
 	return nil
 }
 
-func (f *Firewall) GetOverwatchPath() string {
-	f.exePathMutex.RLock()
-	defer f.exePathMutex.RUnlock()
-	return f.exePath
-}
+// ErrNoIPList is returned when the IP list directory has no file for a region
+var ErrNoIPList = errors.New("ip list file not found")
 
-func (f *Firewall) HasOverwatchPath() bool {
-	f.exePathMutex.RLock()
-	defer f.exePathMutex.RUnlock()
-	return f.exePath != "" && fileExists(f.exePath)
-}
+// ErrInvalidRegion is returned for region names that are not region codes, such as
+// names with path separators that would reach outside the IP list directory
+var ErrInvalidRegion = errors.New("invalid region name")
 
-func (f *Firewall) BlockIPs(region string, ipListDir string) error {
-	if !f.HasOverwatchPath() {
-		return fmt.Errorf("overwatch path not configured")
+var validRegionName = regexp.MustCompile(config.RegionNamePattern)
+
+// readRegionIPs reads and validates the IP list file for a region
+func readRegionIPs(region string, ipListDir string) ([]string, error) {
+	if !validRegionName.MatchString(region) {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidRegion, region)
 	}
+	fileName := fmt.Sprintf("%s.txt", region)
+	filePath := filepath.Join(ipListDir, fileName)
 
-	filePath := filepath.Join(ipListDir, fmt.Sprintf("%s.txt", region))
+	file, err := openIPList(ipListDir, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoIPList, filePath)
+	}
+	defer file.Close()
 
-	fileInfo, err := os.Stat(filePath)
+	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("ip list file not found: %s", filePath)
+		return nil, fmt.Errorf("%w: %s", ErrNoIPList, filePath)
 	}
 	if fileInfo.Size() == 0 {
-		return fmt.Errorf("ip list file is empty: %s", filePath)
+		return nil, fmt.Errorf("ip list file is empty: %s", filePath)
 	}
 
-	ips, err := readIPsFromFile(filePath)
+	ips, err := readIPs(file)
 	if err != nil {
-		return fmt.Errorf("failed to read ip list: %w", err)
+		return nil, fmt.Errorf("failed to read ip list: %w", err)
 	}
 
 	if len(ips) == 0 {
-		return fmt.Errorf("no valid IPs found in file: %s", filePath)
+		return nil, fmt.Errorf("no valid IPs found in file: %s", filePath)
 	}
 
 	// Validate IPs before blocking
-	validIPs := validateIPs(ips)
+	validIPs, invalid := validateIPs(ips)
 	if len(validIPs) == 0 {
-		return fmt.Errorf("no valid IPs found after validation in: %s", filePath)
-	}
-
-	if len(validIPs) < len(ips) {
-		fmt.Printf("Warning: Removed %d invalid IPs from %s\n", len(ips)-len(validIPs), filePath)
+		return nil, fmt.Errorf("no valid IPs found after validation in: %s", filePath)
 	}
 
-	fmt.Printf("Found %d valid IPs to block for region %s\n", len(validIPs), region)
-
-	f.exePathMutex.RLock()
-	exePath := f.exePath
-	f.exePathMutex.RUnlock()
-
-	if !fileExists(exePath) {
-		return fmt.Errorf("overwatch executable no longer exists: %s", exePath)
+	if invalid != nil {
+		fmt.Printf("Warning: Removed %d invalid IPs from %s: %v\n", len(ips)-len(validIPs), filePath, invalid)
 	}
 
-	if err := f.removeRules(region); err != nil {
-		fmt.Printf("Warning: Failed to clean up existing rules: %v\n", err)
-	}
+	return validIPs, nil
+}
 
+// createRules adds inbound and outbound block rules for every program, batching the IPs
+// into as few rules as possible, and returns the number of rules created
+func (f *Firewall) createRules(region string, ips []string, programs []string, protocol string) (int, error) {
 	// Calculate optimal batch size based on number of IPs
 	batchSize := defaultBatchSize
-	if len(validIPs) > 1000 {
+	if len(ips) > 1000 {
 		batchSize = maxBatchSize
-	} else if len(validIPs) < 100 {
+	} else if len(ips) < 100 {
 		batchSize = 10
 	}
 
-	totalBatches := (len(validIPs) + batchSize - 1) / batchSize
+	totalBatches := (len(ips) + batchSize - 1) / batchSize
 
-	fmt.Printf("Processing %d IPs in %d batches\n", len(validIPs), totalBatches)
+	fmt.Printf("Processing %d IPs in %d batches\n", len(ips), totalBatches)
 
 	var wg sync.WaitGroup
-	errChan := make(chan error, totalBatches*2)
-	successCount := make(chan int, totalBatches*2)
+	errChan := make(chan error, totalBatches*2*len(programs))
+	successCount := make(chan int, totalBatches*2*len(programs))
 
 	sem := make(chan struct{}, maxConcurrent)
 
-	for i := 0; i < len(validIPs); i += batchSize {
+	for i := 0; i < len(ips); i += batchSize {
 		end := i + batchSize
-		if end > len(validIPs) {
-			end = len(validIPs)
+		if end > len(ips) {
+			end = len(ips)
 		}
 
-		batch := validIPs[i:end]
+		batch := ips[i:end]
 		batchNum := i/batchSize + 1
 
 		wg.Add(1)
@@ -204,36 +241,22 @@ func (f *Firewall) BlockIPs(region string, ipListDir string) error {
 			defer func() { <-sem }()
 
 			ruleName := fmt.Sprintf("%s%s-Batch%d", f.rulePrefix, region, batchNum)
-			ipList := strings.Join(batch, ",")
-
-			// Create outbound rule
-			output, err := f.executeFirewallCmd("add", "rule",
-				"name="+ruleName,
-				"dir=out",
-				"action=block",
-				"program="+exePath,
-				"remoteip="+ipList)
-
-			if err != nil {
-				errChan <- fmt.Errorf("failed to create outbound rule (batch %d): %v\n%s", batchNum, err, output)
-				return
-			}
-			successCount <- 1
-
-			// Create inbound rule
-			output, err = f.executeFirewallCmd("add", "rule",
-				"name="+ruleName+"-In",
-				"dir=in",
-				"action=block",
-				"program="+exePath,
-				"remoteip="+ipList)
-
-			if err != nil {
-				errChan <- fmt.Errorf("failed to create inbound rule (batch %d): %v\n%s", batchNum, err, output)
-				return
-			}
-			successCount <- 1
 
+			for _, program := range programs {
+				// Create outbound rule
+				if err := f.addRule(ruleName, "out", program, protocol, batch); err != nil {
+					errChan <- fmt.Errorf("failed to create outbound rule (batch %d): %v", batchNum, err)
+					return
+				}
+				successCount <- 1
+
+				// Create inbound rule
+				if err := f.addRule(ruleName+"-In", "in", program, protocol, batch); err != nil {
+					errChan <- fmt.Errorf("failed to create inbound rule (batch %d): %v", batchNum, err)
+					return
+				}
+				successCount <- 1
+			}
 		}(batch, batchNum)
 	}
 
@@ -252,65 +275,62 @@ func (f *Firewall) BlockIPs(region string, ipListDir string) error {
 	}
 
 	if len(errors) > 0 {
-		return fmt.Errorf("failed to create %d rules: %v", len(errors), errors[0])
+		return totalSuccessRules, fmt.Errorf("failed to create %d rules: %v", len(errors), errors[0])
 	}
 
-	fmt.Printf("Successfully blocked %d IPs for region %s (%d rules created)\n", len(validIPs), region, totalSuccessRules/2)
-	return nil
+	return totalSuccessRules, nil
 }
 
-func validateIPs(ips []string) []string {
-	validIPs := make([]string, 0, len(ips))
-
-	for _, ip := range ips {
-		// For CIDR notation (192.168.1.0/24)
-		if strings.Contains(ip, "/") {
-			parts := strings.Split(ip, "/")
-			if len(parts) != 2 {
-				continue
-			}
-
-			// Check if IP part is valid
-			if net.ParseIP(strings.TrimSpace(parts[0])) == nil {
-				continue
-			}
-
-			validIPs = append(validIPs, ip)
-			continue
-		}
-
-		// For range notation (192.168.1.1-192.168.1.10)
-		if strings.Contains(ip, "-") {
-			parts := strings.Split(ip, "-")
-			if len(parts) != 2 {
-				continue
-			}
+func (f *Firewall) addRule(name, direction, program, protocol string, ips []string) error {
+	return f.backend.AddRule(Rule{
+		Name:      name,
+		Direction: direction,
+		Program:   program,
+		Protocol:  protocol,
+		RemoteIPs: ips,
+	})
+}
 
-			// Check if both IPs are valid
-			if net.ParseIP(strings.TrimSpace(parts[0])) == nil ||
-				net.ParseIP(strings.TrimSpace(parts[1])) == nil {
-				continue
-			}
+// validateIPs returns the ranges iprange accepts in their canonical form, so netsh
+// only ever sees CIDR prefixes and a-b ranges without spaces or IPv4-mapped addresses,
+// and an *iprange.ListError describing the ones it dropped
+func validateIPs(ips []string) ([]string, error) {
+	ranges, err := iprange.ParseAll(ips)
 
-			validIPs = append(validIPs, ip)
-			continue
-		}
+	validIPs := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		validIPs = append(validIPs, r.String())
+	}
+	return validIPs, err
+}
 
-		// For single IP
-		if net.ParseIP(strings.TrimSpace(ip)) != nil {
-			validIPs = append(validIPs, ip)
+// countIPv6 returns how many of the validated ranges are IPv6
+func countIPv6(ips []string) int {
+	count := 0
+	for _, ip := range ips {
+		if r, err := iprange.Parse(ip); err == nil && !r.Is4() {
+			count++
 		}
 	}
-
-	return validIPs
+	return count
 }
 
-func (f *Firewall) UnblockIPs(region string) error {
+func (f *Firewall) UnblockIPs(region string) (err error) {
+	done := f.beginOperation(config.ActionUnblock, region)
+	defer func() { done(err) }()
+
 	fmt.Printf("Unblocking region: %s\n", region)
-	return f.removeRules(region)
+	if err := f.removeRules(region); err != nil {
+		return err
+	}
+	f.recordUnblocked(region)
+	return nil
 }
 
-func (f *Firewall) UnblockAll() error {
+func (f *Firewall) UnblockAll() (err error) {
+	done := f.beginOperation(config.ActionUnblockAll, "")
+	defer func() { done(err) }()
+
 	fmt.Println("Unblocking all regions...")
 
 	rules, err := f.listRules()
@@ -320,6 +340,7 @@ func (f *Firewall) UnblockAll() error {
 
 	if len(rules) == 0 {
 		fmt.Println("No firewall rules found to remove")
+		f.recordUnblocked("")
 		return nil
 	}
 
@@ -337,9 +358,8 @@ func (f *Firewall) UnblockAll() error {
 				sem <- struct{}{}
 				defer func() { <-sem }()
 
-				output, err := f.executeFirewallCmd("delete", "rule", "name="+rule)
-				if err != nil {
-					errChan <- fmt.Errorf("failed to delete rule %s: %v\nOutput: %s", rule, err, output)
+				if err := f.backend.DeleteRule(rule); err != nil {
+					errChan <- fmt.Errorf("failed to delete rule %s: %v", rule, err)
 					return
 				}
 				successCount <- 1
@@ -384,18 +404,25 @@ func (f *Firewall) UnblockAll() error {
 		return fmt.Errorf("%d rules still remain after cleanup", remaining)
 	}
 
+	f.recordUnblocked("")
 	return nil
 }
 
-func readIPsFromFile(filePath string) ([]string, error) {
-	file, err := os.Open(filePath)
+// readIPListFile reads the ranges of a file in the IP list directory
+func readIPListFile(ipListDir, name string) ([]string, error) {
+	file, err := openIPList(ipListDir, name)
 	if err != nil {
 		return nil, fmt.Errorf("opening file: %w", err)
 	}
 	defer file.Close()
 
+	return readIPs(file)
+}
+
+// readIPs reads one range per line, skipping blank lines and # comments
+func readIPs(r io.Reader) ([]string, error) {
 	var ips []string
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line != "" && !strings.HasPrefix(line, "#") {
@@ -413,7 +440,7 @@ func readIPsFromFile(filePath string) ([]string, error) {
 func (f *Firewall) removeRules(region string) error {
 	prefix := f.rulePrefix
 	if region != "" {
-		prefix = prefix + region
+		prefix = prefix + region + "-"
 	}
 
 	rules, err := f.listRules()
@@ -449,9 +476,8 @@ func (f *Firewall) removeRules(region string) error {
 				sem <- struct{}{}
 				defer func() { <-sem }()
 
-				output, err := f.executeFirewallCmd("delete", "rule", "name="+rule)
-				if err != nil {
-					errChan <- fmt.Errorf("failed to delete rule %s: %v\nOutput: %s", rule, err, output)
+				if err := f.backend.DeleteRule(rule); err != nil {
+					errChan <- fmt.Errorf("failed to delete rule %s: %v", rule, err)
 					return
 				}
 				successCount <- 1
@@ -486,50 +512,25 @@ func (f *Firewall) removeRules(region string) error {
 	return nil
 }
 
+// listRules returns the names of this installation's rules, each once
 func (f *Firewall) listRules() ([]string, error) {
-	output, err := f.executeFirewallCmd("show", "rule", "name=all")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list firewall rules: %w", err)
-	}
-
-	var rules []string
-	lines := strings.Split(output, "\n")
-
-	for _, line := range lines {
-		if strings.Contains(line, "Rule Name:") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				ruleName := strings.TrimSpace(parts[1])
-				if strings.HasPrefix(ruleName, f.rulePrefix) {
-					rules = append(rules, ruleName)
-				}
-			}
-		}
-	}
-
-	return rules, nil
-}
-
-func (f *Firewall) executeFirewallCmd(args ...string) (string, error) {
-	cmdArgs := append([]string{"advfirewall", "firewall"}, args...)
-	cmd := exec.Command("netsh", cmdArgs...)
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		HideWindow: true,
-	}
-
-	output, err := cmd.CombinedOutput()
+	names, err := f.backend.RuleNames(f.rulePrefix)
 	if err != nil {
-		return string(output), fmt.Errorf("command execution failed: %w", err)
+		return nil, err
 	}
-
-	return string(output), nil
+	return uniqueRuleNames(names), nil
 }
 
-func IsAdminPrivilegesAvailable() bool {
-	cmd := exec.Command("net", "session")
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		HideWindow: true,
+// uniqueRuleNames drops repeated names. A rule name is shared by the rules of every
+// target, and deleting the name once removes all of them.
+func uniqueRuleNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
 	}
-	return cmd.Run() == nil
+	return unique
 }
@@ -0,0 +1,194 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-firewall-sidecar/internal/snapshot"
+	"quidque.no/ow-vpn-shared/iprange"
+)
+
+// customListPrefix marks rules that were created from a snapshot custom list
+const customListPrefix = "Custom-"
+
+// ImportResult reports how a snapshot was applied and what could not be resolved locally
+type ImportResult struct {
+	Targets            []string            `json:"targets" yaml:"targets"`
+	AppliedRegions     []string            `json:"appliedRegions" yaml:"appliedRegions"`
+	AppliedCustomLists []string            `json:"appliedCustomLists" yaml:"appliedCustomLists"`
+	RuleCount          int                 `json:"ruleCount" yaml:"ruleCount"`
+	UnresolvedTargets  []string            `json:"unresolvedTargets" yaml:"unresolvedTargets"`
+	UnresolvedRegions  []string            `json:"unresolvedRegions" yaml:"unresolvedRegions"`
+	UnresolvedRanges   map[string][]string `json:"unresolvedRanges" yaml:"unresolvedRanges"`
+}
+
+type importStep struct {
+	name   string
+	ips    []string
+	ipDir  string
+	custom bool
+}
+
+// Import replaces the current block state with the one described by a snapshot.
+// Regions are resolved against the local IP lists in ipListDir; if any rule cannot be
+// created the previous rules are restored.
+func (f *Firewall) Import(snap snapshot.Snapshot, ipListDir string) (result ImportResult, err error) {
+	done := f.beginOperation(config.ActionImport, "")
+	defer func() { done(err) }()
+
+	if err := snap.Validate(); err != nil {
+		return result, err
+	}
+
+	result = ImportResult{
+		Targets:            []string{},
+		AppliedRegions:     []string{},
+		AppliedCustomLists: []string{},
+		UnresolvedTargets:  []string{},
+		UnresolvedRegions:  []string{},
+		UnresolvedRanges:   make(map[string][]string),
+	}
+
+	for _, target := range snap.Targets {
+		if fileExists(target) {
+			result.Targets = append(result.Targets, target)
+		} else {
+			result.UnresolvedTargets = append(result.UnresolvedTargets, target)
+		}
+	}
+	if len(result.Targets) == 0 {
+		if !f.HasOverwatchPath() {
+			return result, fmt.Errorf("no snapshot target exists locally and overwatch path not configured")
+		}
+		result.Targets = append(result.Targets, f.TargetPaths()...)
+	}
+
+	protocol := snap.Options.Protocol
+	if protocol == "" {
+		protocol = config.ProtocolAny
+	}
+
+	var steps []importStep
+
+	for _, region := range snap.Regions {
+		localIPs, err := readRegionIPs(region.Name, ipListDir)
+		if err != nil {
+			fmt.Printf("Warning: Could not resolve region %s: %v\n", region.Name, err)
+			result.UnresolvedRegions = append(result.UnresolvedRegions, region.Name)
+			continue
+		}
+
+		local := make(map[string]bool, len(localIPs))
+		for _, ip := range localIPs {
+			local[ip] = true
+		}
+		for _, ip := range region.Ranges {
+			if !local[normalizeRange(ip)] {
+				result.UnresolvedRanges[region.Name] = append(result.UnresolvedRanges[region.Name], ip)
+			}
+		}
+
+		steps = append(steps, importStep{name: region.Name, ips: localIPs, ipDir: ipListDir})
+	}
+
+	for _, list := range snap.CustomLists {
+		validIPs, _ := validateIPs(list.Ranges)
+		for _, ip := range list.Ranges {
+			if _, err := iprange.Parse(ip); err != nil {
+				result.UnresolvedRanges[customListPrefix+list.Name] = append(result.UnresolvedRanges[customListPrefix+list.Name], ip)
+			}
+		}
+
+		if len(validIPs) == 0 {
+			result.UnresolvedRegions = append(result.UnresolvedRegions, customListPrefix+list.Name)
+			continue
+		}
+		steps = append(steps, importStep{name: customListPrefix + list.Name, ips: validIPs, custom: true})
+	}
+
+	if len(steps) == 0 {
+		return result, fmt.Errorf("none of the regions or custom lists in the snapshot could be resolved locally")
+	}
+
+	previous, err := f.Rules()
+	if err != nil {
+		return result, fmt.Errorf("failed to read current rules: %w", err)
+	}
+
+	if err := f.removeRules(""); err != nil {
+		return result, fmt.Errorf("failed to remove current rules: %w", err)
+	}
+	f.recordUnblocked("")
+
+	applied := make([]RegionStatus, 0, len(steps))
+	for _, step := range steps {
+		ruleCount, err := f.createRules(step.name, step.ips, result.Targets, protocol)
+		if err != nil {
+			f.rollbackImport(previous)
+			return result, fmt.Errorf("failed to apply %s, previous rules restored: %w", step.name, err)
+		}
+
+		applied = append(applied, RegionStatus{
+			Region:    step.name,
+			RuleCount: ruleCount,
+			IPCount:   len(step.ips),
+			IPListDir: step.ipDir,
+			BlockedAt: time.Now(),
+		})
+		result.RuleCount += ruleCount
+
+		if step.custom {
+			result.AppliedCustomLists = append(result.AppliedCustomLists, strings.TrimPrefix(step.name, customListPrefix))
+		} else {
+			result.AppliedRegions = append(result.AppliedRegions, step.name)
+		}
+	}
+
+	for _, region := range applied {
+		f.recordBlocked(region)
+	}
+
+	fmt.Printf("Successfully imported snapshot (%d rules created)\n", result.RuleCount)
+	return result, nil
+}
+
+// rollbackImport removes everything created by a failed import and recreates the previous rules
+func (f *Firewall) rollbackImport(previous []Rule) {
+	fmt.Println("Import failed, restoring previous firewall rules...")
+
+	if err := f.removeRules(""); err != nil {
+		fmt.Printf("Warning: Failed to remove partially imported rules: %v\n", err)
+	}
+	f.recordUnblocked("")
+
+	restored := make(map[string]RegionStatus)
+	restoredRules := 0
+	for _, rule := range previous {
+		if err := f.addRule(rule.Name, rule.Direction, rule.Program, rule.Protocol, rule.RemoteIPs); err != nil {
+			fmt.Printf("Warning: Failed to restore rule %s: %v\n", rule.Name, err)
+			continue
+		}
+		restoredRules++
+
+		region := restored[rule.Region]
+		region.Region = rule.Region
+		region.RuleCount++
+		if rule.Direction == "out" {
+			region.IPCount += len(rule.RemoteIPs)
+		}
+		region.BlockedAt = time.Now()
+		restored[rule.Region] = region
+	}
+
+	names := make([]string, 0, len(restored))
+	for name, region := range restored {
+		f.recordBlocked(region)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Restored %d of %d rules (%s)\n", restoredRules, len(previous), strings.Join(names, ", "))
+}
@@ -0,0 +1,58 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The IP puller replaces the IP list directory by moving it aside to a directory with
+// this suffix and renaming the new lists into place. Between the two renames the
+// directory does not exist, and readers use the lists that were moved aside.
+const previousIPListSuffix = ".previous"
+
+// A reader looks for a list this often while the IP puller swaps in new lists, since
+// the previous lists are removed as soon as the new ones are in place
+const (
+	ipListAttempts   = 3
+	ipListRetryDelay = 50 * time.Millisecond
+)
+
+// openIPList opens a file of the IP list directory, or of the lists the IP puller moved
+// aside while it is swapping in new ones
+func openIPList(ipListDir, name string) (*os.File, error) {
+	var err error
+	for attempt := 0; attempt < ipListAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ipListRetryDelay)
+		}
+
+		var file *os.File
+		file, err = os.Open(filepath.Join(ipListDir, name))
+		if !os.IsNotExist(err) {
+			return file, err
+		}
+		if _, statErr := os.Stat(ipListDir); statErr == nil {
+			// The directory is in place, so the file does not exist
+			return nil, err
+		}
+
+		if previous, prevErr := os.Open(filepath.Join(ipListDir+previousIPListSuffix, name)); prevErr == nil {
+			return previous, nil
+		}
+	}
+	return nil, err
+}
+
+// readIPListDir lists the IP list directory, or the lists the IP puller moved aside
+// while it is swapping in new ones
+func readIPListDir(ipListDir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(ipListDir)
+	if !os.IsNotExist(err) {
+		return entries, err
+	}
+	if previous, prevErr := os.ReadDir(ipListDir + previousIPListSuffix); prevErr == nil {
+		return previous, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,63 @@
+package firewall
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeIPList(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIPListsReadDuringSwap(t *testing.T) {
+	// The IP puller moved the lists aside and has not renamed the new ones into place yet
+	dir := filepath.Join(t.TempDir(), "ips")
+	writeIPList(t, dir+previousIPListSuffix, map[string]string{
+		"EU.txt":      "1.2.3.0/24\n",
+		ipVersionFile: "1.0\n",
+	})
+
+	ips, err := readRegionIPs("EU", dir)
+	if err != nil || strings.Join(ips, ",") != "1.2.3.0/24" {
+		t.Errorf("readRegionIPs = %v, %v", ips, err)
+	}
+	if version := readIPListVersion(dir); version != "1.0" {
+		t.Errorf("readIPListVersion = %q, want 1.0", version)
+	}
+
+	f := &Firewall{regions: map[string]RegionStatus{}}
+	regions, err := f.AvailableRegions(dir)
+	if err != nil || len(regions) != 1 || regions[0].Region != "EU" {
+		t.Errorf("AvailableRegions = %v, %v", regions, err)
+	}
+}
+
+func TestIPListsPreferTheCurrentDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ips")
+	writeIPList(t, dir, map[string]string{"EU.txt": "5.6.7.0/24\n"})
+	writeIPList(t, dir+previousIPListSuffix, map[string]string{
+		"EU.txt": "1.2.3.0/24\n",
+		"NA.txt": "9.9.9.0/24\n",
+	})
+
+	ips, err := readRegionIPs("EU", dir)
+	if err != nil || strings.Join(ips, ",") != "5.6.7.0/24" {
+		t.Errorf("readRegionIPs(EU) = %v, %v, want the current list", ips, err)
+	}
+
+	// A region the new lists dropped is gone, even though the old lists still have it
+	if _, err := readRegionIPs("NA", dir); !errors.Is(err, ErrNoIPList) {
+		t.Errorf("readRegionIPs(NA) error = %v, want ErrNoIPList", err)
+	}
+}
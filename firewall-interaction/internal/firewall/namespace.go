@@ -0,0 +1,202 @@
+package firewall
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// Namespace returns the namespace embedded in the names of the rules this installation manages
+func (f *Firewall) Namespace() string {
+	return f.namespace
+}
+
+// UseNamespace switches to another namespace, for example a profile given on the command line.
+// It must be called before any rules are managed.
+func (f *Firewall) UseNamespace(namespace string) error {
+	if err := configstore.ValidateNamespace(namespace); err != nil {
+		return err
+	}
+
+	f.namespace = namespace
+	f.rulePrefix = config.FirewallRulePrefix + namespace + "-"
+	f.loadSavedBlocks()
+	return nil
+}
+
+// loadNamespace reads the installation namespace from the config, generating and
+// storing a new one on first run
+func (f *Firewall) loadNamespace() {
+	generated := false
+	cfg, err := f.store.Update(func(cfg *configstore.Config) error {
+		if configstore.ValidateNamespace(cfg.Namespace) == nil {
+			return configstore.ErrUnchanged
+		}
+		namespace, err := generateNamespace()
+		if err != nil {
+			return fmt.Errorf("generating rule namespace: %w", err)
+		}
+		cfg.Namespace = namespace
+		generated = true
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Warning: Failed to load rule namespace: %v\n", err)
+		return
+	}
+
+	f.UseNamespace(cfg.Namespace)
+	if generated {
+		fmt.Printf("Generated rule namespace: %s\n", cfg.Namespace)
+		f.migrateLegacyRules()
+	}
+}
+
+// legacyRuleName matches rules created before namespaces were introduced, like
+// OW-VPN-EU-Batch1-In. Namespaced rules always have a second segment before -Batch, so a
+// legacy region with a dash in its name cannot be told apart and is left alone.
+var legacyRuleName = regexp.MustCompile(`^` + regexp.QuoteMeta(config.FirewallRulePrefix) + `([A-Za-z0-9_]+)-Batch\d+(-In)?$`)
+
+// migrateLegacyRules moves the rules created before namespaces were introduced into this
+// installation's namespace on its first namespaced start. Without it they would keep
+// blocking, but no longer show up in status or be removed by unblock. Each rule is
+// re-created under its namespaced name before the legacy one is deleted; a rule that
+// cannot be re-created is left in place and reported.
+func (f *Firewall) migrateLegacyRules() {
+	rules, err := f.backend.Rules(config.FirewallRulePrefix)
+	if err != nil {
+		fmt.Printf("Warning: Failed to look for rules from before namespaces: %v\n", err)
+		return
+	}
+
+	// netsh deletes every rule of a name at once, so rules are migrated by name
+	var names []string
+	byName := make(map[string][]Rule)
+	for _, rule := range rules {
+		if legacyRuleName.MatchString(rule.Name) {
+			if _, seen := byName[rule.Name]; !seen {
+				names = append(names, rule.Name)
+			}
+			byName[rule.Name] = append(byName[rule.Name], rule)
+		}
+	}
+
+	migrated := make(map[string][]Rule)
+	failed := 0
+	for _, legacyName := range names {
+		region := legacyRuleName.FindStringSubmatch(legacyName)[1]
+		name := f.rulePrefix + strings.TrimPrefix(legacyName, config.FirewallRulePrefix)
+
+		var added []Rule
+		var addErr error
+		for _, rule := range byName[legacyName] {
+			rule.Name = name
+			if addErr = f.backend.AddRule(rule); addErr != nil {
+				break
+			}
+			added = append(added, rule)
+		}
+		if addErr != nil {
+			fmt.Printf("Warning: Failed to migrate rule %s: %v\n", legacyName, addErr)
+			if len(added) > 0 {
+				f.backend.DeleteRule(name)
+			}
+			failed++
+			continue
+		}
+
+		migrated[region] = append(migrated[region], added...)
+		if err := f.backend.DeleteRule(legacyName); err != nil {
+			fmt.Printf("Warning: Migrated rule %s, but failed to delete it: %v\n", legacyName, err)
+		}
+	}
+
+	if len(migrated) == 0 && failed == 0 {
+		return
+	}
+
+	for region, regionRules := range migrated {
+		ips := make(map[string]bool)
+		for _, rule := range regionRules {
+			for _, ip := range rule.RemoteIPs {
+				ips[ip] = true
+			}
+		}
+		f.recordBlocked(RegionStatus{
+			Region:    region,
+			RuleCount: len(regionRules),
+			IPCount:   len(ips),
+			BlockedAt: time.Now(),
+		})
+		fmt.Printf("Migrated %d rules of region %s from before namespaces\n", len(regionRules), region)
+	}
+	if failed > 0 {
+		fmt.Printf("Warning: %d rules from before namespaces could not be migrated, remove them with purge-all\n", failed)
+	}
+}
+
+func generateNamespace() (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// PurgeAllNamespaces removes every OW-VPN rule of every installation, including rules
+// created before namespaces were introduced, and returns the number removed
+func (f *Firewall) PurgeAllNamespaces() (removed int, err error) {
+	done := f.beginOperation(config.ActionPurgeAll, "")
+	defer func() { done(err) }()
+
+	names, err := f.backend.RuleNames(config.FirewallRulePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list firewall rules: %w", err)
+	}
+	names = uniqueRuleNames(names)
+
+	fmt.Printf("Removing %d OW-VPN rules from all namespaces...\n", len(names))
+
+	var wg sync.WaitGroup
+	var countMutex sync.Mutex
+	var failures []error
+	sem := make(chan struct{}, maxConcurrent)
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, config.FirewallRulePrefix) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			err := f.backend.DeleteRule(name)
+
+			countMutex.Lock()
+			defer countMutex.Unlock()
+			if err != nil {
+				failures = append(failures, fmt.Errorf("failed to delete rule %s: %v", name, err))
+				return
+			}
+			removed++
+		}(name)
+	}
+	wg.Wait()
+
+	f.recordUnblocked("")
+
+	if len(failures) > 0 {
+		return removed, fmt.Errorf("failed to remove %d rules: %v", len(failures), failures[0])
+	}
+	return removed, nil
+}
@@ -0,0 +1,105 @@
+package firewall
+
+import (
+	"path/filepath"
+	"testing"
+
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+func TestLegacyRulesMigratedOnFirstStart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := configstore.Open(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	for _, rule := range []Rule{
+		{Name: "OW-VPN-EU-Batch1", Direction: "out", Program: `C:\bnet\Overwatch.exe`, RemoteIPs: []string{"1.2.3.0/24"}},
+		{Name: "OW-VPN-EU-Batch1", Direction: "out", Program: `C:\steam\Overwatch.exe`, RemoteIPs: []string{"1.2.3.0/24"}},
+		{Name: "OW-VPN-EU-Batch1-In", Direction: "in", Program: `C:\bnet\Overwatch.exe`, RemoteIPs: []string{"1.2.3.0/24"}},
+		{Name: "OW-VPN-EU-Batch1-In", Direction: "in", Program: `C:\steam\Overwatch.exe`, RemoteIPs: []string{"1.2.3.0/24"}},
+		// Rules of another installation are namespaced and stay where they are
+		{Name: "OW-VPN-0badcafe-NA-Batch1", Direction: "out", RemoteIPs: []string{"5.6.7.0/24"}},
+	} {
+		backend.AddRule(rule)
+	}
+
+	fw := NewWithBackend(backend, store)
+
+	names, _ := backend.RuleNames("OW-VPN-")
+	names = uniqueRuleNames(names)
+	want := map[string]bool{
+		fw.rulePrefix + "EU-Batch1":    true,
+		fw.rulePrefix + "EU-Batch1-In": true,
+		"OW-VPN-0badcafe-NA-Batch1":    true,
+	}
+	if len(names) != len(want) {
+		t.Fatalf("rules after migration = %v, want %v", names, want)
+	}
+	for _, name := range names {
+		if !want[name] {
+			t.Errorf("unexpected rule %s after migration", name)
+		}
+	}
+
+	rules, _ := fw.Rules()
+	if len(rules) != 4 {
+		t.Errorf("namespace has %d rules, want 4 (both programs, both directions)", len(rules))
+	}
+
+	status := fw.Status(dir)
+	if len(status.Regions) != 1 || status.Regions[0].Region != "EU" || status.Regions[0].RuleCount != 4 {
+		t.Fatalf("status regions = %+v, want EU with 4 rules", status.Regions)
+	}
+
+	// The migrated rules are managed now
+	if err := fw.UnblockIPs("EU"); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := backend.RuleNames(fw.rulePrefix); len(names) != 0 {
+		t.Errorf("rules left after unblock: %v", names)
+	}
+}
+
+func TestLegacyRulesOnlyMigratedOnce(t *testing.T) {
+	dir := t.TempDir()
+	store, err := configstore.Open(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryBackend()
+	NewWithBackend(backend, store)
+
+	// Rules that look like legacy ones are not touched once the namespace exists
+	backend.AddRule(Rule{Name: "OW-VPN-EU-Batch1", Direction: "out", RemoteIPs: []string{"1.2.3.0/24"}})
+	fw := NewWithBackend(backend, store)
+
+	names, _ := backend.RuleNames("OW-VPN-")
+	if len(names) != 1 || names[0] != "OW-VPN-EU-Batch1" {
+		t.Errorf("rules = %v, want only the untouched OW-VPN-EU-Batch1", names)
+	}
+	if regions := fw.Status(dir).Regions; len(regions) != 0 {
+		t.Errorf("status regions = %+v, want none", regions)
+	}
+}
+
+func TestLegacyRuleName(t *testing.T) {
+	tests := map[string]bool{
+		"OW-VPN-EU-Batch1":             true,
+		"OW-VPN-OCE-Batch12-In":        true,
+		"OW-VPN-1a2b3c4d-EU-Batch1":    false,
+		"OW-VPN-1a2b3c4d-EU-Batch1-In": false,
+		"OW-VPN-EU-West-Batch1":        false,
+		"OW-VPN-EU-Batch1-Out":         false,
+		"Other-EU-Batch1":              false,
+		"OW-VPN-EU-Batch":              false,
+	}
+	for name, want := range tests {
+		if got := legacyRuleName.MatchString(name); got != want {
+			t.Errorf("legacyRuleName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package firewall
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"quidque.no/ow-firewall-sidecar/internal/config"
+)
+
+// NetshBackend manages Windows Firewall rules through netsh advfirewall
+type NetshBackend struct{}
+
+func NewNetshBackend() *NetshBackend {
+	return &NetshBackend{}
+}
+
+func (n *NetshBackend) Name() string {
+	return BackendNetsh
+}
+
+func (n *NetshBackend) AddRule(rule Rule) error {
+	args := []string{"add", "rule",
+		"name=" + rule.Name,
+		"dir=" + rule.Direction,
+		"action=block",
+		"program=" + rule.Program,
+		"remoteip=" + strings.Join(rule.RemoteIPs, ","),
+	}
+	if rule.Protocol != "" && rule.Protocol != config.ProtocolAny {
+		args = append(args, "protocol="+rule.Protocol)
+	}
+
+	if output, err := n.executeFirewallCmd(args...); err != nil {
+		return fmt.Errorf("%w\n%s", err, output)
+	}
+	return nil
+}
+
+func (n *NetshBackend) DeleteRule(name string) error {
+	if output, err := n.executeFirewallCmd("delete", "rule", "name="+name); err != nil {
+		return fmt.Errorf("%w\nOutput: %s", err, output)
+	}
+	return nil
+}
+
+func (n *NetshBackend) RuleNames(prefix string) ([]string, error) {
+	output, err := n.executeFirewallCmd("show", "rule", "name=all")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firewall rules: %w", err)
+	}
+
+	var rules []string
+	lines := strings.Split(output, "\n")
+
+	for _, line := range lines {
+		if strings.Contains(line, "Rule Name:") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				ruleName := strings.TrimSpace(parts[1])
+				if strings.HasPrefix(ruleName, prefix) {
+					rules = append(rules, ruleName)
+				}
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+func (n *NetshBackend) Rules(prefix string) ([]Rule, error) {
+	output, err := n.executeFirewallCmd("show", "rule", "name=all", "verbose")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firewall rules: %w", err)
+	}
+
+	var rules []Rule
+	current := -1
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		if key == "Rule Name" {
+			current = -1
+			if strings.HasPrefix(value, prefix) {
+				rules = append(rules, Rule{
+					Name:      value,
+					RemoteIPs: []string{},
+				})
+				current = len(rules) - 1
+			}
+			continue
+		}
+
+		if current < 0 {
+			continue
+		}
+
+		switch key {
+		case "Direction":
+			rules[current].Direction = strings.ToLower(value)
+		case "Program":
+			rules[current].Program = value
+		case "Protocol":
+			rules[current].Protocol = strings.ToLower(value)
+		case "RemoteIP":
+			rules[current].RemoteIPs = splitRemoteIPs(value)
+		}
+	}
+
+	return rules, nil
+}
+
+func (n *NetshBackend) executeFirewallCmd(args ...string) (string, error) {
+	cmdArgs := append([]string{"advfirewall", "firewall"}, args...)
+	cmd := exec.Command("netsh", cmdArgs...)
+	hideWindow(cmd)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("command execution failed: %w", err)
+	}
+
+	return string(output), nil
+}
+
+func splitRemoteIPs(value string) []string {
+	ips := []string{}
+	for _, ip := range strings.Split(value, ",") {
+		ip = strings.TrimSpace(ip)
+		if ip != "" && !strings.EqualFold(ip, "Any") {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,282 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"quidque.no/ow-vpn-shared/configstore"
+	"quidque.no/ow-vpn-shared/iprange"
+)
+
+// persistedBlocks is the content of the blocks file written while persistent blocks are enabled
+type persistedBlocks struct {
+	Namespace string         `json:"namespace"`
+	Regions   []RegionStatus `json:"regions"`
+}
+
+// RestoreResult describes what happened to each persisted region at start
+type RestoreResult struct {
+	Verified  []string          `json:"verified" yaml:"verified"`
+	Reapplied []string          `json:"reapplied" yaml:"reapplied"`
+	Failed    map[string]string `json:"failed" yaml:"failed"`
+}
+
+// Persistent reports whether blocks are kept across restarts
+func (f *Firewall) Persistent() bool {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	return f.persistent
+}
+
+// SetPersistent turns persistent blocks on or off. While enabled, the blocked regions are
+// saved to the blocks file so RestorePersistent can re-apply them on the next start.
+func (f *Firewall) SetPersistent(enabled bool) error {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+
+	f.persistent = enabled
+	f.updateConfig(func(cfg *configstore.Config) {
+		cfg.PersistentBlocks = enabled
+	})
+
+	if !enabled {
+		f.unrestored = make(map[string]RegionStatus)
+		if err := os.Remove(f.blocksFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing blocks file: %w", err)
+		}
+		return nil
+	}
+
+	return f.saveBlocksLocked()
+}
+
+// Purge removes every rule in this namespace, turns persistent blocks off and deletes the saved blocks
+func (f *Firewall) Purge() error {
+	if err := f.UnblockAll(); err != nil {
+		return err
+	}
+	return f.SetPersistent(false)
+}
+
+// RestorePersistent re-verifies the regions saved in the blocks file and re-applies any
+// whose rules are missing or incomplete. Regions whose IP list directory no longer exists
+// are read from defaultIPListDir instead.
+func (f *Firewall) RestorePersistent(defaultIPListDir string) (RestoreResult, error) {
+	result := RestoreResult{
+		Verified:  []string{},
+		Reapplied: []string{},
+		Failed:    make(map[string]string),
+	}
+
+	if !f.Persistent() {
+		return result, nil
+	}
+
+	saved, err := f.loadBlocks()
+	if err != nil {
+		return result, err
+	}
+	if len(saved.Regions) == 0 {
+		return result, nil
+	}
+
+	rules, err := f.Rules()
+	if err != nil {
+		return result, err
+	}
+
+	for _, region := range saved.Regions {
+		ipListDir := region.IPListDir
+		if info, err := os.Stat(ipListDir); ipListDir == "" || err != nil || !info.IsDir() {
+			ipListDir = defaultIPListDir
+		}
+
+		ips, err := readRegionIPs(region.Region, ipListDir)
+		if err != nil {
+			f.keepUnrestored(region)
+			result.Failed[region.Region] = err.Error()
+			continue
+		}
+
+		if ruleCount, complete := regionRulesComplete(rules, region.Region, ips, f.TargetPaths()); complete {
+			region.RuleCount = ruleCount
+			region.IPCount = len(ips)
+			region.IPListDir = ipListDir
+			f.recordBlocked(region)
+			result.Verified = append(result.Verified, region.Region)
+			continue
+		}
+
+		fmt.Printf("Persistent block for region %s is incomplete, re-applying...\n", region.Region)
+		if err := f.BlockIPs(region.Region, ipListDir); err != nil {
+			f.keepUnrestored(region)
+			result.Failed[region.Region] = err.Error()
+			continue
+		}
+		result.Reapplied = append(result.Reapplied, region.Region)
+	}
+
+	return result, nil
+}
+
+// keepUnrestored keeps a region that could not be restored in the blocks file so the next start retries it
+func (f *Firewall) keepUnrestored(region RegionStatus) {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	f.unrestored[region.Region] = region
+	f.saveBlocksLocked()
+}
+
+// loadSavedBlocks reads the blocks file into the unrestored regions, so a one-shot
+// command that saves the blocks keeps the regions it did not touch. RestorePersistent
+// moves them over to the blocked regions once their rules are verified.
+func (f *Firewall) loadSavedBlocks() {
+	f.stateMutex.Lock()
+	f.unrestored = make(map[string]RegionStatus)
+	persistent := f.persistent
+	f.stateMutex.Unlock()
+
+	if !persistent {
+		return
+	}
+
+	saved, err := f.loadBlocks()
+	if err != nil {
+		fmt.Printf("Warning: Failed to load persistent blocks: %v\n", err)
+		return
+	}
+
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	for _, region := range saved.Regions {
+		if _, blocked := f.regions[region.Region]; !blocked {
+			f.unrestored[region.Region] = region
+		}
+	}
+}
+
+func (f *Firewall) loadBlocks() (persistedBlocks, error) {
+	var blocks persistedBlocks
+
+	data, err := os.ReadFile(f.blocksFile)
+	if os.IsNotExist(err) {
+		return blocks, nil
+	}
+	if err != nil {
+		return blocks, fmt.Errorf("reading blocks file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return blocks, fmt.Errorf("parsing blocks file: %w", err)
+	}
+	if blocks.Namespace != "" && blocks.Namespace != f.namespace {
+		return persistedBlocks{}, fmt.Errorf("blocks file belongs to namespace %s, not %s", blocks.Namespace, f.namespace)
+	}
+	return blocks, nil
+}
+
+// saveBlocksLocked writes the blocked regions to the blocks file. The caller must hold stateMutex.
+func (f *Firewall) saveBlocksLocked() error {
+	if !f.persistent {
+		return nil
+	}
+
+	blocks := persistedBlocks{Namespace: f.namespace, Regions: []RegionStatus{}}
+	for _, region := range f.regions {
+		blocks.Regions = append(blocks.Regions, region)
+	}
+	for name, region := range f.unrestored {
+		if _, blocked := f.regions[name]; !blocked {
+			blocks.Regions = append(blocks.Regions, region)
+		}
+	}
+	sort.Slice(blocks.Regions, func(i, j int) bool {
+		return blocks.Regions[i].Region < blocks.Regions[j].Region
+	})
+
+	data, err := json.MarshalIndent(blocks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding blocks file: %w", err)
+	}
+
+	if err := configstore.WriteFileAtomic(f.blocksFile, data, 0644); err != nil {
+		fmt.Printf("Warning: Failed to save persistent blocks: %v\n", err)
+		return fmt.Errorf("writing blocks file: %w", err)
+	}
+	return nil
+}
+
+// regionRulesComplete reports whether every range is blocked in both directions for
+// every program and returns the number of rules the region has. Rules for a program that
+// is no longer a target make the region incomplete too, so re-applying replaces them.
+func regionRulesComplete(rules []Rule, region string, ips []string, programs []string) (int, bool) {
+	type enforced struct{ inbound, outbound map[string]bool }
+	byProgram := make(map[string]*enforced)
+	for _, program := range programs {
+		byProgram[strings.ToLower(program)] = &enforced{inbound: make(map[string]bool), outbound: make(map[string]bool)}
+	}
+
+	ruleCount := 0
+	stale := false
+	for _, rule := range rules {
+		if rule.Region != region {
+			continue
+		}
+		ruleCount++
+
+		program, ok := byProgram[strings.ToLower(rule.Program)]
+		if !ok {
+			stale = true
+			continue
+		}
+		ranges := program.outbound
+		if strings.EqualFold(rule.Direction, "in") {
+			ranges = program.inbound
+		}
+		for _, ip := range rule.RemoteIPs {
+			ranges[normalizeRange(ip)] = true
+		}
+	}
+
+	if stale || len(programs) == 0 {
+		return ruleCount, false
+	}
+	for _, program := range byProgram {
+		for _, ip := range ips {
+			key := normalizeRange(ip)
+			if !program.inbound[key] || !program.outbound[key] {
+				return ruleCount, false
+			}
+		}
+	}
+	return ruleCount, ruleCount > 0
+}
+
+// normalizeRange converts the notations used by the IP lists and by netsh output
+// (a.b.c.d, a.b.c.d/nn, a.b.c.d/255.255.255.0 and their IPv6 forms) to one canonical form
+func normalizeRange(ip string) string {
+	ip = strings.TrimSpace(ip)
+
+	// netsh shows IPv4 masks in dotted form
+	if address, mask, found := strings.Cut(ip, "/"); found && strings.Contains(mask, ".") {
+		maskIP := net.ParseIP(mask).To4()
+		if maskIP == nil {
+			return ip
+		}
+		ones, bits := net.IPMask(maskIP).Size()
+		if bits == 0 {
+			return ip
+		}
+		ip = fmt.Sprintf("%s/%d", address, ones)
+	}
+
+	r, err := iprange.Parse(ip)
+	if err != nil {
+		return ip
+	}
+	return r.String()
+}
@@ -0,0 +1,168 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// newTestFirewall returns a firewall on the memory backend with a config directory and an
+// IP list directory in a temp dir, and an existing file configured as the game executable
+func newTestFirewall(t *testing.T, dir string) (*Firewall, string) {
+	t.Helper()
+
+	store, err := configstore.Open(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipDir := filepath.Join(dir, "ips")
+	if err := os.MkdirAll(ipDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for region, ranges := range map[string]string{"EU": "1.2.3.0/24\n", "NA": "5.6.7.0/24\n2001:db8::/32\n"} {
+		if err := os.WriteFile(filepath.Join(ipDir, region+".txt"), []byte(ranges), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exe := filepath.Join(dir, "Overwatch.exe")
+	if err := os.WriteFile(exe, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fw := NewWithBackend(NewMemoryBackend(), store)
+	fw.targets = []configstore.Target{{Edition: configstore.EditionBattleNet, Path: exe}}
+	return fw, ipDir
+}
+
+func savedRegions(t *testing.T, fw *Firewall) []string {
+	t.Helper()
+	saved, err := fw.loadBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, region := range saved.Regions {
+		names = append(names, region.Region)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestOneShotBlockKeepsOtherSavedRegions(t *testing.T) {
+	dir := t.TempDir()
+
+	first, ipDir := newTestFirewall(t, dir)
+	if err := first.SetPersistent(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.BlockIPs("EU", ipDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second process, as started by a one-shot -action block, does not restore first
+	second, _ := newTestFirewall(t, dir)
+	if err := second.BlockIPs("NA", ipDir); err != nil {
+		t.Fatal(err)
+	}
+	if got := savedRegions(t, second); len(got) != 2 || got[0] != "EU" || got[1] != "NA" {
+		t.Fatalf("saved regions after blocking NA = %v, want [EU NA]", got)
+	}
+
+	third, _ := newTestFirewall(t, dir)
+	if err := third.UnblockIPs("EU"); err != nil {
+		t.Fatal(err)
+	}
+	if got := savedRegions(t, third); len(got) != 1 || got[0] != "NA" {
+		t.Fatalf("saved regions after unblocking EU = %v, want [NA]", got)
+	}
+}
+
+// restarted returns a firewall on the same backend and config, as after a sidecar restart
+func restarted(t *testing.T, fw *Firewall) *Firewall {
+	t.Helper()
+	next := NewWithBackend(fw.backend, fw.store)
+	next.targets = append([]configstore.Target(nil), fw.targets...)
+	return next
+}
+
+func TestRestorePersistentVerifiesCompleteRules(t *testing.T) {
+	dir := t.TempDir()
+	fw, ipDir := newTestFirewall(t, dir)
+	if err := fw.SetPersistent(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.BlockIPs("EU", ipDir); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := restarted(t, fw).RestorePersistent(ipDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Verified) != 1 || result.Verified[0] != "EU" || len(result.Reapplied) != 0 {
+		t.Errorf("restore result = %+v, want EU verified", result)
+	}
+}
+
+func TestRestorePersistentReappliesRulesOfAnOldTarget(t *testing.T) {
+	dir := t.TempDir()
+	fw, ipDir := newTestFirewall(t, dir)
+	if err := fw.SetPersistent(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.BlockIPs("EU", ipDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The game moved while the sidecar was not running
+	moved := filepath.Join(dir, "moved", "Overwatch.exe")
+	if err := os.MkdirAll(filepath.Dir(moved), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(moved, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	next := restarted(t, fw)
+	next.targets = []configstore.Target{{Edition: configstore.EditionBattleNet, Path: moved}}
+
+	result, err := next.RestorePersistent(ipDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Reapplied) != 1 || result.Reapplied[0] != "EU" || len(result.Verified) != 0 {
+		t.Fatalf("restore result = %+v, want EU re-applied", result)
+	}
+	if programs := rulePrograms(t, next); len(programs) != 1 || programs[moved] != 2 {
+		t.Errorf("rule programs after restore = %v, want only %s", programs, moved)
+	}
+}
+
+func TestRestorePersistentReappliesForAnAddedTarget(t *testing.T) {
+	dir := t.TempDir()
+	fw, ipDir := newTestFirewall(t, dir)
+	if err := fw.SetPersistent(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.BlockIPs("EU", ipDir); err != nil {
+		t.Fatal(err)
+	}
+
+	next := restarted(t, fw)
+	steam := addSteamTarget(t, next, dir)
+
+	result, err := next.RestorePersistent(ipDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Reapplied) != 1 || result.Reapplied[0] != "EU" {
+		t.Fatalf("restore result = %+v, want EU re-applied", result)
+	}
+	if programs := rulePrograms(t, next); programs[steam] != 2 {
+		t.Errorf("rule programs after restore = %v, want rules for %s", programs, steam)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package firewall
+
+import (
+	"os"
+	"os/exec"
+)
+
+func hideWindow(cmd *exec.Cmd) {}
+
+func IsAdminPrivilegesAvailable() bool {
+	return os.Geteuid() == 0
+}
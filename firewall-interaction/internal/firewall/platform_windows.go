@@ -0,0 +1,18 @@
+package firewall
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func hideWindow(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow: true,
+	}
+}
+
+func IsAdminPrivilegesAvailable() bool {
+	cmd := exec.Command("net", "session")
+	hideWindow(cmd)
+	return cmd.Run() == nil
+}
@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RegionInfo describes a region IP list available in the IP list directory
+type RegionInfo struct {
+	Region  string `json:"region" yaml:"region"`
+	IPCount int    `json:"ipCount" yaml:"ipCount"`
+	Blocked bool   `json:"blocked" yaml:"blocked"`
+}
+
+// AvailableRegions lists the region IP lists in ipListDir and whether each is blocked
+func (f *Firewall) AvailableRegions(ipListDir string) ([]RegionInfo, error) {
+	entries, err := readIPListDir(ipListDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading ip list directory: %w", err)
+	}
+
+	f.stateMutex.Lock()
+	blocked := make(map[string]bool, len(f.regions))
+	for region := range f.regions {
+		blocked[strings.ToUpper(region)] = true
+	}
+	f.stateMutex.Unlock()
+
+	regions := []RegionInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".txt" || name == ipVersionFile {
+			continue
+		}
+
+		ips, err := readIPListFile(ipListDir, name)
+		if err != nil {
+			continue
+		}
+		validIPs, _ := validateIPs(ips)
+		if len(validIPs) == 0 {
+			continue
+		}
+
+		region := strings.TrimSuffix(name, ".txt")
+		regions = append(regions, RegionInfo{
+			Region:  region,
+			IPCount: len(validIPs),
+			Blocked: blocked[strings.ToUpper(region)],
+		})
+	}
+
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Region < regions[j].Region
+	})
+	return regions, nil
+}
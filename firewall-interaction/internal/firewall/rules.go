@@ -0,0 +1,183 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-firewall-sidecar/internal/snapshot"
+)
+
+// Rule is a single OW-VPN firewall rule as reported by the backend
+type Rule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Region    string   `json:"region" yaml:"region"`
+	Direction string   `json:"direction" yaml:"direction"`
+	Program   string   `json:"program" yaml:"program"`
+	Protocol  string   `json:"protocol" yaml:"protocol"`
+	RemoteIPs []string `json:"remoteIps" yaml:"remoteIps"`
+}
+
+// RegionRules groups the rules that belong to one region
+type RegionRules struct {
+	Region string `json:"region" yaml:"region"`
+	Rules  []Rule `json:"rules" yaml:"rules"`
+}
+
+// RuleList is the result of the list action
+type RuleList struct {
+	Regions []RegionRules `json:"regions" yaml:"regions"`
+}
+
+// RangeEntry is one enforced range and the rules it is part of
+type RangeEntry struct {
+	Range string   `json:"range" yaml:"range"`
+	Rules []string `json:"rules" yaml:"rules"`
+}
+
+// RegionRanges is the result of the show action
+type RegionRanges struct {
+	Region string       `json:"region" yaml:"region"`
+	Ranges []RangeEntry `json:"ranges" yaml:"ranges"`
+}
+
+// Rules returns every OW-VPN rule of this namespace currently present in the firewall
+func (f *Firewall) Rules() ([]Rule, error) {
+	rules, err := f.backend.Rules(f.rulePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firewall rules: %w", err)
+	}
+
+	for i := range rules {
+		rules[i].Region = f.regionFromRuleName(rules[i].Name)
+	}
+	return rules, nil
+}
+
+// ListRules returns every OW-VPN rule grouped by region
+func (f *Firewall) ListRules() (RuleList, error) {
+	rules, err := f.Rules()
+	if err != nil {
+		return RuleList{}, err
+	}
+
+	byRegion := make(map[string][]Rule)
+	for _, rule := range rules {
+		byRegion[rule.Region] = append(byRegion[rule.Region], rule)
+	}
+
+	list := RuleList{Regions: []RegionRules{}}
+	for region, regionRules := range byRegion {
+		sort.Slice(regionRules, func(i, j int) bool {
+			return regionRules[i].Name < regionRules[j].Name
+		})
+		list.Regions = append(list.Regions, RegionRules{Region: region, Rules: regionRules})
+	}
+	sort.Slice(list.Regions, func(i, j int) bool {
+		return list.Regions[i].Region < list.Regions[j].Region
+	})
+
+	return list, nil
+}
+
+// ShowRegion returns every range enforced for a region and the rules it lives in
+func (f *Firewall) ShowRegion(region string) (RegionRanges, error) {
+	rules, err := f.Rules()
+	if err != nil {
+		return RegionRanges{}, err
+	}
+
+	rulesByRange := make(map[string][]string)
+	var order []string
+
+	for _, rule := range rules {
+		if !strings.EqualFold(rule.Region, region) {
+			continue
+		}
+		for _, ip := range rule.RemoteIPs {
+			if _, seen := rulesByRange[ip]; !seen {
+				order = append(order, ip)
+			}
+			rulesByRange[ip] = append(rulesByRange[ip], rule.Name)
+		}
+	}
+
+	result := RegionRanges{Region: region, Ranges: make([]RangeEntry, 0, len(order))}
+	for _, ip := range order {
+		ruleNames := rulesByRange[ip]
+		sort.Strings(ruleNames)
+		result.Ranges = append(result.Ranges, RangeEntry{Range: ip, Rules: ruleNames})
+	}
+
+	return result, nil
+}
+
+// regionFromRuleName extracts the region from names like OW-VPN-1a2b3c4d-EU-Batch3-In
+func (f *Firewall) regionFromRuleName(name string) string {
+	region := strings.TrimPrefix(name, f.rulePrefix)
+	if idx := strings.Index(region, "-Batch"); idx >= 0 {
+		region = region[:idx]
+	}
+	return region
+}
+
+// Export builds a snapshot of the current block state from the rules in the firewall
+func (f *Firewall) Export(ipListDir string) (snapshot.Snapshot, error) {
+	rules, err := f.Rules()
+	if err != nil {
+		return snapshot.Snapshot{}, err
+	}
+
+	snap := snapshot.New(f.backend.Name())
+	snap.IPListVersion = readIPListVersion(ipListDir)
+
+	targets := make(map[string]bool)
+	protocols := make(map[string]bool)
+	rangesByRegion := make(map[string][]string)
+	seen := make(map[string]bool)
+
+	for _, rule := range rules {
+		protocols[rule.Protocol] = true
+		if rule.Program != "" && !targets[rule.Program] {
+			targets[rule.Program] = true
+			snap.Targets = append(snap.Targets, rule.Program)
+		}
+		for _, ip := range rule.RemoteIPs {
+			key := rule.Region + "|" + ip
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			rangesByRegion[rule.Region] = append(rangesByRegion[rule.Region], ip)
+		}
+	}
+
+	if len(snap.Targets) == 0 {
+		snap.Targets = append(snap.Targets, f.TargetPaths()...)
+	}
+
+	if len(protocols) == 1 {
+		for protocol := range protocols {
+			if protocol == config.ProtocolTCP || protocol == config.ProtocolUDP {
+				snap.Options.Protocol = protocol
+			}
+		}
+	}
+
+	for region, ranges := range rangesByRegion {
+		if name, ok := strings.CutPrefix(region, customListPrefix); ok {
+			snap.CustomLists = append(snap.CustomLists, snapshot.CustomList{Name: name, Ranges: ranges})
+			continue
+		}
+		snap.Regions = append(snap.Regions, snapshot.Region{Name: region, Ranges: ranges})
+	}
+	sort.Slice(snap.Regions, func(i, j int) bool {
+		return snap.Regions[i].Name < snap.Regions[j].Name
+	})
+	sort.Slice(snap.CustomLists, func(i, j int) bool {
+		return snap.CustomLists[i].Name < snap.CustomLists[j].Name
+	})
+
+	return snap, nil
+}
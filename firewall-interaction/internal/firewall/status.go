@@ -0,0 +1,216 @@
+package firewall
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// ipVersionFile is the version marker written by the IP puller
+const ipVersionFile = "IP_version.txt"
+
+// maxHistory is the number of completed operations kept for the history action
+const maxHistory = 100
+
+// RegionStatus describes the rules currently enforced for one region
+type RegionStatus struct {
+	Region    string    `json:"region" yaml:"region"`
+	RuleCount int       `json:"ruleCount" yaml:"ruleCount"`
+	IPCount   int       `json:"ipCount" yaml:"ipCount"`
+	IPListDir string    `json:"ipListDir" yaml:"ipListDir"`
+	BlockedAt time.Time `json:"blockedAt" yaml:"blockedAt"`
+}
+
+// Operation describes a firewall operation that has run or is running
+type Operation struct {
+	Action    string    `json:"action" yaml:"action"`
+	Region    string    `json:"region,omitempty" yaml:"region,omitempty"`
+	StartedAt time.Time `json:"startedAt" yaml:"startedAt"`
+	Error     string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Status is a machine-readable snapshot of the sidecar state
+type Status struct {
+	Ready          bool                 `json:"ready" yaml:"ready"`
+	Persistent     bool                 `json:"persistent" yaml:"persistent"`
+	Namespace      string               `json:"namespace" yaml:"namespace"`
+	Backend        string               `json:"backend" yaml:"backend"`
+	Executables    []string             `json:"executables" yaml:"executables"`
+	Targets        []configstore.Target `json:"targets" yaml:"targets"`
+	PathConfigured bool                 `json:"pathConfigured" yaml:"pathConfigured"`
+	IPListDir      string               `json:"ipListDir" yaml:"ipListDir"`
+	IPListVersion  string               `json:"ipListVersion" yaml:"ipListVersion"`
+	Regions        []RegionStatus       `json:"regions" yaml:"regions"`
+	RulesError     string               `json:"rulesError,omitempty" yaml:"rulesError,omitempty"`
+	LastOperation  *Operation           `json:"lastOperation,omitempty" yaml:"lastOperation,omitempty"`
+	Pending        []Operation          `json:"pending" yaml:"pending"`
+}
+
+// Status returns the current state of the firewall, reading the IP list version from
+// ipListDir. The blocked regions and their rule and IP counts come from the rules in the
+// firewall, so another process, such as a one-shot -action status next to the daemon,
+// sees them too; this process only adds when and from which directory it blocked them.
+// Pending and the last operation cover the operations of this process only.
+func (f *Firewall) Status(ipListDir string) Status {
+	status := Status{
+		Backend:        f.backend.Name(),
+		Namespace:      f.Namespace(),
+		Executables:    []string{},
+		PathConfigured: f.HasOverwatchPath(),
+		Targets:        f.Targets(),
+		IPListDir:      ipListDir,
+		IPListVersion:  readIPListVersion(ipListDir),
+		Regions:        []RegionStatus{},
+		Pending:        []Operation{},
+	}
+	status.Ready = status.PathConfigured
+
+	for _, target := range status.Targets {
+		status.Executables = append(status.Executables, target.Path)
+	}
+
+	rules, rulesErr := f.Rules()
+
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+
+	status.Persistent = f.persistent
+
+	if rulesErr != nil {
+		// Without the rules the regions this process blocked are the best guess
+		status.RulesError = rulesErr.Error()
+		for _, region := range f.regions {
+			status.Regions = append(status.Regions, region)
+		}
+	} else {
+		status.Regions = f.regionsFromRules(rules)
+	}
+	sort.Slice(status.Regions, func(i, j int) bool {
+		return status.Regions[i].Region < status.Regions[j].Region
+	})
+
+	for _, op := range f.pending {
+		status.Pending = append(status.Pending, *op)
+	}
+	sort.Slice(status.Pending, func(i, j int) bool {
+		return status.Pending[i].StartedAt.Before(status.Pending[j].StartedAt)
+	})
+
+	if f.lastOperation != nil {
+		last := *f.lastOperation
+		status.LastOperation = &last
+	}
+
+	return status
+}
+
+// regionsFromRules counts the rules and distinct ranges of every region that has rules.
+// Must be called with stateMutex held.
+func (f *Firewall) regionsFromRules(rules []Rule) []RegionStatus {
+	var order []string
+	byRegion := make(map[string]*RegionStatus)
+	ranges := make(map[string]map[string]bool)
+	for _, rule := range rules {
+		region, seen := byRegion[rule.Region]
+		if !seen {
+			region = &RegionStatus{Region: rule.Region}
+			if recorded, ok := f.regions[rule.Region]; ok {
+				region.IPListDir = recorded.IPListDir
+				region.BlockedAt = recorded.BlockedAt
+			}
+			byRegion[rule.Region] = region
+			ranges[rule.Region] = make(map[string]bool)
+			order = append(order, rule.Region)
+		}
+		region.RuleCount++
+		for _, ip := range rule.RemoteIPs {
+			ranges[rule.Region][ip] = true
+		}
+	}
+
+	regions := make([]RegionStatus, 0, len(order))
+	for _, name := range order {
+		region := byRegion[name]
+		region.IPCount = len(ranges[name])
+		regions = append(regions, *region)
+	}
+	return regions
+}
+
+// beginOperation registers an operation as pending and returns a function that completes it
+func (f *Firewall) beginOperation(action, region string) func(error) {
+	op := &Operation{
+		Action:    action,
+		Region:    region,
+		StartedAt: time.Now(),
+	}
+
+	f.stateMutex.Lock()
+	f.pending[op] = op
+	f.stateMutex.Unlock()
+
+	return func(err error) {
+		if err != nil {
+			op.Error = err.Error()
+		}
+
+		f.stateMutex.Lock()
+		delete(f.pending, op)
+		f.lastOperation = op
+		f.history = append(f.history, *op)
+		if len(f.history) > maxHistory {
+			f.history = f.history[len(f.history)-maxHistory:]
+		}
+		f.stateMutex.Unlock()
+	}
+}
+
+// History returns the most recent completed operations, oldest first
+func (f *Firewall) History() []Operation {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	return append([]Operation{}, f.history...)
+}
+
+func (f *Firewall) recordBlocked(region RegionStatus) {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+	f.regions[region.Region] = region
+	delete(f.unrestored, region.Region)
+	f.saveBlocksLocked()
+}
+
+func (f *Firewall) recordUnblocked(region string) {
+	f.stateMutex.Lock()
+	defer f.stateMutex.Unlock()
+
+	if region == "" {
+		f.regions = make(map[string]RegionStatus)
+		f.unrestored = make(map[string]RegionStatus)
+	} else {
+		delete(f.regions, region)
+		delete(f.unrestored, region)
+	}
+	f.saveBlocksLocked()
+}
+
+func readIPListVersion(ipListDir string) string {
+	if ipListDir == "" {
+		return ""
+	}
+
+	file, err := openIPList(ipListDir, ipVersionFile)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
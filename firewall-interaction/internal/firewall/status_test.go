@@ -0,0 +1,40 @@
+package firewall
+
+import "testing"
+
+func TestStatusReadsRegionsFromTheRules(t *testing.T) {
+	dir := t.TempDir()
+	daemon, ipDir := newTestFirewall(t, dir)
+	addSteamTarget(t, daemon, dir)
+
+	for _, region := range []string{"EU", "NA"} {
+		if err := daemon.BlockIPs(region, ipDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A one-shot -action status runs in a second process on the same firewall
+	oneShot := NewWithBackend(daemon.backend, daemon.store)
+	regions := oneShot.Status(ipDir).Regions
+	if len(regions) != 2 {
+		t.Fatalf("status regions = %+v, want EU and NA", regions)
+	}
+
+	// Inbound and outbound rules for both targets, one batch each
+	want := map[string]RegionStatus{
+		"EU": {Region: "EU", RuleCount: 4, IPCount: 1},
+		"NA": {Region: "NA", RuleCount: 4, IPCount: 2},
+	}
+	for _, region := range regions {
+		if region != want[region.Region] {
+			t.Errorf("status region = %+v, want %+v", region, want[region.Region])
+		}
+	}
+
+	// The process that blocked the regions adds when and from where
+	for _, region := range daemon.Status(ipDir).Regions {
+		if region.IPListDir != ipDir || region.BlockedAt.IsZero() {
+			t.Errorf("daemon status region = %+v, want its IP list directory and block time", region)
+		}
+	}
+}
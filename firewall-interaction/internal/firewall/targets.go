@@ -0,0 +1,255 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"quidque.no/ow-firewall-sidecar/internal/gamefile"
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// loadTargets takes the stored targets that still pass verification. Targets are verified
+// again when the file changed, for example after a game update, when the allowlist no
+// longer contains their hash, or when they were stored before verification existed.
+func (f *Firewall) loadTargets(cfg configstore.Config) bool {
+	var loaded []configstore.Target
+
+	for _, target := range cfg.Targets {
+		if !fileExists(target.Path) {
+			fmt.Printf("Warning: Stored %s Overwatch path no longer exists: %s\n", target.Edition, target.Path)
+			continue
+		}
+
+		info := target.Info
+		if info == nil || info.Path != target.Path || !info.Unchanged() || !gamefile.Allowed(info.SHA256, cfg.ExecutableAllowlist) {
+			var err error
+			if info, err = f.verifyExecutable(target.Path, cfg.ExecutableAllowlist); err != nil {
+				fmt.Printf("Warning: Stored %s Overwatch path failed verification: %v\n", target.Edition, err)
+				continue
+			}
+			target.Info = info
+			f.updateConfig(func(cfg *configstore.Config) {
+				cfg.SetTarget(target)
+			})
+		}
+
+		loaded = append(loaded, target)
+		fmt.Printf("Loaded %s Overwatch path: %s\n", target.Edition, target.Path)
+	}
+
+	f.targetsMutex.Lock()
+	f.targets = loaded
+	f.targetsMutex.Unlock()
+	return len(loaded) > 0
+}
+
+// SetOverwatchPath verifies and stores the executable of one edition, replacing the
+// previous target of that edition. An install directory or another file in it, such as
+// the launcher, selects the game executable inside it.
+func (f *Firewall) SetOverwatchPath(path string) (configstore.Target, error) {
+	if path == "" {
+		return configstore.Target{}, fmt.Errorf("path cannot be empty")
+	}
+
+	path = gamefile.SelectExecutable(path, gamefile.Overwatch)
+	if !fileExists(path) {
+		return configstore.Target{}, fmt.Errorf("path does not exist: %s", path)
+	}
+
+	cfg, err := f.store.Load()
+	if err != nil {
+		return configstore.Target{}, fmt.Errorf("loading executable allowlist: %w", err)
+	}
+	info, err := f.verifyExecutable(path, cfg.ExecutableAllowlist)
+	if err != nil {
+		return configstore.Target{}, err
+	}
+
+	target := configstore.Target{Edition: configstore.EditionOf(path), Path: path, Info: info}
+
+	f.targetsMutex.Lock()
+	defer f.targetsMutex.Unlock()
+
+	fmt.Printf("Setting %s Overwatch path to: %s (%s)\n", target.Edition, path, describeExecutable(info))
+	replaced := false
+	for i := range f.targets {
+		if f.targets[i].Edition == target.Edition {
+			f.targets[i] = target
+			replaced = true
+		}
+	}
+	if !replaced {
+		f.targets = append(f.targets, target)
+	}
+
+	f.updateConfig(func(cfg *configstore.Config) {
+		cfg.SetTarget(target)
+	})
+	return target, nil
+}
+
+// ClearResult describes the targets ClearTargets forgot and the blocked regions whose
+// rules applied to them
+type ClearResult struct {
+	Cleared []string `json:"cleared" yaml:"cleared"`
+	// Updated are regions whose rules still block the remaining targets
+	Updated []string `json:"updated" yaml:"updated"`
+	// Unblocked are regions left without rules because they only applied to cleared targets
+	Unblocked []string `json:"unblocked" yaml:"unblocked"`
+}
+
+// ClearTargets forgets the target of an edition, or every target when edition is empty,
+// and takes the cleared executables out of the rules of the blocked regions, so they no
+// longer block a game the sidecar does not manage anymore
+func (f *Firewall) ClearTargets(edition string) (ClearResult, error) {
+	if edition != "" && edition != configstore.EditionBattleNet && edition != configstore.EditionSteam {
+		return ClearResult{}, fmt.Errorf("unknown edition '%s', expected %s or %s", edition, configstore.EditionBattleNet, configstore.EditionSteam)
+	}
+
+	result := ClearResult{Cleared: []string{}, Updated: []string{}, Unblocked: []string{}}
+
+	f.targetsMutex.Lock()
+	kept := []configstore.Target{}
+	for _, target := range f.targets {
+		if edition != "" && target.Edition != edition {
+			kept = append(kept, target)
+		} else {
+			result.Cleared = append(result.Cleared, target.Path)
+		}
+	}
+	f.targets = kept
+	f.targetsMutex.Unlock()
+
+	f.updateConfig(func(cfg *configstore.Config) {
+		cfg.RemoveTargets(edition)
+	})
+
+	if len(result.Cleared) == 0 {
+		return result, nil
+	}
+	if err := f.removePrograms(result.Cleared, &result); err != nil {
+		return result, fmt.Errorf("removing the cleared executables from the rules: %w", err)
+	}
+	return result, nil
+}
+
+// removePrograms re-creates every rule that applies to one of programs without them and
+// updates the recorded state of the regions those rules belong to
+func (f *Firewall) removePrograms(programs []string, result *ClearResult) error {
+	rules, err := f.backend.Rules(f.rulePrefix)
+	if err != nil {
+		return err
+	}
+
+	cleared := func(rule Rule) bool {
+		for _, program := range programs {
+			if strings.EqualFold(rule.Program, program) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// netsh deletes every rule of a name at once, so rules are re-created by name
+	var names []string
+	byName := make(map[string][]Rule)
+	affected := make(map[string]bool)
+	for _, rule := range rules {
+		if _, seen := byName[rule.Name]; !seen {
+			names = append(names, rule.Name)
+		}
+		byName[rule.Name] = append(byName[rule.Name], rule)
+		if cleared(rule) {
+			affected[rule.Name] = true
+		}
+	}
+
+	var failed []string
+	remaining := make(map[string]int)
+	regions := make(map[string]bool)
+	for _, name := range names {
+		region := f.regionFromRuleName(name)
+		if !affected[name] {
+			remaining[region] += len(byName[name])
+			continue
+		}
+		regions[region] = true
+
+		if err := f.backend.DeleteRule(name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		for _, rule := range byName[name] {
+			if cleared(rule) {
+				continue
+			}
+			if err := f.backend.AddRule(rule); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			remaining[region]++
+		}
+	}
+
+	for region := range regions {
+		if remaining[region] == 0 {
+			f.recordUnblocked(region)
+			result.Unblocked = append(result.Unblocked, region)
+			continue
+		}
+
+		f.stateMutex.Lock()
+		status, blocked := f.regions[region]
+		f.stateMutex.Unlock()
+		if !blocked {
+			status = RegionStatus{Region: region, BlockedAt: time.Now()}
+		}
+		status.RuleCount = remaining[region]
+		f.recordBlocked(status)
+		result.Updated = append(result.Updated, region)
+	}
+	sort.Strings(result.Updated)
+	sort.Strings(result.Unblocked)
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to re-create %d rules: %s", len(failed), failed[0])
+	}
+	return nil
+}
+
+// Targets returns the verified executables rules are created for
+func (f *Firewall) Targets() []configstore.Target {
+	f.targetsMutex.RLock()
+	defer f.targetsMutex.RUnlock()
+	return append([]configstore.Target{}, f.targets...)
+}
+
+// TargetPaths returns the paths of the targets that still exist
+func (f *Firewall) TargetPaths() []string {
+	paths := []string{}
+	for _, target := range f.Targets() {
+		if fileExists(target.Path) {
+			paths = append(paths, target.Path)
+		}
+	}
+	return paths
+}
+
+func (f *Firewall) HasOverwatchPath() bool {
+	return len(f.TargetPaths()) > 0
+}
+
+func describeExecutable(info *configstore.ExecutableInfo) string {
+	return fmt.Sprintf("%s, sha256 %s", ProductText(info), info.SHA256[:12])
+}
+
+// ProductText returns the product name and version of a verified executable
+func ProductText(info *configstore.ExecutableInfo) string {
+	product := strings.TrimSpace(info.ProductName + " " + info.FileVersion)
+	if product == "" {
+		return "unknown product"
+	}
+	return product
+}
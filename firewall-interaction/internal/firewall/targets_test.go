@@ -0,0 +1,124 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// addSteamTarget configures a second, Steam, executable next to the Battle.net one
+func addSteamTarget(t *testing.T, fw *Firewall, dir string) string {
+	t.Helper()
+	steam := filepath.Join(dir, "steamapps", "common", "Overwatch", "Overwatch.exe")
+	if err := os.MkdirAll(filepath.Dir(steam), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(steam, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fw.targets = append(fw.targets, configstore.Target{Edition: configstore.EditionSteam, Path: steam})
+	return steam
+}
+
+func rulePrograms(t *testing.T, fw *Firewall) map[string]int {
+	t.Helper()
+	rules, err := fw.Rules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	programs := make(map[string]int)
+	for _, rule := range rules {
+		programs[rule.Program]++
+	}
+	return programs
+}
+
+func TestClearTargetKeepsBlocksForTheOtherEdition(t *testing.T) {
+	dir := t.TempDir()
+	fw, ipDir := newTestFirewall(t, dir)
+	battlenet := fw.targets[0].Path
+	steam := addSteamTarget(t, fw, dir)
+
+	for _, region := range []string{"EU", "NA"} {
+		if err := fw.BlockIPs(region, ipDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := fw.ClearTargets(configstore.EditionSteam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ClearResult{Cleared: []string{steam}, Updated: []string{"EU", "NA"}, Unblocked: []string{}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("ClearTargets = %+v, want %+v", result, want)
+	}
+
+	// EU has one batch and NA one, each with an inbound and an outbound rule
+	if programs := rulePrograms(t, fw); programs[steam] != 0 || programs[battlenet] != 4 {
+		t.Errorf("rules per program = %v, want only the 4 Battle.net rules", programs)
+	}
+	for _, region := range fw.Status(ipDir).Regions {
+		if region.RuleCount != 2 {
+			t.Errorf("%s rule count = %d, want 2", region.Region, region.RuleCount)
+		}
+	}
+}
+
+func TestClearAllTargetsUnblocks(t *testing.T) {
+	dir := t.TempDir()
+	fw, ipDir := newTestFirewall(t, dir)
+	if err := fw.SetPersistent(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.BlockIPs("EU", ipDir); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := fw.ClearTargets("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Unblocked, []string{"EU"}) {
+		t.Errorf("unblocked = %v, want EU", result.Unblocked)
+	}
+
+	if programs := rulePrograms(t, fw); len(programs) != 0 {
+		t.Errorf("rules left for %v", programs)
+	}
+	if regions := fw.Status(ipDir).Regions; len(regions) != 0 {
+		t.Errorf("status regions = %v, want none", regions)
+	}
+	if saved := savedRegions(t, fw); len(saved) != 0 {
+		t.Errorf("saved regions = %v, want none", saved)
+	}
+}
+
+func TestUnblockWithTwoTargets(t *testing.T) {
+	dir := t.TempDir()
+	fw, ipDir := newTestFirewall(t, dir)
+	addSteamTarget(t, fw, dir)
+
+	for _, region := range []string{"EU", "NA"} {
+		if err := fw.BlockIPs(region, ipDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every batch name is shared by the rules of both targets
+	if err := fw.UnblockIPs("EU"); err != nil {
+		t.Fatalf("UnblockIPs(EU) = %v", err)
+	}
+	if err := fw.BlockIPs("EU", ipDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.UnblockAll(); err != nil {
+		t.Fatalf("UnblockAll = %v", err)
+	}
+	if programs := rulePrograms(t, fw); len(programs) != 0 {
+		t.Errorf("rules left for %v", programs)
+	}
+}
@@ -0,0 +1,43 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateIPsCanonical(t *testing.T) {
+	valid, err := validateIPs([]string{
+		"1.2.3.4 - 1.2.3.9",
+		"1.2.3.4/24",
+		"::ffff:1.2.3.4",
+		" 5.6.7.8 ",
+		"2001:db8::1",
+		"1.2.3.0/33",
+		"1.2.3.9-1.2.3.4",
+	})
+
+	want := []string{"1.2.3.4-1.2.3.9", "1.2.3.0/24", "1.2.3.4/32", "5.6.7.8/32", "2001:db8::1/128"}
+	if strings.Join(valid, ",") != strings.Join(want, ",") {
+		t.Errorf("validateIPs = %v, want %v", valid, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), "1.2.3.0/33") || !strings.Contains(err.Error(), "1.2.3.9-1.2.3.4") {
+		t.Errorf("validateIPs error = %v, want both invalid ranges listed", err)
+	}
+}
+
+func TestNormalizeRange(t *testing.T) {
+	tests := map[string]string{
+		"1.2.3.4":               "1.2.3.4/32",
+		"1.2.3.0/255.255.255.0": "1.2.3.0/24",
+		"1.2.3.4/24":            "1.2.3.0/24",
+		"1.2.3.4 - 1.2.3.9":     "1.2.3.4-1.2.3.9",
+		"2001:db8::/32":         "2001:db8::/32",
+		"1.2.3.0/255.0.255.0":   "1.2.3.0/255.0.255.0",
+		"not an address":        "not an address",
+	}
+	for input, want := range tests {
+		if got := normalizeRange(input); got != want {
+			t.Errorf("normalizeRange(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
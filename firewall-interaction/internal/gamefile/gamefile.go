@@ -0,0 +1,166 @@
+// Package gamefile verifies that a path given as the game executable really is the game,
+// using the file name, the PE version resource and an optional hash allowlist.
+package gamefile
+
+import (
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"quidque.no/ow-firewall-sidecar/internal/config"
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// Profile describes how to recognise a game's executable
+type Profile struct {
+	Name            string
+	ExecutableNames []string
+	ProductNames    []string
+
+	// InstallLayouts are the executable paths below an install directory, in the order
+	// they are tried: Battle.net keeps the game in _retail_, Steam in the install root
+	InstallLayouts []string
+}
+
+// Overwatch is the profile of the Overwatch game client
+var Overwatch = Profile{
+	Name:            "Overwatch",
+	ExecutableNames: []string{config.OverwatchProcessName},
+	ProductNames:    []string{"Overwatch"},
+	InstallLayouts:  []string{"_retail_/" + config.OverwatchProcessName, config.OverwatchProcessName},
+}
+
+// VerificationError explains why a file was refused as the game executable
+type VerificationError struct {
+	Path   string
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("%s is not a valid game executable: %s", e.Path, e.Reason)
+}
+
+// Verify checks that path is the profile's executable and returns its metadata. Hard
+// mismatches are refused with a VerificationError; missing version information only adds
+// a warning to the result. A non-empty allowlist must contain the file's SHA-256 hash.
+func Verify(path string, profile Profile, allowlist []string) (*configstore.ExecutableInfo, error) {
+	refuse := func(format string, args ...interface{}) error {
+		return &VerificationError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, refuse("file not found")
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, refuse("not a regular file")
+	}
+
+	if !matchesAny(filepath.Base(path), profile.ExecutableNames, strings.EqualFold) {
+		return nil, refuse("file name %s does not match %s (%s)", filepath.Base(path), profile.Name, strings.Join(profile.ExecutableNames, ", "))
+	}
+
+	info := &configstore.ExecutableInfo{
+		Path:       path,
+		Size:       stat.Size(),
+		ModTime:    stat.ModTime(),
+		VerifiedAt: time.Now(),
+	}
+
+	peFile, err := pe.Open(path)
+	if err != nil {
+		return nil, refuse("not a Windows executable: %v", err)
+	}
+	version, err := ReadVersionInfo(peFile)
+	peFile.Close()
+
+	switch {
+	case errors.Is(err, errNoVersionInfo):
+		info.Warnings = append(info.Warnings, "the executable has no version information, product name not checked")
+	case err != nil:
+		info.Warnings = append(info.Warnings, fmt.Sprintf("unreadable version information (%v), product name not checked", err))
+	case version.ProductName == "":
+		info.Warnings = append(info.Warnings, "the version information has no product name")
+	case !matchesAny(version.ProductName, profile.ProductNames, containsFold):
+		return nil, refuse("product name is '%s', expected %s", version.ProductName, profile.Name)
+	}
+
+	info.ProductName = version.ProductName
+	info.CompanyName = version.CompanyName
+	info.FileVersion = version.FileVersion
+	info.ProductVersion = version.ProductVersion
+
+	if info.SHA256, err = hashFile(path); err != nil {
+		return nil, refuse("reading file: %v", err)
+	}
+	if !Allowed(info.SHA256, allowlist) {
+		return nil, refuse("SHA-256 %s is not in the executable allowlist", info.SHA256)
+	}
+
+	return info, nil
+}
+
+// SelectExecutable returns the game executable for a path that may also be an install
+// directory or another file in it, such as the Battle.net launcher. Paths that do not
+// lead to a game executable are returned unchanged so verification reports why.
+func SelectExecutable(path string, profile Profile) string {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+
+	dir := path
+	if !stat.IsDir() {
+		if matchesAny(filepath.Base(path), profile.ExecutableNames, strings.EqualFold) {
+			return path
+		}
+		dir = filepath.Dir(path)
+	}
+
+	for _, layout := range profile.InstallLayouts {
+		candidate := filepath.Join(dir, filepath.FromSlash(layout))
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return path
+}
+
+// Allowed reports whether hash is in the allowlist. An empty allowlist allows every hash.
+func Allowed(hash string, allowlist []string) bool {
+	return len(allowlist) == 0 || matchesAny(hash, allowlist, strings.EqualFold)
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func matchesAny(value string, candidates []string, match func(string, string) bool) bool {
+	for _, candidate := range candidates {
+		if match(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(value, substr string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(substr))
+}
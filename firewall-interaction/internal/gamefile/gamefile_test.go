@@ -0,0 +1,70 @@
+package gamefile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureExe is a minimal PE file with the version resource of Overwatch, a copy of the
+// one in the fixture trees of the shared discovery package
+var fixtureExe = filepath.Join("testdata", "Overwatch.exe")
+
+func TestVerifyReadsVersionInfo(t *testing.T) {
+	info, err := Verify(fixtureExe, Overwatch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProductName != "Overwatch" || info.CompanyName != "Blizzard Entertainment, Inc." || info.FileVersion != "2.14.1.0" {
+		t.Errorf("info = %+v", info)
+	}
+	if len(info.Warnings) != 0 || len(info.SHA256) != 64 {
+		t.Errorf("warnings %v, hash %q", info.Warnings, info.SHA256)
+	}
+
+	if _, err := Verify(fixtureExe, Overwatch, []string{strings.ToUpper(info.SHA256)}); err != nil {
+		t.Errorf("Verify with the hash in the allowlist: %v", err)
+	}
+	if _, err := Verify(fixtureExe, Overwatch, []string{"0000"}); err == nil {
+		t.Error("Verify with another hash in the allowlist succeeded")
+	}
+}
+
+func TestVerifyRefuses(t *testing.T) {
+	dir := t.TempDir()
+	notPE := filepath.Join(dir, "Overwatch.exe")
+	if err := os.WriteFile(notPE, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fixtureExe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := filepath.Join(dir, "Other.exe")
+	if err := os.WriteFile(renamed, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherGame := Profile{Name: "Other", ExecutableNames: []string{"Overwatch.exe"}, ProductNames: []string{"Other"}}
+
+	tests := []struct {
+		name    string
+		path    string
+		profile Profile
+		reason  string
+	}{
+		{"missing", filepath.Join(dir, "missing.exe"), Overwatch, "file not found"},
+		{"directory", dir, Overwatch, "not a regular file"},
+		{"file name", renamed, Overwatch, "file name Other.exe does not match"},
+		{"not a PE", notPE, Overwatch, "not a Windows executable"},
+		{"product name", fixtureExe, otherGame, "product name is 'Overwatch'"},
+	}
+
+	for _, tt := range tests {
+		_, err := Verify(tt.path, tt.profile, nil)
+		refused, ok := err.(*VerificationError)
+		if !ok || !strings.HasPrefix(refused.Reason, tt.reason) {
+			t.Errorf("%s: Verify() error = %v, want %s", tt.name, err, tt.reason)
+		}
+	}
+}
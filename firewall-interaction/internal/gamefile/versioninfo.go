@@ -0,0 +1,210 @@
+package gamefile
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	resourceTypeVersion = 16
+	fixedFileInfoMagic  = 0xFEEF04BD
+)
+
+var errNoVersionInfo = errors.New("no version information")
+
+// VersionInfo holds the fields of a PE version resource used to identify a game
+type VersionInfo struct {
+	ProductName    string
+	CompanyName    string
+	FileVersion    string
+	ProductVersion string
+}
+
+// versionBlock is one node of a VS_VERSIONINFO tree
+type versionBlock struct {
+	key      string
+	value    []byte
+	text     bool
+	children []versionBlock
+}
+
+// ReadVersionInfo reads the version resource of a PE file
+func ReadVersionInfo(f *pe.File) (VersionInfo, error) {
+	data, err := versionResource(f)
+	if err != nil {
+		return VersionInfo{}, err
+	}
+
+	root, _, err := parseVersionBlock(data)
+	if err != nil {
+		return VersionInfo{}, fmt.Errorf("parsing version resource: %w", err)
+	}
+
+	info := VersionInfo{}
+	if len(root.value) >= 52 && binary.LittleEndian.Uint32(root.value) == fixedFileInfoMagic {
+		info.FileVersion = fixedVersion(root.value[8:16])
+		info.ProductVersion = fixedVersion(root.value[16:24])
+	}
+
+	for _, child := range root.children {
+		if child.key != "StringFileInfo" {
+			continue
+		}
+		for _, table := range child.children {
+			for _, entry := range table.children {
+				value := decodeUTF16(entry.value)
+				switch entry.key {
+				case "ProductName":
+					info.ProductName = value
+				case "CompanyName":
+					info.CompanyName = value
+				case "FileVersion":
+					if value != "" {
+						info.FileVersion = value
+					}
+				case "ProductVersion":
+					if value != "" {
+						info.ProductVersion = value
+					}
+				}
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// versionResource walks the resource directory (type, name, language) to the first RT_VERSION entry
+func versionResource(f *pe.File) ([]byte, error) {
+	section := f.Section(".rsrc")
+	if section == nil {
+		return nil, errNoVersionInfo
+	}
+
+	rsrc, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("reading resources: %w", err)
+	}
+
+	offset, isDir, err := resourceEntry(rsrc, 0, resourceTypeVersion)
+	if err != nil || !isDir {
+		return nil, errNoVersionInfo
+	}
+	for level := 0; level < 2 && isDir; level++ {
+		if offset, isDir, err = resourceEntry(rsrc, offset, -1); err != nil {
+			return nil, errNoVersionInfo
+		}
+	}
+	if isDir || int(offset)+16 > len(rsrc) {
+		return nil, errNoVersionInfo
+	}
+
+	rva := binary.LittleEndian.Uint32(rsrc[offset:])
+	size := binary.LittleEndian.Uint32(rsrc[offset+4:])
+	start := int64(rva) - int64(section.VirtualAddress)
+	if start < 0 || start+int64(size) > int64(len(rsrc)) {
+		return nil, fmt.Errorf("version resource outside resource section")
+	}
+	return rsrc[start : start+int64(size)], nil
+}
+
+// resourceEntry finds the entry with the given id in the resource directory at offset,
+// or the first entry when id is negative. It returns the entry's target offset and
+// whether that target is another directory.
+func resourceEntry(rsrc []byte, offset uint32, id int) (uint32, bool, error) {
+	if int(offset)+16 > len(rsrc) {
+		return 0, false, errNoVersionInfo
+	}
+
+	named := int(binary.LittleEndian.Uint16(rsrc[offset+12:]))
+	ids := int(binary.LittleEndian.Uint16(rsrc[offset+14:]))
+
+	for i := 0; i < named+ids; i++ {
+		entry := int(offset) + 16 + i*8
+		if entry+8 > len(rsrc) {
+			break
+		}
+
+		name := binary.LittleEndian.Uint32(rsrc[entry:])
+		target := binary.LittleEndian.Uint32(rsrc[entry+4:])
+		if id >= 0 && (name&0x80000000 != 0 || int(name) != id) {
+			continue
+		}
+		return target & 0x7FFFFFFF, target&0x80000000 != 0, nil
+	}
+
+	return 0, false, errNoVersionInfo
+}
+
+// parseVersionBlock parses a VS_VERSIONINFO style block and returns it with its length
+func parseVersionBlock(data []byte) (versionBlock, int, error) {
+	if len(data) < 6 {
+		return versionBlock{}, 0, fmt.Errorf("truncated block")
+	}
+
+	length := int(binary.LittleEndian.Uint16(data))
+	valueLength := int(binary.LittleEndian.Uint16(data[2:]))
+	block := versionBlock{text: binary.LittleEndian.Uint16(data[4:]) == 1}
+	if length < 6 || length > len(data) {
+		return versionBlock{}, 0, fmt.Errorf("invalid block length %d", length)
+	}
+	data = data[:length]
+
+	pos := 6
+	var key []uint16
+	for pos+1 < length {
+		c := binary.LittleEndian.Uint16(data[pos:])
+		pos += 2
+		if c == 0 {
+			break
+		}
+		key = append(key, c)
+	}
+	block.key = string(utf16.Decode(key))
+	pos = align4(pos)
+
+	valueBytes := valueLength
+	if block.text {
+		valueBytes *= 2
+	}
+	if pos+valueBytes > length {
+		valueBytes = max(length-pos, 0)
+	}
+	if pos < length {
+		block.value = data[pos : pos+valueBytes]
+	}
+	pos = align4(pos + valueBytes)
+
+	for pos < length {
+		child, n, err := parseVersionBlock(data[pos:])
+		if err != nil {
+			return versionBlock{}, 0, err
+		}
+		block.children = append(block.children, child)
+		pos = align4(pos + n)
+	}
+
+	return block, length, nil
+}
+
+func fixedVersion(data []byte) string {
+	ms := binary.LittleEndian.Uint32(data)
+	ls := binary.LittleEndian.Uint32(data[4:])
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xFFFF, ls>>16, ls&0xFFFF)
+}
+
+func decodeUTF16(data []byte) string {
+	chars := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		chars = append(chars, binary.LittleEndian.Uint16(data[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(chars)), "\x00")
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
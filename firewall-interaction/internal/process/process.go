@@ -1,10 +1,23 @@
+// Package process detects the Overwatch game process through the shared procwatch package
 package process
 
-// This file is simplified since the GUI now handles Overwatch process detection
-// The package is kept for API compatibility
+import (
+	"quidque.no/ow-vpn-shared/procwatch"
+)
 
-// IsOverwatchRunning always returns false since the GUI handles process detection
+// OverwatchProcessName is the executable name of both the Battle.net and Steam editions
+const OverwatchProcessName = "Overwatch.exe"
+
+// NewWatcher returns a watcher that reports Overwatch starting and exiting on this machine
+func NewWatcher() *procwatch.Watcher {
+	return procwatch.New(procwatch.NewSystemSource(), procwatch.MatchNames(OverwatchProcessName), procwatch.DefaultInterval)
+}
+
+// IsOverwatchRunning takes a single process snapshot and reports whether Overwatch is in it
 func IsOverwatchRunning() (bool, error) {
-	// GUI handles this functionality now
-	return false, nil
+	events, err := NewWatcher().Poll()
+	if err != nil {
+		return false, err
+	}
+	return len(events) > 0, nil
 }
@@ -0,0 +1,225 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"quidque.no/ow-firewall-sidecar/internal/firewall"
+	"quidque.no/ow-firewall-sidecar/internal/snapshot"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Render formats an action result as text, single-line JSON or YAML
+func Render(v interface{}, format string) (string, error) {
+	switch format {
+	case FormatText:
+		return renderText(v), nil
+
+	case FormatJSON:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("encoding json: %w", err)
+		}
+		return string(data), nil
+
+	case FormatYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("encoding yaml: %w", err)
+		}
+		return strings.TrimRight(string(data), "\n"), nil
+
+	default:
+		return "", fmt.Errorf("unknown output format '%s'", format)
+	}
+}
+
+func renderText(v interface{}) string {
+	var b strings.Builder
+
+	switch r := v.(type) {
+	case firewall.Status:
+		fmt.Fprintf(&b, "Status: %s\n", readyText(r.Ready))
+		if r.Persistent {
+			b.WriteString("Persistent blocks: enabled\n")
+		}
+		fmt.Fprintf(&b, "Backend: %s\n", r.Backend)
+		fmt.Fprintf(&b, "Rule namespace: %s\n", r.Namespace)
+		if len(r.Targets) == 0 {
+			b.WriteString("Executables: Overwatch path not configured\n")
+		}
+		for _, target := range r.Targets {
+			fmt.Fprintf(&b, "Executable (%s): %s\n", target.Edition, target.Path)
+			if info := target.Info; info != nil {
+				fmt.Fprintf(&b, "Verified: %s (sha256 %s) at %s\n", firewall.ProductText(info), info.SHA256, info.VerifiedAt.Format("2006-01-02 15:04"))
+				for _, warning := range info.Warnings {
+					fmt.Fprintf(&b, "Verification warning: %s\n", warning)
+				}
+			}
+		}
+		fmt.Fprintf(&b, "IP lists: %s (version %s)\n", r.IPListDir, valueOrUnknown(r.IPListVersion))
+		if len(r.Regions) == 0 {
+			b.WriteString("Blocked regions: none\n")
+		}
+		for _, region := range r.Regions {
+			fmt.Fprintf(&b, "Blocked %s: %d IPs in %d rules\n", region.Region, region.IPCount, region.RuleCount)
+		}
+		if r.LastOperation != nil {
+			fmt.Fprintf(&b, "Last operation: %s at %s\n", operationText(*r.LastOperation), r.LastOperation.StartedAt.Format("15:04:05"))
+		}
+		for _, op := range r.Pending {
+			fmt.Fprintf(&b, "Pending: %s\n", operationText(op))
+		}
+
+	case firewall.RuleList:
+		if len(r.Regions) == 0 {
+			b.WriteString("No OW-VPN firewall rules found in this namespace\n")
+		}
+		for _, region := range r.Regions {
+			fmt.Fprintf(&b, "%s: %d rules\n", region.Region, len(region.Rules))
+			for _, rule := range region.Rules {
+				fmt.Fprintf(&b, "  %s (%s, %d ranges)\n", rule.Name, rule.Direction, len(rule.RemoteIPs))
+			}
+		}
+
+	case firewall.RegionRanges:
+		fmt.Fprintf(&b, "%s: %d ranges\n", r.Region, len(r.Ranges))
+		for _, entry := range r.Ranges {
+			fmt.Fprintf(&b, "  %-20s %s\n", entry.Range, strings.Join(entry.Rules, ", "))
+		}
+
+	case snapshot.Snapshot:
+		fmt.Fprintf(&b, "Snapshot created %s (backend %s)\n", r.CreatedAt.Format("2006-01-02 15:04:05"), r.Backend)
+		for _, target := range r.Targets {
+			fmt.Fprintf(&b, "Target: %s\n", target)
+		}
+		for _, region := range r.Regions {
+			fmt.Fprintf(&b, "%s: %d ranges\n", region.Name, len(region.Ranges))
+		}
+		for _, list := range r.CustomLists {
+			fmt.Fprintf(&b, "Custom list %s: %d ranges\n", list.Name, len(list.Ranges))
+		}
+		if r.Options.Protocol != "" {
+			fmt.Fprintf(&b, "Protocol: %s\n", r.Options.Protocol)
+		}
+
+	case []firewall.RegionInfo:
+		if len(r) == 0 {
+			b.WriteString("No region IP lists available\n")
+		}
+		for _, region := range r {
+			state := "unblocked"
+			if region.Blocked {
+				state = "blocked"
+			}
+			fmt.Fprintf(&b, "%s: %d IPs (%s)\n", region.Region, region.IPCount, state)
+		}
+
+	case []firewall.Operation:
+		if len(r) == 0 {
+			b.WriteString("No operations recorded\n")
+		}
+		for _, op := range r {
+			fmt.Fprintf(&b, "%s %s\n", op.StartedAt.Format("2006-01-02 15:04:05"), operationText(op))
+		}
+
+	case firewall.RestoreResult:
+		if len(r.Verified)+len(r.Reapplied)+len(r.Failed) == 0 {
+			b.WriteString("No persistent blocks to restore\n")
+		}
+		if len(r.Verified) > 0 {
+			fmt.Fprintf(&b, "Persistent blocks verified: %s\n", strings.Join(r.Verified, ", "))
+		}
+		if len(r.Reapplied) > 0 {
+			fmt.Fprintf(&b, "Persistent blocks re-applied: %s\n", strings.Join(r.Reapplied, ", "))
+		}
+		failed := make([]string, 0, len(r.Failed))
+		for region := range r.Failed {
+			failed = append(failed, region)
+		}
+		sort.Strings(failed)
+		for _, region := range failed {
+			fmt.Fprintf(&b, "ERROR: Failed to restore persistent block for %s: %s\n", region, r.Failed[region])
+		}
+
+	case firewall.DiscoveryResult:
+		if len(r.Candidates) == 0 {
+			b.WriteString("No Overwatch installation found\n")
+		}
+		for _, candidate := range r.Candidates {
+			state := "verified"
+			if !candidate.Verified {
+				state = "refused: " + candidate.Problem
+			}
+			fmt.Fprintf(&b, "%3d%% %s (%s; %s)\n", candidate.Confidence, candidate.Path, strings.Join(candidate.Sources, ", "), state)
+		}
+		for _, warning := range r.Warnings {
+			fmt.Fprintf(&b, "Warning: %s\n", warning)
+		}
+
+	case firewall.ImportResult:
+		fmt.Fprintf(&b, "Imported %d regions and %d custom lists (%d rules)\n", len(r.AppliedRegions), len(r.AppliedCustomLists), r.RuleCount)
+		for _, target := range r.Targets {
+			fmt.Fprintf(&b, "Target: %s\n", target)
+		}
+		if len(r.AppliedRegions) > 0 {
+			fmt.Fprintf(&b, "Regions: %s\n", strings.Join(r.AppliedRegions, ", "))
+		}
+		if len(r.AppliedCustomLists) > 0 {
+			fmt.Fprintf(&b, "Custom lists: %s\n", strings.Join(r.AppliedCustomLists, ", "))
+		}
+		for _, target := range r.UnresolvedTargets {
+			fmt.Fprintf(&b, "Warning: Target not found locally: %s\n", target)
+		}
+		for _, region := range r.UnresolvedRegions {
+			fmt.Fprintf(&b, "Warning: Could not resolve %s locally\n", region)
+		}
+		names := make([]string, 0, len(r.UnresolvedRanges))
+		for name := range r.UnresolvedRanges {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "Warning: %d ranges for %s not in local IP lists: %s\n", len(r.UnresolvedRanges[name]), name, strings.Join(r.UnresolvedRanges[name], ", "))
+		}
+
+	default:
+		fmt.Fprint(&b, v)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func readyText(ready bool) string {
+	if ready {
+		return "Ready"
+	}
+	return "Overwatch path not configured"
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+func operationText(op firewall.Operation) string {
+	text := op.Action
+	if op.Region != "" {
+		text += " " + op.Region
+	}
+	if op.Error != "" {
+		text += " (failed: " + op.Error + ")"
+	}
+	return text
+}
@@ -0,0 +1,130 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"quidque.no/ow-firewall-sidecar/internal/config"
+)
+
+// SchemaVersion is the snapshot format written by this sidecar
+const SchemaVersion = 1
+
+// Region lists the ranges blocked for one region
+type Region struct {
+	Name   string   `json:"name" yaml:"name"`
+	Ranges []string `json:"ranges,omitempty" yaml:"ranges,omitempty"`
+}
+
+// CustomList is a named list of ranges that is not backed by a region IP list
+type CustomList struct {
+	Name   string   `json:"name" yaml:"name"`
+	Ranges []string `json:"ranges" yaml:"ranges"`
+}
+
+// Options controls how the rules of a snapshot are created
+type Options struct {
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+}
+
+// Snapshot is a portable description of the block state of a sidecar
+type Snapshot struct {
+	SchemaVersion int          `json:"schemaVersion" yaml:"schemaVersion"`
+	CreatedAt     time.Time    `json:"createdAt" yaml:"createdAt"`
+	Backend       string       `json:"backend" yaml:"backend"`
+	Targets       []string     `json:"targets" yaml:"targets"`
+	IPListVersion string       `json:"ipListVersion,omitempty" yaml:"ipListVersion,omitempty"`
+	Regions       []Region     `json:"regions" yaml:"regions"`
+	CustomLists   []CustomList `json:"customLists,omitempty" yaml:"customLists,omitempty"`
+	Options       Options      `json:"options" yaml:"options"`
+}
+
+// New creates an empty snapshot stamped with the current time
+func New(backend string) Snapshot {
+	return Snapshot{
+		SchemaVersion: SchemaVersion,
+		CreatedAt:     time.Now(),
+		Backend:       backend,
+		Targets:       []string{},
+		Regions:       []Region{},
+	}
+}
+
+// Load reads a snapshot from a JSON or YAML file, choosing the format by extension
+func Load(path string) (Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	var snap Snapshot
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &snap)
+	default:
+		err = json.Unmarshal(data, &snap)
+	}
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("parsing snapshot %s: %w", path, err)
+	}
+
+	if err := snap.Validate(); err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// validName matches region codes and custom list names. Region names become file names
+// in the IP list directory, custom list names become part of rule names.
+var validName = regexp.MustCompile(config.RegionNamePattern)
+
+// Validate checks the structure of a snapshot without touching the local IP lists
+func (s Snapshot) Validate() error {
+	if s.SchemaVersion < 1 || s.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("unsupported snapshot schema version %d", s.SchemaVersion)
+	}
+
+	switch s.Options.Protocol {
+	case "", config.ProtocolAny, config.ProtocolTCP, config.ProtocolUDP:
+	default:
+		return fmt.Errorf("unsupported protocol '%s' in snapshot options", s.Options.Protocol)
+	}
+
+	if len(s.Regions) == 0 && len(s.CustomLists) == 0 {
+		return fmt.Errorf("snapshot contains no regions or custom lists")
+	}
+
+	names := make(map[string]bool)
+	for _, region := range s.Regions {
+		if region.Name == "" {
+			return fmt.Errorf("snapshot contains a region without a name")
+		}
+		if !validName.MatchString(region.Name) {
+			return fmt.Errorf("invalid region name '%s' in snapshot: use a region code such as EU", region.Name)
+		}
+		if names[strings.ToUpper(region.Name)] {
+			return fmt.Errorf("region %s appears more than once in snapshot", region.Name)
+		}
+		names[strings.ToUpper(region.Name)] = true
+	}
+
+	names = make(map[string]bool)
+	for _, list := range s.CustomLists {
+		if !validName.MatchString(list.Name) {
+			return fmt.Errorf("invalid custom list name '%s'", list.Name)
+		}
+		if names[strings.ToUpper(list.Name)] {
+			return fmt.Errorf("custom list %s appears more than once in snapshot", list.Name)
+		}
+		names[strings.ToUpper(list.Name)] = true
+	}
+
+	return nil
+}
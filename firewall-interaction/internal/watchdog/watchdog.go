@@ -0,0 +1,89 @@
+package watchdog
+
+import (
+	"sync"
+	"time"
+)
+
+// Watchdog calls onExpire when no heartbeat arrives within the grace period.
+// It is armed by the first heartbeat, so clients that never send one are not affected.
+// Only the client that armed it keeps it alive: heartbeats from other clients are
+// ignored, so a second client cannot hide that the first one is gone. After expiry the
+// next client to send a heartbeat arms it again.
+type Watchdog struct {
+	grace    time.Duration
+	onExpire func(silence time.Duration)
+	onResume func(silence time.Duration)
+	mutex    sync.Mutex
+	timer    *time.Timer
+	owner    string
+	last     time.Time
+	expired  bool
+	stopped  bool
+}
+
+// New creates a watchdog. onResume is called when heartbeats arrive again after expiry and may be nil.
+func New(grace time.Duration, onExpire, onResume func(silence time.Duration)) *Watchdog {
+	return &Watchdog{
+		grace:    grace,
+		onExpire: onExpire,
+		onResume: onResume,
+	}
+}
+
+// Beat records a heartbeat from client and restarts the grace period, unless another
+// client armed the watchdog
+func (w *Watchdog) Beat(client string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped || w.grace <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if w.expired {
+		w.expired = false
+		w.owner = client
+		if w.onResume != nil {
+			go w.onResume(now.Sub(w.last))
+		}
+	}
+	if w.timer == nil {
+		w.owner = client
+	}
+	if client != w.owner {
+		return
+	}
+	w.last = now
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.grace, w.expire)
+		return
+	}
+	w.timer.Reset(w.grace)
+}
+
+// Stop disarms the watchdog permanently
+func (w *Watchdog) Stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *Watchdog) expire() {
+	w.mutex.Lock()
+	if w.stopped || w.expired || time.Since(w.last) < w.grace {
+		w.mutex.Unlock()
+		return
+	}
+	w.expired = true
+	silence := time.Since(w.last)
+	w.mutex.Unlock()
+
+	w.onExpire(silence)
+}
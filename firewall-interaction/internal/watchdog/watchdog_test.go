@@ -0,0 +1,129 @@
+package watchdog
+
+import (
+	"testing"
+	"time"
+)
+
+const grace = 50 * time.Millisecond
+
+func newTestWatchdog() (*Watchdog, chan time.Duration, chan time.Duration) {
+	expired := make(chan time.Duration, 4)
+	resumed := make(chan time.Duration, 4)
+	w := New(grace,
+		func(silence time.Duration) { expired <- silence },
+		func(silence time.Duration) { resumed <- silence })
+	return w, expired, resumed
+}
+
+func expectExpiry(t *testing.T, expired chan time.Duration) {
+	t.Helper()
+	select {
+	case silence := <-expired:
+		if silence < grace {
+			t.Errorf("expired after %s of silence, want at least %s", silence, grace)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not expire")
+	}
+}
+
+func expectNoExpiry(t *testing.T, expired chan time.Duration, wait time.Duration) {
+	t.Helper()
+	select {
+	case <-expired:
+		t.Fatal("watchdog expired")
+	case <-time.After(wait):
+	}
+}
+
+func TestNotArmedWithoutHeartbeat(t *testing.T) {
+	w, expired, _ := newTestWatchdog()
+	defer w.Stop()
+
+	expectNoExpiry(t, expired, 3*grace)
+}
+
+func TestExpiresAfterGrace(t *testing.T) {
+	w, expired, _ := newTestWatchdog()
+	defer w.Stop()
+
+	w.Beat("stdin")
+	expectExpiry(t, expired)
+	expectNoExpiry(t, expired, 2*grace)
+}
+
+func TestHeartbeatsResetTheGracePeriod(t *testing.T) {
+	w, expired, _ := newTestWatchdog()
+	defer w.Stop()
+
+	for i := 0; i < 6; i++ {
+		w.Beat("stdin")
+		expectNoExpiry(t, expired, grace/2)
+	}
+	expectExpiry(t, expired)
+}
+
+func TestOtherClientsDoNotKeepItAlive(t *testing.T) {
+	w, expired, _ := newTestWatchdog()
+	defer w.Stop()
+
+	w.Beat("stdin")
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(grace / 5)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.Beat("control-1")
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	expectExpiry(t, expired)
+}
+
+func TestResumesAfterExpiry(t *testing.T) {
+	w, expired, resumed := newTestWatchdog()
+	defer w.Stop()
+
+	w.Beat("stdin")
+	expectExpiry(t, expired)
+
+	// After expiry the next client to send a heartbeat arms the watchdog again
+	w.Beat("control-1")
+	select {
+	case silence := <-resumed:
+		if silence < grace {
+			t.Errorf("resumed after %s of silence, want at least %s", silence, grace)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watchdog did not report the resumed heartbeat")
+	}
+	w.Beat("stdin")
+	expectExpiry(t, expired)
+}
+
+func TestStopDisarms(t *testing.T) {
+	w, expired, _ := newTestWatchdog()
+
+	w.Beat("stdin")
+	w.Stop()
+	expectNoExpiry(t, expired, 3*grace)
+
+	w.Beat("stdin")
+	expectNoExpiry(t, expired, 3*grace)
+}
+
+func TestZeroGraceDisablesIt(t *testing.T) {
+	expired := make(chan time.Duration, 1)
+	w := New(0, func(silence time.Duration) { expired <- silence }, nil)
+	defer w.Stop()
+
+	w.Beat("stdin")
+	expectNoExpiry(t, expired, 3*grace)
+}
@@ -38,3 +38,7 @@ require (
 	golang.org/x/text v0.22.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+require quidque.no/ow-vpn-shared v0.0.0
+
+replace quidque.no/ow-vpn-shared => ../shared
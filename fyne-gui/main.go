@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -22,6 +23,11 @@ import (
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+
+	"quidque.no/ow-vpn-shared/configstore"
+	"quidque.no/ow-vpn-shared/discovery"
+	"quidque.no/ow-vpn-shared/iprange"
+	"quidque.no/ow-vpn-shared/procwatch"
 )
 
 var regions = []string{"EU", "NA", "AS", "AFR", "ME", "OCE", "SA"}
@@ -32,10 +38,41 @@ var (
 	colorTitle     = color.NRGBA{R: 66, G: 139, B: 202, A: 255}
 )
 
-type Config struct {
-	OverwatchPath    string `json:"overwatchPath"`
-	UseGithubSource  bool   `json:"useGithubSource"`
-	InitialSetupDone bool   `json:"initialSetupDone"`
+type SidecarRegionStatus struct {
+	Region    string `json:"region"`
+	RuleCount int    `json:"ruleCount"`
+	IPCount   int    `json:"ipCount"`
+}
+
+type SidecarStatus struct {
+	Ready          bool                  `json:"ready"`
+	Persistent     bool                  `json:"persistent"`
+	Namespace      string                `json:"namespace"`
+	Backend        string                `json:"backend"`
+	Executables    []string              `json:"executables"`
+	PathConfigured bool                  `json:"pathConfigured"`
+	IPListDir      string                `json:"ipListDir"`
+	IPListVersion  string                `json:"ipListVersion"`
+	Regions        []SidecarRegionStatus `json:"regions"`
+}
+
+type SidecarEvent struct {
+	Type    string            `json:"type"`
+	Action  string            `json:"action"`
+	Region  string            `json:"region"`
+	Source  string            `json:"source"`
+	Time    time.Time         `json:"time"`
+	Status  SidecarStatus     `json:"status"`
+	Process procwatch.Process `json:"process"`
+}
+
+// IPSnapshot is a stored IP list version, as listed by ip-puller -list-snapshots
+type IPSnapshot struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Source    string    `json:"source"`
+	ID        string    `json:"id"`
+	Pinned    bool      `json:"pinned"`
 }
 
 type OwVpnGui struct {
@@ -45,19 +82,23 @@ type OwVpnGui struct {
 	statusIcon             *canvas.Image
 	progressBar            *widget.ProgressBarInfinite
 	regionButtons          map[string]*widget.Button
+	persistCheck           *widget.Check
 	firewallCmd            *exec.Cmd
 	cmdStdin               io.WriteCloser
-	blocked                map[string]bool
+	blocked                map[string]bool // blocked and pending belong to the UI thread
+	pending                map[string]bool
+	cancelPendingBtn       *widget.Button
 	blockingInProgress     bool
 	blockingMutex          sync.Mutex
 	availableRegions       []string
 	overwatchPath          string
 	pathConfigured         bool
 	useGithubSource        bool
-	config                 Config
-	configPath             string
+	config                 configstore.Config
+	store                  *configstore.Store
 	isOverwatchRunning     bool
-	processMutex           sync.Mutex
+	overwatchProcesses     map[int]procwatch.Process
+	processMutex           sync.Mutex // guards isOverwatchRunning and overwatchProcesses
 	isInitialized          bool
 	initialSetupDone       bool
 	pendingDetectionDialog dialog.Dialog
@@ -90,15 +131,17 @@ func main() {
 		progressBar:        widget.NewProgressBarInfinite(),
 		regionButtons:      make(map[string]*widget.Button),
 		blocked:            make(map[string]bool),
+		pending:            make(map[string]bool),
+		overwatchProcesses: make(map[int]procwatch.Process),
 		blockingInProgress: false,
 		availableRegions:   []string{},
 		pathConfigured:     false,
-		configPath:         "config.json",
 		isInitialized:      false,
 		initialSetupDone:   false,
 		useGithubSource:    true,
 	}
 
+	gui.openConfigStore()
 	gui.loadConfig()
 	gui.updateRegionButtons()
 
@@ -132,7 +175,7 @@ func (g *OwVpnGui) showPendingDetectionDialog() {
 	content := container.NewVBox(
 		widget.NewLabelWithStyle("Overwatch Not Detected", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
 		widget.NewLabel(""),
-		widget.NewLabel("Overwatch has not been detected yet."),
+		widget.NewLabel("No Overwatch installation was found on this PC."),
 		widget.NewLabel(""),
 		widget.NewLabel("Please start Overwatch to automatically detect and configure the application."),
 		widget.NewLabel(""),
@@ -164,10 +207,12 @@ func (g *OwVpnGui) showHowToUseWindow() {
 		container.NewTabItem("Blocking Regions", container.NewVBox(
 			widget.NewLabelWithStyle("How to Block Regions", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
 			widget.NewLabel(""),
-			widget.NewLabel("1. Wait for Overwatch to be closed"),
-			widget.NewLabel("2. Click on a region button to block it"),
-			widget.NewLabel("3. The button will turn red indicating the region is blocked"),
-			widget.NewLabel("4. Launch Overwatch to play with blocked regions"),
+			widget.NewLabel("1. Click on a region button to block it"),
+			widget.NewLabel("2. The button will turn red indicating the region is blocked"),
+			widget.NewLabel("3. Launch Overwatch to play with blocked regions"),
+			widget.NewLabel(""),
+			widget.NewLabel("While Overwatch is running, selected regions show as pending and are"),
+			widget.NewLabel("blocked when it exits. Click a pending region or 'CANCEL PENDING' to cancel."),
 		)),
 		container.NewTabItem("Unblocking Regions", container.NewVBox(
 			widget.NewLabelWithStyle("How to Unblock Regions", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
@@ -180,14 +225,15 @@ func (g *OwVpnGui) showHowToUseWindow() {
 			widget.NewLabelWithStyle("Application Behavior", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
 			widget.NewLabel(""),
 			widget.NewLabel("• When you start the app, it fetches the latest IP addresses"),
-			widget.NewLabel("• The app detects Overwatch automatically when it's running"),
+			widget.NewLabel("• The app finds your Overwatch installation, or detects it when the game is running"),
 			widget.NewLabel("• When you close the app, all blocks are automatically removed"),
 		)),
 		container.NewTabItem("Important Notes", container.NewVBox(
 			widget.NewLabelWithStyle("Important Information", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
 			widget.NewLabel(""),
-			widget.NewLabel("• This software only works with Battle.net, as you need to choose the login region"),
-			widget.NewLabel("• You cannot block regions while Overwatch is running"),
+			widget.NewLabel("• Works with the Battle.net and Steam versions of Overwatch 2"),
+			widget.NewLabel("• Steam has no region selector, so blocking regions is how Steam players pick servers"),
+			widget.NewLabel("• Blocks selected while Overwatch is running wait until it exits"),
 			widget.NewLabel("• If you can't connect to a game, try unblocking regions"),
 			widget.NewLabel("• All blocks are automatically removed when you close the app"),
 			widget.NewLabel("• The app requires administrator privileges for firewall access"),
@@ -224,40 +270,96 @@ func showAdminPermissionsDialog(w fyne.Window) {
 	dialog.Show()
 }
 
-func (g *OwVpnGui) loadConfig() {
-	data, err := os.ReadFile(g.configPath)
+// openConfigStore opens the config directory shared with the sidecar, importing a
+// config.json left next to the executable by older versions and upgrading it to the
+// current schema
+func (g *OwVpnGui) openConfigStore() {
+	dir, err := configstore.ResolveDir("", "")
 	if err == nil {
-		if err := json.Unmarshal(data, &g.config); err == nil {
-			g.logImportant(fmt.Sprintf("Loaded configuration from %s", g.configPath))
-			g.overwatchPath = g.config.OverwatchPath
-			g.useGithubSource = true
-			g.initialSetupDone = g.config.InitialSetupDone
-
-			if g.overwatchPath != "" && fileExists(g.overwatchPath) {
-				g.pathConfigured = true
-				g.logImportant(fmt.Sprintf("Using configured Overwatch path: %s", g.overwatchPath))
-			} else {
-				g.pathConfigured = false
-				g.logImportant("Configured Overwatch path no longer exists, will detect automatically")
-			}
+		g.store, err = configstore.Open(dir)
+	}
+	if err != nil {
+		g.logError(fmt.Sprintf("Error opening config directory, using working directory: %v", err))
+		cwd, _ := os.Getwd()
+		g.store, _ = configstore.Open(cwd)
+		return
+	}
+
+	if imported, err := g.store.ImportShared(); err != nil {
+		g.logError(fmt.Sprintf("Error taking over the configuration shared by earlier versions: %v", err))
+	} else if imported {
+		g.logImportant(fmt.Sprintf("Moved the configuration shared by earlier versions to %s", g.store.Dir()))
+	}
+
+	if legacy, err := filepath.Abs(configstore.FileName); err == nil && legacy != g.store.Path(configstore.FileName) {
+		if imported, err := g.store.ImportLegacy(legacy); err != nil {
+			g.logError(fmt.Sprintf("Error importing %s: %v", legacy, err))
+		} else if imported {
+			g.logImportant(fmt.Sprintf("Moved configuration to %s", g.store.Dir()))
 		}
 	}
-}
 
-func (g *OwVpnGui) saveConfig() {
-	g.config.OverwatchPath = g.overwatchPath
-	g.config.UseGithubSource = true
-	g.config.InitialSetupDone = g.initialSetupDone
+	migration, err := g.store.Migrate()
+	if err != nil {
+		g.logError(fmt.Sprintf("Error upgrading configuration: %v", err))
+		dialog.ShowError(fmt.Errorf("the configuration in %s could not be loaded: %v", g.store.Dir(), err), g.window)
+		return
+	}
+	if migration.Migrated() {
+		g.logImportant(fmt.Sprintf("Upgraded configuration from version %d to %d, previous file saved as %s", migration.From, migration.To, migration.Backup))
+	}
+}
 
-	data, err := json.MarshalIndent(g.config, "", "  ")
+func (g *OwVpnGui) loadConfig() {
+	cfg, err := g.store.Load()
 	if err != nil {
-		g.logError(fmt.Sprintf("Error creating config JSON: %v", err))
+		g.logError(fmt.Sprintf("Error loading configuration: %v", err))
+		return
+	}
+
+	g.config = cfg
+	g.logImportant(fmt.Sprintf("Loaded configuration from %s", g.store.Dir()))
+	g.useGithubSource = g.config.UseGithubSource
+	g.initialSetupDone = g.config.InitialSetupDone
+
+	if len(g.config.Targets) == 0 {
 		return
 	}
 
-	if err := os.WriteFile(g.configPath, data, 0644); err != nil {
+	for _, target := range g.config.Targets {
+		if fileExists(target.Path) {
+			g.overwatchPath = target.Path
+			g.pathConfigured = true
+			g.logImportant(fmt.Sprintf("Using configured Overwatch path (%s): %s", editionName(target.Edition), target.Path))
+		}
+	}
+
+	if !g.pathConfigured {
+		g.logImportant("Configured Overwatch path no longer exists, will detect automatically")
+	}
+}
+
+// editionName returns the store name shown for a target edition
+func editionName(edition string) string {
+	if edition == configstore.EditionSteam {
+		return "Steam"
+	}
+	return "Battle.net"
+}
+
+// saveConfig stores the settings owned by the GUI. Settings written by the sidecar, such
+// as the rule namespace and the verified Overwatch targets, are left untouched.
+func (g *OwVpnGui) saveConfig() {
+	cfg, err := g.store.Update(func(cfg *configstore.Config) error {
+		cfg.UseGithubSource = g.useGithubSource
+		cfg.InitialSetupDone = g.initialSetupDone
+		return nil
+	})
+	if err != nil {
 		g.logError(fmt.Sprintf("Error writing config file: %v", err))
+		return
 	}
+	g.config = cfg
 }
 
 func (g *OwVpnGui) getIPDirectory() string {
@@ -282,8 +384,14 @@ func (g *OwVpnGui) updateAvailableRegions() {
 			if err != nil || len(fileContent) == 0 {
 				continue
 			}
-			lineCount := strings.Count(string(fileContent), "\n") + 1
-			g.logInfo(fmt.Sprintf("Found IP list for region %s with %d IPs", region, lineCount))
+			ranges, invalid := iprange.ParseList(string(fileContent))
+			if invalid != nil {
+				g.logImportant(fmt.Sprintf("IP list for region %s has %v", region, invalid))
+			}
+			if len(ranges) == 0 {
+				continue
+			}
+			g.logInfo(fmt.Sprintf("Found IP list for region %s with %d IPs", region, len(ranges)))
 			g.availableRegions = append(g.availableRegions, region)
 		}
 	}
@@ -294,10 +402,16 @@ func (g *OwVpnGui) updateAvailableRegions() {
 func (g *OwVpnGui) detectOverwatchPath() {
 	g.logImportant("Attempting to detect Overwatch path...")
 
-	if path, success := g.findOverwatchProcess(); success {
+	path, success := g.findOverwatchProcess()
+	if success {
+		g.logImportant(fmt.Sprintf("Detected Overwatch at: %s", path))
+	} else {
+		path, success = g.discoverOverwatchPath()
+	}
+
+	if success {
 		g.overwatchPath = path
 		g.pathConfigured = true
-		g.logImportant(fmt.Sprintf("Detected Overwatch at: %s", path))
 
 		if err := g.sendCommand(fmt.Sprintf("set-path|%s", path)); err != nil {
 			g.logError(fmt.Sprintf("Error setting Overwatch path: %v", err))
@@ -325,48 +439,54 @@ func (g *OwVpnGui) detectOverwatchPath() {
 	}
 }
 
-func (g *OwVpnGui) findOverwatchProcess() (string, bool) {
-	cmd := exec.Command("powershell", "-Command",
-		"Get-Process -Name 'Overwatch' -ErrorAction SilentlyContinue | Select-Object -ExpandProperty Path")
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		HideWindow: true,
+// discoverOverwatchPath looks for an installed copy of Overwatch when the game is not
+// running, using the Battle.net and Steam install records
+func (g *OwVpnGui) discoverOverwatchPath() (string, bool) {
+	candidates, warnings := discovery.Discover(discovery.DefaultEnvironment(), discovery.Overwatch)
+	for _, warning := range warnings {
+		g.logInfo(fmt.Sprintf("Install discovery: %v", warning))
 	}
 
-	output, err := cmd.Output()
-	if err != nil {
+	best, found := discovery.Best(candidates)
+	if !found {
 		return "", false
 	}
 
-	path := strings.TrimSpace(string(output))
-	if path != "" {
-		return path, true
-	}
-
-	return "", false
+	g.logImportant(fmt.Sprintf("Found %s installation of Overwatch at: %s (%s, confidence %d%%)",
+		editionName(best.Edition), best.Path, strings.Join(best.Sources, ", "), best.Confidence))
+	return best.Path, true
 }
 
-func (g *OwVpnGui) isOverwatchProcessRunning() bool {
-	cmd := exec.Command("powershell", "-Command",
-		"Get-Process -Name 'Overwatch' -ErrorAction SilentlyContinue | Measure-Object | Select-Object -ExpandProperty Count")
+// findOverwatchProcess returns the executable of a running Overwatch process
+func (g *OwVpnGui) findOverwatchProcess() (string, bool) {
+	g.processMutex.Lock()
+	defer g.processMutex.Unlock()
 
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		HideWindow: true,
+	// Only one edition runs at a time, but take the lowest PID in case of several
+	pid := 0
+	for candidate, process := range g.overwatchProcesses {
+		if process.Path != "" && (pid == 0 || candidate < pid) {
+			pid = candidate
+		}
 	}
-
-	output, err := cmd.Output()
-	if err != nil {
-		return false
+	if pid == 0 {
+		return "", false
 	}
-
-	count := strings.TrimSpace(string(output))
-	return count != "0"
+	return g.overwatchProcesses[pid].Path, true
 }
 
-func (g *OwVpnGui) checkOverwatchProcessStatus() {
+// handleProcessEvent updates the running state when the sidecar reports that Overwatch
+// started or exited. The sidecar logs the event itself.
+func (g *OwVpnGui) handleProcessEvent(event procwatch.Event) {
 	g.processMutex.Lock()
+	switch event.Type {
+	case procwatch.Started:
+		g.overwatchProcesses[event.Process.PID] = event.Process
+	case procwatch.Exited:
+		delete(g.overwatchProcesses, event.Process.PID)
+	}
 	wasRunning := g.isOverwatchRunning
-	isRunning := g.isOverwatchProcessRunning()
+	isRunning := len(g.overwatchProcesses) > 0
 	g.isOverwatchRunning = isRunning
 	g.processMutex.Unlock()
 
@@ -375,6 +495,7 @@ func (g *OwVpnGui) checkOverwatchProcessStatus() {
 		if g.pathConfigured {
 			g.setStatus("Ready", theme.ConfirmIcon())
 			g.enableRegionButtons()
+			g.applyPendingBlocks()
 		}
 	} else if !wasRunning && isRunning {
 		g.logImportant("Detected Overwatch is now running")
@@ -397,24 +518,9 @@ func (g *OwVpnGui) checkOverwatchProcessStatus() {
 	}
 }
 
-func (g *OwVpnGui) startProcessMonitoring() {
-	go func() {
-		time.Sleep(2 * time.Second)
-
-		for {
-			g.checkOverwatchProcessStatus()
-			time.Sleep(5 * time.Second)
-		}
-	}()
-}
-
 func (g *OwVpnGui) enableRegionButtons() {
-	for region, btn := range g.regionButtons {
-		if !g.isOverwatchRunning || g.blocked[region] {
-			btn.Enable()
-		} else {
-			btn.Disable()
-		}
+	for _, btn := range g.regionButtons {
+		btn.Enable()
 	}
 	g.window.Canvas().Refresh(g.window.Content())
 }
@@ -426,15 +532,102 @@ func (g *OwVpnGui) disableRegionButtons() {
 	g.window.Canvas().Refresh(g.window.Content())
 }
 
+// updateButtonStatesForOverwatchRunning keeps the region buttons usable while the game
+// runs, since blocks selected then are queued until it exits
 func (g *OwVpnGui) updateButtonStatesForOverwatchRunning() {
-	for region, btn := range g.regionButtons {
-		if g.isOverwatchRunning && !g.blocked[region] {
-			btn.Disable()
-		} else {
-			btn.Enable()
+	if !g.pathConfigured {
+		return
+	}
+	g.enableRegionButtons()
+}
+
+// refreshRegionButton shows whether a region is blocked, unblocked or waiting for the game
+// to exit before it is blocked
+func (g *OwVpnGui) refreshRegionButton(region string) {
+	btn := g.regionButtons[region]
+	if btn == nil {
+		return
+	}
+
+	switch {
+	case g.pending[region]:
+		btn.Importance = widget.WarningImportance
+		btn.SetText(region + " (pending)")
+		btn.SetIcon(theme.HistoryIcon())
+	case g.blocked[region]:
+		btn.Importance = widget.DangerImportance
+		btn.SetText(region)
+		btn.SetIcon(theme.ContentAddIcon())
+	default:
+		btn.Importance = widget.SuccessImportance
+		btn.SetText(region)
+		btn.SetIcon(theme.ContentRemoveIcon())
+	}
+	g.window.Canvas().Refresh(btn)
+}
+
+// queueBlock marks a region to be blocked as soon as Overwatch exits, since new firewall
+// rules would not apply to connections the running game already has
+func (g *OwVpnGui) queueBlock(region string) {
+	g.pending[region] = true
+	g.logImportant(fmt.Sprintf("Overwatch is running, region %s will be blocked when it exits", region))
+	g.refreshRegionButton(region)
+	g.updatePendingControls()
+}
+
+// cancelPending drops queued blocks, or every queued block when no region is given
+func (g *OwVpnGui) cancelPending(regions ...string) {
+	if len(regions) == 0 {
+		for region := range g.pending {
+			regions = append(regions, region)
 		}
+		sort.Strings(regions)
 	}
-	g.window.Canvas().Refresh(g.window.Content())
+
+	for _, region := range regions {
+		if !g.pending[region] {
+			continue
+		}
+		delete(g.pending, region)
+		g.logImportant(fmt.Sprintf("Cancelled pending block of region %s", region))
+		g.refreshRegionButton(region)
+	}
+	g.updatePendingControls()
+}
+
+// applyPendingBlocks blocks every queued region once Overwatch has exited
+func (g *OwVpnGui) applyPendingBlocks() {
+	if len(g.pending) == 0 {
+		return
+	}
+
+	regions := make([]string, 0, len(g.pending))
+	for region := range g.pending {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	g.logImportant(fmt.Sprintf("Overwatch exited, applying pending blocks: %s", strings.Join(regions, ", ")))
+	for _, region := range regions {
+		delete(g.pending, region)
+		g.blockRegion(region)
+	}
+	g.updatePendingControls()
+}
+
+// updatePendingControls shows the cancel button and status only while blocks are queued
+func (g *OwVpnGui) updatePendingControls() {
+	if g.cancelPendingBtn == nil {
+		return
+	}
+
+	if len(g.pending) == 0 {
+		g.cancelPendingBtn.Hide()
+		return
+	}
+
+	g.cancelPendingBtn.Show()
+	g.setStatus(fmt.Sprintf("Overwatch is running, %d region(s) will be blocked when it exits", len(g.pending)), theme.WarningIcon())
 }
 
 func (g *OwVpnGui) updateRegionButtons() {
@@ -491,22 +684,41 @@ func (g *OwVpnGui) updateRegionButtons() {
 	unblockAllBtn.Importance = widget.HighImportance
 	unblockAllBtnContainer := container.NewPadded(unblockAllBtn)
 
+	g.cancelPendingBtn = widget.NewButtonWithIcon("CANCEL PENDING", theme.CancelIcon(), func() {
+		g.cancelPending()
+	})
+	g.cancelPendingBtn.Importance = widget.WarningImportance
+	cancelPendingBtnContainer := container.NewPadded(g.cancelPendingBtn)
+
 	howToUseBtn := widget.NewButtonWithIcon("HOW TO USE", theme.HelpIcon(), func() {
 		g.showHowToUseWindow()
 	})
 	howToUseBtn.Importance = widget.MediumImportance
 	howToUseBtnContainer := container.NewPadded(howToUseBtn)
 
+	ipListsBtn := widget.NewButtonWithIcon("IP LISTS", theme.HistoryIcon(), func() {
+		g.showIPListsDialog()
+	})
+	ipListsBtn.Importance = widget.MediumImportance
+	ipListsBtnContainer := container.NewPadded(ipListsBtn)
+
 	resetConfigBtn := widget.NewButtonWithIcon("RESET CONFIG", theme.SettingsIcon(), func() {
 		g.resetConfig()
 	})
 	resetConfigBtn.Importance = widget.MediumImportance
 	resetConfigBtnContainer := container.NewPadded(resetConfigBtn)
 
+	g.persistCheck = widget.NewCheck("Keep blocks after closing (persistent)", func(checked bool) {
+		g.setPersistentBlocks(checked)
+	})
+	g.persistCheck.SetChecked(g.config.PersistentBlocks)
+
 	buttonControls := container.NewHBox(
 		layout.NewSpacer(),
 		unblockAllBtnContainer,
+		cancelPendingBtnContainer,
 		howToUseBtnContainer,
+		ipListsBtnContainer,
 		resetConfigBtnContainer,
 		layout.NewSpacer(),
 	)
@@ -526,12 +738,14 @@ func (g *OwVpnGui) updateRegionButtons() {
 		container.NewPadded(regionLabel),
 		container.NewPadded(regionButtons),
 		container.NewPadded(buttonControls),
+		container.NewCenter(g.persistCheck),
 		widget.NewSeparator(),
 		container.NewPadded(logLabel),
 		container.NewPadded(scrollLog),
 	)
 
 	g.window.SetContent(container.NewPadded(content))
+	g.updatePendingControls()
 }
 
 func (g *OwVpnGui) initialize() {
@@ -552,7 +766,7 @@ func (g *OwVpnGui) initialize() {
 			if _, err := os.Stat(versionFilePath); err == nil {
 				needUpdateCmd := exec.Command(
 					filepath.Join(filepath.Dir(os.Args[0]), "ip-puller.exe"),
-					"-version=check",
+					g.ipPullerArgs("-version=check")...,
 				)
 				needUpdateCmd.SysProcAttr = &syscall.SysProcAttr{
 					HideWindow: true,
@@ -574,14 +788,14 @@ func (g *OwVpnGui) initialize() {
 	}
 
 	if needIPUpdate {
-		g.logImportant("Fetching IP addresses from GitHub source...")
-		if err := g.runIpPuller(true); err != nil {
+		g.logImportant(fmt.Sprintf("Fetching IP addresses from %s source...", g.ipSourceName()))
+		if err := g.runIpPuller(g.useGithubSource); err != nil {
 			g.logError(fmt.Sprintf("Error fetching IPs: %v", err))
 			g.setStatus("Error: IP Puller failed", theme.ErrorIcon())
 			dialog.ShowError(fmt.Errorf("failed to run IP Puller: %v", err), g.window)
 			return
 		}
-		g.logImportant("Successfully fetched IPs from GitHub")
+		g.logImportant(fmt.Sprintf("Successfully fetched IPs from %s", g.ipSourceName()))
 	}
 
 	g.updateAvailableRegions()
@@ -601,11 +815,13 @@ func (g *OwVpnGui) initialize() {
 
 	time.Sleep(500 * time.Millisecond)
 
-	g.startProcessMonitoring()
-
 	g.isInitialized = true
 
-	if !g.pathConfigured || g.isOverwatchRunning {
+	g.processMutex.Lock()
+	isRunning := g.isOverwatchRunning
+	g.processMutex.Unlock()
+
+	if !g.pathConfigured || isRunning {
 		g.detectOverwatchPath()
 	}
 
@@ -629,28 +845,170 @@ func (g *OwVpnGui) initialize() {
 }
 
 func (g *OwVpnGui) runIpPuller(useGithub bool) error {
-	exePath, err := filepath.Abs(filepath.Join(filepath.Dir(os.Args[0]), "ip-puller.exe"))
+	// A forced run still leaves lists alone that were pinned by a rollback
+	args := []string{}
+	if useGithub {
+		args = append(args, "-version=force")
+	}
+
+	output, err := runIpPullerCommand(g.ipPullerArgs(args...)...)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %v", err)
+		return err
+	}
+	if pinned := pinnedLine(output); pinned != "" {
+		g.logImportant(pinned)
 	}
+	return nil
+}
 
-	var cmd *exec.Cmd
-	if useGithub {
-		cmd = exec.Command(exePath, "-version=force")
-	} else {
-		cmd = exec.Command(exePath)
+// runIpPullerCommand runs the IP Puller next to the GUI and returns its output
+func runIpPullerCommand(args ...string) (string, error) {
+	exePath, err := filepath.Abs(filepath.Join(filepath.Dir(os.Args[0]), "ip-puller.exe"))
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
+	cmd := exec.Command(exePath, args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		HideWindow: true,
 	}
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to execute IP Puller: %v - output: %s", err, string(output))
+		return "", fmt.Errorf("failed to execute IP Puller: %v - output: %s", err, string(output))
 	}
+	return string(output), nil
+}
 
-	return nil
+// pinnedLine returns the line of IP Puller output saying an update was skipped because
+// of a rollback, or ""
+func pinnedLine(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "IP lists are pinned") {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// showIPListsDialog lists the stored IP list versions, so a bad update can be rolled
+// back and the rollback pin removed again
+func (g *OwVpnGui) showIPListsDialog() {
+	go func() {
+		output, err := runIpPullerCommand("-list-snapshots", "-format", "json")
+		var snapshots []IPSnapshot
+		if err == nil {
+			err = json.Unmarshal([]byte(output), &snapshots)
+		}
+
+		fyne.Do(func() {
+			if err != nil {
+				g.logError(fmt.Sprintf("Error listing IP list versions: %v", err))
+				dialog.ShowError(fmt.Errorf("could not list IP list versions: %v", err), g.window)
+				return
+			}
+			g.showIPListsDialogWith(snapshots)
+		})
+	}()
+}
+
+func (g *OwVpnGui) showIPListsDialogWith(snapshots []IPSnapshot) {
+	var pinned *IPSnapshot
+	options := make([]string, 0, len(snapshots))
+	ids := make(map[string]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		option := fmt.Sprintf("%s  (%s, %s)", snapshot.Version, snapshot.CreatedAt.Local().Format("2006-01-02 15:04"), snapshot.Source)
+		if snapshot.Pinned {
+			option += "  pinned"
+			pinned = &snapshots[i]
+		}
+		options = append(options, option)
+		ids[option] = snapshot.ID
+	}
+
+	status := fmt.Sprintf("Updates from %s replace the IP lists when a new version is published.", g.ipSourceName())
+	if pinned != nil {
+		status = fmt.Sprintf("Pinned to version %s by a rollback, updates are skipped until unpinned.", pinned.Version)
+	}
+	if len(snapshots) == 0 {
+		status += "\nNo earlier versions are stored yet."
+	}
+
+	var ipDialog dialog.Dialog
+	versionSelect := widget.NewSelect(options, nil)
+	rollbackBtn := widget.NewButtonWithIcon("Roll back and pin", theme.HistoryIcon(), func() {
+		id, ok := ids[versionSelect.Selected]
+		if !ok {
+			return
+		}
+		ipDialog.Hide()
+		g.changeIPLists(fmt.Sprintf("Rolling back IP lists to %s...", versionSelect.Selected), "-rollback", id)
+	})
+	rollbackBtn.Disable()
+	versionSelect.OnChanged = func(string) { rollbackBtn.Enable() }
+
+	unpinBtn := widget.NewButtonWithIcon("Unpin and update", theme.ViewRefreshIcon(), func() {
+		ipDialog.Hide()
+		g.changeIPLists("Removing the rollback pin and updating the IP lists...", g.ipPullerArgs("-unpin")...)
+	})
+	if pinned == nil {
+		unpinBtn.Disable()
+	}
+
+	content := container.NewVBox(
+		widget.NewLabel(status),
+		versionSelect,
+		container.NewHBox(layout.NewSpacer(), rollbackBtn, unpinBtn),
+	)
+	ipDialog = dialog.NewCustom("IP List Versions", "Close", content, g.window)
+	ipDialog.Show()
+}
+
+// changeIPLists runs the IP Puller to replace the IP lists, then re-applies the blocked
+// regions so their rules use the new lists
+func (g *OwVpnGui) changeIPLists(message string, args ...string) {
+	g.logImportant(message)
+	go func() {
+		output, err := runIpPullerCommand(args...)
+		fyne.Do(func() {
+			if err != nil {
+				g.logError(err.Error())
+				return
+			}
+			for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+				g.logInfo(line)
+			}
+			g.logImportant("IP lists changed")
+
+			g.updateAvailableRegions()
+			for _, region := range regions {
+				if g.blocked[region] {
+					g.blockRegion(region)
+				}
+			}
+		})
+	}()
+}
+
+// ipPullerArgs adds the IP source from the config to the IP Puller arguments
+func (g *OwVpnGui) ipPullerArgs(args ...string) []string {
+	if g.config.IPSource != "" {
+		args = append(args, "-source="+g.config.IPSource)
+	}
+	if g.config.IPSourceLocation != "" {
+		args = append(args, "-source-location="+g.config.IPSourceLocation)
+	}
+	return args
+}
+
+// ipSourceName describes the configured IP source for the log
+func (g *OwVpnGui) ipSourceName() string {
+	switch g.config.IPSource {
+	case "", "github":
+		return "GitHub"
+	default:
+		return fmt.Sprintf("%s %s", g.config.IPSource, g.config.IPSourceLocation)
+	}
 }
 
 func (g *OwVpnGui) startFirewallDaemon() error {
@@ -660,7 +1018,7 @@ func (g *OwVpnGui) startFirewallDaemon() error {
 	}
 
 	g.logInfo("Starting firewall daemon process...")
-	g.firewallCmd = exec.Command(exePath, "daemon")
+	g.firewallCmd = exec.Command(exePath, "-config-dir", g.store.Dir(), "daemon")
 
 	g.firewallCmd.SysProcAttr = &syscall.SysProcAttr{
 		HideWindow:    true,
@@ -693,7 +1051,9 @@ func (g *OwVpnGui) startFirewallDaemon() error {
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
 			text := scanner.Text()
-			g.processFirewallOutput(text)
+			fyne.Do(func() {
+				g.processFirewallOutput(text)
+			})
 		}
 	}()
 
@@ -715,10 +1075,49 @@ func (g *OwVpnGui) startFirewallDaemon() error {
 	}
 
 	g.logInfo("Successfully established communication with firewall daemon")
+
+	// The sidecar applies its on-client-lost policy when heartbeats stop arriving
+	go func() {
+		for {
+			if err := g.sendCommand("heartbeat"); err != nil {
+				g.logInfo(fmt.Sprintf("Heartbeat stopped: %v", err))
+				return
+			}
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
 	return nil
 }
 
 func (g *OwVpnGui) processFirewallOutput(text string) {
+	if eventData, ok := strings.CutPrefix(text, "EVENT "); ok {
+		var event SidecarEvent
+		if err := json.Unmarshal([]byte(eventData), &event); err != nil {
+			return
+		}
+		switch event.Type {
+		case "state-changed":
+			if event.Source != "stdin" {
+				g.logImportant(fmt.Sprintf("Firewall changed by another client (%s %s)", event.Action, event.Region))
+			}
+			g.applySidecarStatus(event.Status)
+		case "game-started":
+			g.handleProcessEvent(procwatch.Event{Type: procwatch.Started, Process: event.Process, Time: event.Time})
+		case "game-exited":
+			g.handleProcessEvent(procwatch.Event{Type: procwatch.Exited, Process: event.Process, Time: event.Time})
+		}
+		return
+	}
+
+	if strings.HasPrefix(text, "{") {
+		var status SidecarStatus
+		if err := json.Unmarshal([]byte(text), &status); err == nil {
+			g.applySidecarStatus(status)
+			return
+		}
+	}
+
 	if strings.Contains(text, "ERROR:") {
 		g.logError(text)
 	} else if strings.Contains(text, "Successfully") {
@@ -749,6 +1148,14 @@ func (g *OwVpnGui) processFirewallOutput(text string) {
 		g.saveConfig()
 	}
 
+	if strings.Contains(text, "Failed to set Overwatch path:") {
+		g.pathConfigured = false
+		g.overwatchPath = ""
+		g.disableRegionButtons()
+		dialog.ShowError(fmt.Errorf("the detected executable was refused: %s",
+			strings.TrimPrefix(text, "ERROR: Failed to set Overwatch path: ")), g.window)
+	}
+
 	if strings.Contains(text, "Successfully blocked") {
 		g.setStatus("Ready", theme.ConfirmIcon())
 	}
@@ -770,6 +1177,57 @@ func (g *OwVpnGui) processFirewallOutput(text string) {
 	}
 }
 
+func (g *OwVpnGui) applySidecarStatus(status SidecarStatus) {
+	if !status.PathConfigured {
+		g.pathConfigured = false
+		g.disableRegionButtons()
+		g.setStatus("Overwatch not detected, will detect when launched", theme.WarningIcon())
+		return
+	}
+
+	if !g.pathConfigured && len(status.Executables) > 0 {
+		g.pathConfigured = true
+		g.overwatchPath = status.Executables[0]
+		g.logImportant(fmt.Sprintf("Using Overwatch path: %s", g.overwatchPath))
+		g.enableRegionButtons()
+		g.saveConfig()
+	}
+
+	if status.Persistent != g.config.PersistentBlocks {
+		g.config.PersistentBlocks = status.Persistent
+		if status.Persistent {
+			g.logImportant("Persistent blocks enabled: blocks stay active after closing and are restored on next start")
+		} else {
+			g.logImportant("Persistent blocks disabled: blocks are removed when closing")
+		}
+		if g.persistCheck != nil {
+			g.persistCheck.SetChecked(status.Persistent)
+		}
+	}
+
+	sidecarBlocked := make(map[string]bool)
+	for _, region := range status.Regions {
+		sidecarBlocked[region.Region] = true
+	}
+
+	for region := range g.regionButtons {
+		if g.blocked[region] == sidecarBlocked[region] {
+			continue
+		}
+
+		g.blocked[region] = sidecarBlocked[region]
+		if sidecarBlocked[region] {
+			g.logImportant(fmt.Sprintf("Firewall reports region %s as blocked", region))
+			// Another client already blocked it, nothing left to wait for
+			delete(g.pending, region)
+		} else {
+			g.logImportant(fmt.Sprintf("Firewall reports region %s as unblocked", region))
+		}
+		g.refreshRegionButton(region)
+	}
+	g.updatePendingControls()
+}
+
 func (g *OwVpnGui) toggleRegion(region string) {
 	if !g.pathConfigured {
 		g.logImportant("Overwatch path not configured. Overwatch will be detected automatically when launched.")
@@ -777,57 +1235,64 @@ func (g *OwVpnGui) toggleRegion(region string) {
 		return
 	}
 
-	isBlocked := g.blocked[region]
+	if g.pending[region] {
+		g.cancelPending(region)
+		return
+	}
 
-	if isBlocked {
+	if g.blocked[region] {
 		g.logImportant(fmt.Sprintf("Unblocking region %s...", region))
 		if err := g.sendCommand(fmt.Sprintf("unblock|%s", region)); err != nil {
 			g.logError(fmt.Sprintf("Error unblocking region %s: %v", region, err))
 			return
 		}
 		g.blocked[region] = false
+		g.refreshRegionButton(region)
+		return
+	}
 
-		g.regionButtons[region].Importance = widget.SuccessImportance
-		g.regionButtons[region].SetText(region)
-		g.regionButtons[region].SetIcon(theme.ContentRemoveIcon())
+	g.processMutex.Lock()
+	isRunning := g.isOverwatchRunning
+	g.processMutex.Unlock()
 
-		if g.isOverwatchRunning {
-			g.regionButtons[region].Disable()
-		}
+	if isRunning {
+		g.queueBlock(region)
+		return
+	}
 
-		g.window.Canvas().Refresh(g.regionButtons[region])
-	} else {
-		g.processMutex.Lock()
-		isRunning := g.isOverwatchRunning
-		g.processMutex.Unlock()
-
-		if isRunning {
-			g.logImportant("Cannot block region while Overwatch is running. Please close Overwatch first.")
-			content := container.NewVBox(
-				widget.NewLabel("Overwatch is currently running."),
-				widget.NewLabel("Please close Overwatch before blocking regions."),
-			)
-			dialog.ShowCustom("Overwatch Running", "OK", content, g.window)
-			return
-		}
+	g.blockRegion(region)
+}
 
-		g.logImportant(fmt.Sprintf("Blocking region %s...", region))
-		ipDir := g.getIPDirectory()
-		if err := g.sendCommand(fmt.Sprintf("block|%s|%s", region, ipDir)); err != nil {
-			g.logError(fmt.Sprintf("Error blocking region %s: %v", region, err))
-			return
-		}
-		g.blocked[region] = true
+func (g *OwVpnGui) blockRegion(region string) {
+	g.logImportant(fmt.Sprintf("Blocking region %s...", region))
+	ipDir := g.getIPDirectory()
+	if err := g.sendCommand(fmt.Sprintf("block|%s|%s", region, ipDir)); err != nil {
+		g.logError(fmt.Sprintf("Error blocking region %s: %v", region, err))
+		g.refreshRegionButton(region)
+		return
+	}
+	g.blocked[region] = true
+	g.refreshRegionButton(region)
+}
 
-		g.regionButtons[region].Importance = widget.DangerImportance
-		g.regionButtons[region].SetText(region)
-		g.regionButtons[region].SetIcon(theme.ContentAddIcon())
+func (g *OwVpnGui) setPersistentBlocks(enabled bool) {
+	if enabled == g.config.PersistentBlocks {
+		return
+	}
 
-		g.window.Canvas().Refresh(g.regionButtons[region])
+	setting := "off"
+	if enabled {
+		setting = "on"
+	}
+	if err := g.sendCommand("set-persist|" + setting); err != nil {
+		g.logError(fmt.Sprintf("Error changing persistent blocks: %v", err))
+		g.persistCheck.SetChecked(g.config.PersistentBlocks)
 	}
 }
 
 func (g *OwVpnGui) unblockAll() {
+	g.cancelPending()
+
 	g.logImportant("Unblocking all regions...")
 	if err := g.sendCommand("unblock-all"); err != nil {
 		g.logError(fmt.Sprintf("Error unblocking all regions: %v", err))
@@ -837,13 +1302,8 @@ func (g *OwVpnGui) unblockAll() {
 	for region := range g.blocked {
 		g.blocked[region] = false
 		if g.regionButtons[region] != nil {
-			g.regionButtons[region].Importance = widget.SuccessImportance
-			g.regionButtons[region].SetText(region)
-			g.regionButtons[region].SetIcon(theme.ContentRemoveIcon())
-
-			if g.isOverwatchRunning {
-				g.regionButtons[region].Disable()
-			} else if g.pathConfigured {
+			g.refreshRegionButton(region)
+			if g.pathConfigured {
 				g.regionButtons[region].Enable()
 			}
 		}
@@ -898,13 +1358,17 @@ func (g *OwVpnGui) cleanup() {
 	g.window.Hide()
 
 	if g.cmdStdin != nil {
-		g.logInfo("Sending cleanup command to firewall daemon...")
-
-		if err := g.sendCommand("unblock-all"); err != nil {
-			g.logError(fmt.Sprintf("Warning: Error sending unblock-all command: %v", err))
+		if g.config.PersistentBlocks {
+			g.logInfo("Persistent blocks enabled, leaving firewall rules in place")
 		} else {
-			g.logInfo("Waiting for cleanup to complete...")
-			time.Sleep(1 * time.Second)
+			g.logInfo("Sending cleanup command to firewall daemon...")
+
+			if err := g.sendCommand("unblock-all"); err != nil {
+				g.logError(fmt.Sprintf("Warning: Error sending unblock-all command: %v", err))
+			} else {
+				g.logInfo("Waiting for cleanup to complete...")
+				time.Sleep(1 * time.Second)
+			}
 		}
 
 		if err := g.sendCommand("exit"); err != nil {
@@ -944,8 +1408,8 @@ func (g *OwVpnGui) resetConfig() {
 		widget.NewLabelWithStyle("Reset Configuration", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
 		widget.NewLabel(""),
 		widget.NewLabel("This will:"),
-		widget.NewLabel("• Delete the saved configuration file"),
-		widget.NewLabel("• Clear the detected Overwatch path"),
+		widget.NewLabel("• Clear the saved Overwatch settings"),
+		widget.NewLabel("• Clear the detected Overwatch paths (Battle.net and Steam)"),
 		widget.NewLabel("• Force re-detection of Overwatch when launched"),
 		widget.NewLabel(""),
 		widget.NewLabel("Use this if:"),
@@ -962,13 +1426,14 @@ func (g *OwVpnGui) resetConfig() {
 		func(reset bool) {
 			if reset {
 				g.logImportant("Resetting configuration...")
-				if err := os.Remove(g.configPath); err != nil && !os.IsNotExist(err) {
-					g.logError(fmt.Sprintf("Error deleting config file: %v", err))
-					return
-				}
+				// The rule namespace and persistence are kept so existing rules can still be managed
 				g.pathConfigured = false
 				g.overwatchPath = ""
 				g.initialSetupDone = false
+				g.saveConfig()
+				if err := g.sendCommand("clear-path"); err != nil {
+					g.logError(fmt.Sprintf("Error clearing Overwatch paths: %v", err))
+				}
 				g.logImportant("Configuration reset successful")
 				g.setStatus("Waiting for Overwatch to launch", theme.WarningIcon())
 				g.disableRegionButtons()
@@ -0,0 +1,364 @@
+// Package configstore is the settings store shared by the GUI and the firewall sidecar.
+// Both processes read and update the same config file in a per-user or per-machine data
+// directory, serialized through a lock file and written atomically. Every installation
+// has its own directory in there, so a portable and an installed copy never share a
+// rule namespace.
+package configstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// FileName is the name of the config file inside the store directory
+	FileName = "config.json"
+
+	// AppDirName is the directory created inside the user or machine data directory
+	AppDirName = "OverwatchVPN"
+
+	// EnvDir overrides the store directory
+	EnvDir = "OW_VPN_CONFIG_DIR"
+
+	// EnvScope selects the default directory when no directory is given: user or machine
+	EnvScope = "OW_VPN_CONFIG_SCOPE"
+
+	ScopeUser    = "user"
+	ScopeMachine = "machine"
+)
+
+// ErrUnchanged can be returned by an Update function to skip writing the config
+var ErrUnchanged = errors.New("config unchanged")
+
+// Config holds the settings shared by the GUI and the sidecar
+type Config struct {
+	SchemaVersion    int    `json:"schemaVersion"`
+	UseGithubSource  bool   `json:"useGithubSource"`
+	InitialSetupDone bool   `json:"initialSetupDone"`
+	PersistentBlocks bool   `json:"persistentBlocks"`
+	Namespace        string `json:"namespace,omitempty"`
+
+	// Targets are the game executables rules are created for, at most one per edition
+	Targets []Target `json:"targets"`
+
+	// ExecutableAllowlist optionally restricts the game executable to these SHA-256 hashes
+	ExecutableAllowlist []string `json:"executableAllowlist,omitempty"`
+
+	// IPSource selects where the IP puller gets the lists: github (the default), mirror,
+	// dir or archive. IPSourceLocation is the URL or path it reads from.
+	IPSource         string `json:"ipSource,omitempty"`
+	IPSourceLocation string `json:"ipSourceLocation,omitempty"`
+}
+
+// Store reads and updates the config file in one directory
+type Store struct {
+	dir string
+}
+
+// DefaultDir returns the data directory of this installation for a scope. The user scope
+// lives in the user's config directory, the machine scope in ProgramData on Windows and
+// /var/lib elsewhere; in both the installation gets a directory named by InstallationID.
+func DefaultDir(scope string) (string, error) {
+	dir, err := sharedDir(scope)
+	if err != nil {
+		return "", err
+	}
+	if id := InstallationID(); id != "" {
+		dir = filepath.Join(dir, id)
+	}
+	return dir, nil
+}
+
+// sharedDir returns the data directory for a scope that holds the directories of every
+// installation, and that every installation used before each got its own
+func sharedDir(scope string) (string, error) {
+	switch scope {
+	case "", ScopeUser:
+		base, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("finding user config directory: %w", err)
+		}
+		return filepath.Join(base, AppDirName), nil
+
+	case ScopeMachine:
+		if runtime.GOOS == "windows" {
+			base := os.Getenv("ProgramData")
+			if base == "" {
+				base = `C:\ProgramData`
+			}
+			return filepath.Join(base, AppDirName), nil
+		}
+		return filepath.Join("/var/lib", AppDirName), nil
+
+	default:
+		return "", fmt.Errorf("unknown config scope '%s'", scope)
+	}
+}
+
+// InstallationID identifies the installation by the directory of the running
+// executable, which the GUI, the sidecar and the IP puller share. It is empty when the
+// executable cannot be found.
+func InstallationID() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	dir := filepath.Clean(filepath.Dir(exe))
+	if runtime.GOOS == "windows" {
+		dir = strings.ToLower(dir)
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:6])
+}
+
+// ResolveDir picks the store directory: dir if given, then $OW_VPN_CONFIG_DIR, then the
+// default directory for scope or $OW_VPN_CONFIG_SCOPE
+func ResolveDir(dir, scope string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv(EnvDir)
+	}
+	if dir != "" {
+		return filepath.Abs(dir)
+	}
+
+	if scope == "" {
+		scope = os.Getenv(EnvScope)
+	}
+	return DefaultDir(scope)
+}
+
+// Open creates the store directory if needed and returns a store for it
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating config directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the store directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Path returns the path of a state file kept next to the config. Absolute paths are returned unchanged.
+func (s *Store) Path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
+
+// Load returns the stored config upgraded to the current schema. A missing file yields
+// the default config. The file itself is only upgraded by Migrate or Update.
+func (s *Store) Load() (Config, error) {
+	var cfg Config
+
+	err := s.withLock(false, func() error {
+		loaded, err := s.load()
+		cfg = loaded.cfg
+		return err
+	})
+	return cfg, err
+}
+
+// Update applies fn to the stored config and writes the result, holding the lock so
+// changes from the other process are not lost. An outdated file is backed up and
+// migrated first.
+func (s *Store) Update(fn func(cfg *Config) error) (Config, error) {
+	var cfg Config
+
+	err := s.withLock(true, func() error {
+		loaded, err := s.load()
+		if err != nil {
+			return err
+		}
+
+		err = fn(&loaded.cfg)
+		cfg = loaded.cfg
+		if errors.Is(err, ErrUnchanged) {
+			if loaded.from == CurrentSchemaVersion {
+				return nil
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		_, err = s.save(loaded)
+		return err
+	})
+	return cfg, err
+}
+
+// ImportLegacy copies a config file from the old cwd-relative location into the store
+// when the store has no config yet. It reports whether a file was imported.
+func (s *Store) ImportLegacy(path string) (bool, error) {
+	imported := false
+
+	err := s.withLock(true, func() error {
+		if _, err := os.Stat(s.Path(FileName)); err == nil || !errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading legacy config: %w", err)
+		}
+
+		if _, _, err := decode(data); err != nil {
+			return fmt.Errorf("legacy config: %w", err)
+		}
+
+		if err := WriteFileAtomic(s.Path(FileName), data, 0644); err != nil {
+			return err
+		}
+		imported = true
+		return nil
+	})
+	return imported, err
+}
+
+// ImportShared moves the config and state files that every installation shared before
+// each got its own directory into the store, when the store is an installation
+// directory without a config yet. Only the first installation to start takes them over,
+// with the rule namespace and saved blocks; the others start afresh. It reports whether
+// files were moved.
+func (s *Store) ImportShared() (bool, error) {
+	if filepath.Base(s.dir) != InstallationID() {
+		return false, nil
+	}
+	shared := &Store{dir: filepath.Dir(s.dir)}
+	imported := false
+
+	err := s.withLock(true, func() error {
+		if _, err := os.Stat(s.Path(FileName)); err == nil || !errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		// The shared lock keeps two installations from both taking the files
+		return shared.withLock(true, func() error {
+			if _, err := os.Stat(shared.Path(FileName)); err != nil {
+				return nil
+			}
+
+			entries, err := os.ReadDir(shared.dir)
+			if err != nil {
+				return fmt.Errorf("reading shared config directory: %w", err)
+			}
+			// config.json goes last, so an interrupted move is finished by the next start
+			names := []string{}
+			for _, entry := range entries {
+				name := entry.Name()
+				if entry.Type().IsRegular() && name != FileName && name != FileName+".lock" {
+					names = append(names, name)
+				}
+			}
+			names = append(names, FileName)
+
+			for _, name := range names {
+				if err := os.Rename(shared.Path(name), s.Path(name)); err != nil {
+					return fmt.Errorf("moving shared %s: %w", name, err)
+				}
+			}
+			imported = true
+			return nil
+		})
+	})
+	return imported, err
+}
+
+func (s *Store) load() (loadedConfig, error) {
+	data, err := os.ReadFile(s.Path(FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return loadedConfig{cfg: Default(), from: CurrentSchemaVersion}, nil
+	}
+	if err != nil {
+		return loadedConfig{}, fmt.Errorf("reading config: %w", err)
+	}
+
+	cfg, from, err := decode(data)
+	if err != nil {
+		return loadedConfig{}, err
+	}
+	return loadedConfig{cfg: cfg, raw: data, from: from}, nil
+}
+
+// save writes the config, backing up the file first when it was written with an older
+// schema, and returns the path of the backup if one was made
+func (s *Store) save(loaded loadedConfig) (string, error) {
+	var backup string
+	if loaded.raw != nil && loaded.from != CurrentSchemaVersion {
+		var err error
+		if backup, err = s.backup(loaded.raw, loaded.from); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := json.MarshalIndent(loaded.cfg, "", "  ")
+	if err != nil {
+		return backup, fmt.Errorf("encoding config: %w", err)
+	}
+	return backup, WriteFileAtomic(s.Path(FileName), data, 0644)
+}
+
+func (s *Store) withLock(exclusive bool, fn func() error) error {
+	lockFile, err := os.OpenFile(s.Path(FileName+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("opening config lock: %w", err)
+	}
+	defer lockFile.Close()
+
+	if err := lockFileHandle(lockFile, exclusive); err != nil {
+		return fmt.Errorf("locking config: %w", err)
+	}
+	defer unlockFileHandle(lockFile)
+
+	return fn()
+}
+
+// WriteFileAtomic writes data to a temporary file in the same directory and renames it
+// over path, so readers never see a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("setting file mode: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("replacing %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
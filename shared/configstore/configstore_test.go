@@ -0,0 +1,216 @@
+package configstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultDirIsPerInstallation(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("AppData", os.Getenv("XDG_CONFIG_HOME"))
+
+	dir, err := DefaultDir(ScopeUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := InstallationID()
+	if id == "" || filepath.Base(dir) != id || filepath.Base(filepath.Dir(dir)) != AppDirName {
+		t.Errorf("DefaultDir = %s, want %s/<%s>", dir, AppDirName, id)
+	}
+}
+
+func TestImportSharedMovesFilesToTheFirstInstallation(t *testing.T) {
+	shared := t.TempDir()
+	writeFile(t, filepath.Join(shared, FileName), `{"schemaVersion": 3, "namespace": "1a2b3c4d", "targets": []}`)
+	writeFile(t, filepath.Join(shared, "blocks.json"), `{"regions": []}`)
+
+	first, err := Open(filepath.Join(shared, InstallationID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := first.ImportShared()
+	if err != nil || !imported {
+		t.Fatalf("ImportShared = %v, %v", imported, err)
+	}
+
+	cfg, err := first.Load()
+	if err != nil || cfg.Namespace != "1a2b3c4d" {
+		t.Errorf("namespace after import = %q, %v", cfg.Namespace, err)
+	}
+	for _, name := range []string{FileName, "blocks.json"} {
+		if _, err := os.Stat(filepath.Join(shared, name)); !os.IsNotExist(err) {
+			t.Errorf("%s left in the shared directory: %v", name, err)
+		}
+		if _, err := os.Stat(first.Path(name)); err != nil {
+			t.Errorf("%s not moved: %v", name, err)
+		}
+	}
+
+	// The files are gone, so nothing is left for a second start or installation
+	if imported, err := first.ImportShared(); err != nil || imported {
+		t.Errorf("second ImportShared = %v, %v", imported, err)
+	}
+}
+
+func TestImportSharedIgnoresOtherDirectories(t *testing.T) {
+	shared := t.TempDir()
+	writeFile(t, filepath.Join(shared, FileName), `{"schemaVersion": 3, "namespace": "1a2b3c4d", "targets": []}`)
+
+	// An explicit -config-dir is not an installation directory
+	store, err := Open(filepath.Join(shared, "profile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := store.ImportShared(); err != nil || imported {
+		t.Errorf("ImportShared = %v, %v, want nothing imported", imported, err)
+	}
+	if _, err := os.Stat(filepath.Join(shared, FileName)); err != nil {
+		t.Errorf("shared config moved: %v", err)
+	}
+}
+
+func TestConcurrentUpdatesKeepEveryChange(t *testing.T) {
+	dir := t.TempDir()
+
+	// Separate stores, like the GUI and the sidecar, each adding one entry
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store, err := Open(dir)
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, err = store.Update(func(cfg *Config) error {
+				cfg.ExecutableAllowlist = append(cfg.ExecutableAllowlist, fmt.Sprintf("%064x", i))
+				return nil
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, _ := Open(dir)
+	cfg, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := append([]string(nil), cfg.ExecutableAllowlist...)
+	sort.Strings(hashes)
+	if len(hashes) != writers {
+		t.Fatalf("allowlist has %d entries, want %d: %v", len(hashes), writers, hashes)
+	}
+	for i, hash := range hashes {
+		if want := fmt.Sprintf("%064x", i); hash != want {
+			t.Errorf("allowlist[%d] = %s, want %s", i, hash, want)
+		}
+	}
+}
+
+func TestImportLegacy(t *testing.T) {
+	legacy := filepath.Join(t.TempDir(), FileName)
+	writeFile(t, legacy, `{"overwatchPath": "C:\\Games\\Overwatch\\Overwatch.exe", "initialSetupDone": true, "namespace": "1a2b3c4d"}`)
+
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := store.ImportLegacy(legacy)
+	if err != nil || !imported {
+		t.Fatalf("ImportLegacy = %v, %v", imported, err)
+	}
+
+	// The file is copied as it was and upgraded by the next Migrate
+	cfg, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Namespace != "1a2b3c4d" || !cfg.InitialSetupDone || len(cfg.Targets) != 1 || cfg.Targets[0].Path != `C:\Games\Overwatch\Overwatch.exe` {
+		t.Errorf("imported config = %+v", cfg)
+	}
+
+	// A store with a config keeps it
+	writeFile(t, legacy, `{"namespace": "ffffffff"}`)
+	if imported, err := store.ImportLegacy(legacy); err != nil || imported {
+		t.Errorf("second ImportLegacy = %v, %v, want the existing config kept", imported, err)
+	}
+	if cfg, _ := store.Load(); cfg.Namespace != "1a2b3c4d" {
+		t.Errorf("namespace = %s, want 1a2b3c4d", cfg.Namespace)
+	}
+}
+
+func TestImportLegacyRejectsInvalidConfig(t *testing.T) {
+	legacy := filepath.Join(t.TempDir(), FileName)
+	writeFile(t, legacy, `{"namespace": "not/valid"}`)
+
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := store.ImportLegacy(legacy); err == nil || imported {
+		t.Errorf("ImportLegacy = %v, %v, want an error", imported, err)
+	}
+	if _, err := os.Stat(store.Path(FileName)); !os.IsNotExist(err) {
+		t.Errorf("invalid legacy config written: %v", err)
+	}
+}
+
+func TestWriteFileAtomicLeavesNoTempFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	writeFile(t, path, "old")
+
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new" {
+		t.Errorf("content = %q, %v, want new", data, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestWriteFileAtomicFailureKeepsTheOldFile(t *testing.T) {
+	dir := t.TempDir()
+	// A directory in the way makes the final rename fail
+	path := filepath.Join(dir, "taken")
+	if err := os.MkdirAll(filepath.Join(path, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new"), 0644); err == nil {
+		t.Fatal("WriteFileAtomic over a directory succeeded")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the directory in the way", len(entries))
+	}
+}
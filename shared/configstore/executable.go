@@ -0,0 +1,88 @@
+package configstore
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// Editions of the game a target can belong to
+const (
+	EditionBattleNet = "battlenet"
+	EditionSteam     = "steam"
+)
+
+// EditionOf returns the edition a game executable belongs to, going by whether it is
+// installed in a Steam library
+func EditionOf(path string) string {
+	if strings.Contains(strings.ToLower(strings.ReplaceAll(path, "/", `\`)), `\steamapps\`) {
+		return EditionSteam
+	}
+	return EditionBattleNet
+}
+
+// Target is a game executable of one edition, with the metadata recorded when it was verified
+type Target struct {
+	Edition string          `json:"edition" yaml:"edition"`
+	Path    string          `json:"path" yaml:"path"`
+	Info    *ExecutableInfo `json:"info,omitempty" yaml:"info,omitempty"`
+}
+
+// ExecutableInfo is the metadata recorded when a game executable passes verification
+type ExecutableInfo struct {
+	Path           string    `json:"path" yaml:"path"`
+	ProductName    string    `json:"productName,omitempty" yaml:"productName,omitempty"`
+	CompanyName    string    `json:"companyName,omitempty" yaml:"companyName,omitempty"`
+	FileVersion    string    `json:"fileVersion,omitempty" yaml:"fileVersion,omitempty"`
+	ProductVersion string    `json:"productVersion,omitempty" yaml:"productVersion,omitempty"`
+	SHA256         string    `json:"sha256" yaml:"sha256"`
+	Size           int64     `json:"size" yaml:"size"`
+	ModTime        time.Time `json:"modTime" yaml:"modTime"`
+	VerifiedAt     time.Time `json:"verifiedAt" yaml:"verifiedAt"`
+	Warnings       []string  `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+}
+
+// Unchanged reports whether the file at Path still has the size and modification time it
+// had when it was verified, so it need not be hashed again
+func (e *ExecutableInfo) Unchanged() bool {
+	if e == nil {
+		return false
+	}
+	info, err := os.Stat(e.Path)
+	if err != nil {
+		return false
+	}
+	return info.Size() == e.Size && info.ModTime().Equal(e.ModTime)
+}
+
+// Target returns the target of an edition
+func (c Config) Target(edition string) (Target, bool) {
+	for _, target := range c.Targets {
+		if target.Edition == edition {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+// SetTarget adds a target or replaces the one of the same edition
+func (c *Config) SetTarget(target Target) {
+	for i := range c.Targets {
+		if c.Targets[i].Edition == target.Edition {
+			c.Targets[i] = target
+			return
+		}
+	}
+	c.Targets = append(c.Targets, target)
+}
+
+// RemoveTargets removes the target of an edition, or every target when edition is empty
+func (c *Config) RemoveTargets(edition string) {
+	kept := []Target{}
+	for _, target := range c.Targets {
+		if edition != "" && target.Edition != edition {
+			kept = append(kept, target)
+		}
+	}
+	c.Targets = kept
+}
@@ -0,0 +1,17 @@
+package configstore
+
+import "testing"
+
+func TestEditionOf(t *testing.T) {
+	tests := map[string]string{
+		`E:\SteamLibrary\steamapps\common\Overwatch\Overwatch.exe`:              EditionSteam,
+		`C:/Program Files (x86)/Steam/SteamApps/common/Overwatch/Overwatch.exe`: EditionSteam,
+		`D:\Games\Overwatch\_retail_\Overwatch.exe`:                             EditionBattleNet,
+		`D:\steamapps-backup\Overwatch.exe`:                                     EditionBattleNet,
+	}
+	for path, want := range tests {
+		if got := EditionOf(path); got != want {
+			t.Errorf("EditionOf(%s) = %s, want %s", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package configstore
+
+import "os"
+
+// Platforms without file locking rely on the atomic rename alone
+func lockFileHandle(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFileHandle(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package configstore
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFileHandle(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(f.Fd()), how)
+}
+
+func unlockFileHandle(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
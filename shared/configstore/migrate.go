@@ -0,0 +1,230 @@
+package configstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CurrentSchemaVersion is the config schema written by this version.
+// Schema 1 is the unversioned config written before versioning was introduced.
+const CurrentSchemaVersion = 3
+
+// migration upgrades the raw config from schema `from` to `from+1`. Migrations work on
+// the decoded JSON object so they do not depend on the current Config struct.
+type migration struct {
+	from        int
+	description string
+	apply       func(raw map[string]interface{}) error
+}
+
+// migrations must stay ordered by from, one per schema version
+var migrations = []migration{
+	{
+		from:        1,
+		description: "the GUI always fetched IP lists from GitHub whatever useGithubSource said; store that as the setting",
+		apply: func(raw map[string]interface{}) error {
+			raw["useGithubSource"] = true
+			return nil
+		},
+	},
+	{
+		from:        2,
+		description: "the single overwatchPath and overwatchInfo become a target entry, so Battle.net and Steam installs can be kept side by side",
+		apply: func(raw map[string]interface{}) error {
+			targets := []interface{}{}
+			if path, _ := raw["overwatchPath"].(string); path != "" {
+				target := map[string]interface{}{"edition": EditionOf(path), "path": path}
+				if info, ok := raw["overwatchInfo"]; ok && info != nil {
+					target["info"] = info
+				}
+				targets = append(targets, target)
+			}
+
+			raw["targets"] = targets
+			delete(raw, "overwatchPath")
+			delete(raw, "overwatchInfo")
+			return nil
+		},
+	},
+}
+
+// MigrationResult describes an upgrade of the config file
+type MigrationResult struct {
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Backup string `json:"backup,omitempty"`
+}
+
+// Migrated reports whether the config file was upgraded
+func (r MigrationResult) Migrated() bool {
+	return r.From != r.To
+}
+
+// ValidationError lists everything wrong with a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// namespacePattern excludes '-' so one namespace can never be a prefix of another's rule names
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9]{1,16}$`)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+
+// ValidateNamespace checks that a rule namespace can be embedded in firewall rule names
+func ValidateNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return fmt.Errorf("invalid namespace '%s': use 1-16 letters or digits", namespace)
+	}
+	return nil
+}
+
+// Default returns the config used when no config file exists yet
+func Default() Config {
+	return Config{
+		SchemaVersion:   CurrentSchemaVersion,
+		UseGithubSource: true,
+		Targets:         []Target{},
+	}
+}
+
+// Validate checks a config against the current schema
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.SchemaVersion != CurrentSchemaVersion {
+		problems = append(problems, fmt.Sprintf("schemaVersion is %d, expected %d", c.SchemaVersion, CurrentSchemaVersion))
+	}
+	if c.Namespace != "" {
+		if err := ValidateNamespace(c.Namespace); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	editions := make(map[string]bool)
+	for _, target := range c.Targets {
+		switch {
+		case target.Edition != EditionBattleNet && target.Edition != EditionSteam:
+			problems = append(problems, fmt.Sprintf("target %s has unknown edition '%s'", target.Path, target.Edition))
+		case editions[target.Edition]:
+			problems = append(problems, fmt.Sprintf("more than one %s target", target.Edition))
+		case target.Path == "":
+			problems = append(problems, fmt.Sprintf("%s target has no path", target.Edition))
+		}
+		editions[target.Edition] = true
+	}
+	switch c.IPSource {
+	case "", "github":
+	case "mirror", "dir", "archive":
+		if c.IPSourceLocation == "" {
+			problems = append(problems, fmt.Sprintf("ipSource %s needs an ipSourceLocation", c.IPSource))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown ipSource '%s'", c.IPSource))
+	}
+	for _, hash := range c.ExecutableAllowlist {
+		if !sha256Pattern.MatchString(hash) {
+			problems = append(problems, fmt.Sprintf("executableAllowlist entry '%s' is not a SHA-256 hash", hash))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Migrate upgrades the config file to the current schema, keeping a backup of the
+// original next to it
+func (s *Store) Migrate() (MigrationResult, error) {
+	var result MigrationResult
+
+	err := s.withLock(true, func() error {
+		loaded, err := s.load()
+		if err != nil {
+			return err
+		}
+
+		result = MigrationResult{From: loaded.from, To: CurrentSchemaVersion}
+		if !result.Migrated() {
+			return nil
+		}
+
+		result.Backup, err = s.save(loaded)
+		return err
+	})
+	return result, err
+}
+
+// loadedConfig is a config read from disk and upgraded in memory
+type loadedConfig struct {
+	cfg  Config
+	raw  []byte
+	from int
+}
+
+// decode parses a config file, applying every migration from its schema to the current one
+func decode(data []byte) (Config, int, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Config{}, 0, fmt.Errorf("parsing config: %w", err)
+	}
+
+	from := 1
+	if version, ok := raw["schemaVersion"]; ok {
+		number, ok := version.(float64)
+		if !ok || number < 1 || number != float64(int(number)) {
+			return Config{}, 0, &ValidationError{Problems: []string{fmt.Sprintf("schemaVersion %v is not a valid version", version)}}
+		}
+		from = int(number)
+	}
+
+	if from > CurrentSchemaVersion {
+		return Config{}, from, fmt.Errorf("config schema %d was written by a newer version (this version supports up to %d)", from, CurrentSchemaVersion)
+	}
+
+	version := from
+	for _, m := range migrations {
+		if m.from != version {
+			continue
+		}
+		if err := m.apply(raw); err != nil {
+			return Config{}, from, fmt.Errorf("migrating config from schema %d: %w", m.from, err)
+		}
+		version++
+	}
+	if version != CurrentSchemaVersion {
+		return Config{}, from, fmt.Errorf("no migration from config schema %d", version)
+	}
+	raw["schemaVersion"] = CurrentSchemaVersion
+
+	upgraded, err := json.Marshal(raw)
+	if err != nil {
+		return Config{}, from, fmt.Errorf("encoding migrated config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(upgraded, &cfg); err != nil {
+		return Config{}, from, &ValidationError{Problems: []string{err.Error()}}
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, from, err
+	}
+	return cfg, from, nil
+}
+
+// backup copies the pre-migration file to config.json.v<from>-<timestamp>.bak
+func (s *Store) backup(data []byte, from int) (string, error) {
+	name := fmt.Sprintf("%s.v%d-%s.bak", FileName, from, time.Now().Format("20060102-150405"))
+	path := s.Path(name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("backing up config before migration: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,150 @@
+package configstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		from int
+		want Config
+	}{
+		{
+			name: "unversioned to current",
+			file: `{"overwatchPath": "C:\\Games\\Overwatch\\Overwatch.exe", "useGithubSource": false, "initialSetupDone": true, "namespace": "1a2b3c4d"}`,
+			from: 1,
+			want: Config{
+				SchemaVersion:    CurrentSchemaVersion,
+				UseGithubSource:  true,
+				InitialSetupDone: true,
+				Namespace:        "1a2b3c4d",
+				Targets:          []Target{{Edition: EditionBattleNet, Path: `C:\Games\Overwatch\Overwatch.exe`}},
+			},
+		},
+		{
+			name: "schema 2 Steam path to targets",
+			file: `{"schemaVersion": 2, "useGithubSource": false, "overwatchPath": "E:/SteamLibrary/steamapps/common/Overwatch/Overwatch.exe", "overwatchInfo": {"path": "E:/SteamLibrary/steamapps/common/Overwatch/Overwatch.exe", "sha256": "abc", "size": 2}}`,
+			from: 2,
+			want: Config{
+				SchemaVersion: CurrentSchemaVersion,
+				Targets: []Target{{
+					Edition: EditionSteam,
+					Path:    "E:/SteamLibrary/steamapps/common/Overwatch/Overwatch.exe",
+					Info:    &ExecutableInfo{Path: "E:/SteamLibrary/steamapps/common/Overwatch/Overwatch.exe", SHA256: "abc", Size: 2},
+				}},
+			},
+		},
+		{
+			name: "schema 2 without a path",
+			file: `{"schemaVersion": 2, "useGithubSource": true}`,
+			from: 2,
+			want: Config{SchemaVersion: CurrentSchemaVersion, UseGithubSource: true, Targets: []Target{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, store.Path(FileName), tt.file)
+
+			result, err := store.Migrate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.From != tt.from || result.To != CurrentSchemaVersion || !result.Migrated() {
+				t.Errorf("Migrate = %+v, want from %d to %d", result, tt.from, CurrentSchemaVersion)
+			}
+
+			cfg, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, tt.want) {
+				t.Errorf("migrated config = %+v, want %+v", cfg, tt.want)
+			}
+
+			// The original file is kept as config.json.v<from>-<timestamp>.bak
+			prefix := store.Path(fmt.Sprintf("%s.v%d-", FileName, tt.from))
+			if !strings.HasPrefix(result.Backup, prefix) || !strings.HasSuffix(result.Backup, ".bak") {
+				t.Errorf("backup = %s, want %s<timestamp>.bak", result.Backup, prefix)
+			}
+			backup, err := os.ReadFile(result.Backup)
+			if err != nil || string(backup) != tt.file {
+				t.Errorf("backup content = %q, %v, want the original file", backup, err)
+			}
+		})
+	}
+}
+
+func TestMigrateCurrentSchemaDoesNothing(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Update(func(cfg *Config) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := store.Migrate()
+	if err != nil || result.Migrated() || result.Backup != "" {
+		t.Errorf("Migrate = %+v, %v, want nothing to do", result, err)
+	}
+	if backups, _ := filepath.Glob(store.Path("*.bak")); len(backups) != 0 {
+		t.Errorf("backups = %v, want none", backups)
+	}
+}
+
+func TestMigrateRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		validation bool
+		message    string
+	}{
+		{name: "newer schema", file: `{"schemaVersion": 99}`, message: "written by a newer version"},
+		{name: "schemaVersion not a number", file: `{"schemaVersion": "3"}`, validation: true, message: "not a valid version"},
+		{name: "schemaVersion zero", file: `{"schemaVersion": 0}`, validation: true, message: "not a valid version"},
+		{name: "fractional schemaVersion", file: `{"schemaVersion": 2.5}`, validation: true, message: "not a valid version"},
+		{name: "invalid namespace", file: `{"schemaVersion": 3, "namespace": "a-b", "targets": []}`, validation: true, message: "invalid namespace"},
+		{name: "two targets of one edition", file: `{"schemaVersion": 3, "targets": [{"edition": "steam", "path": "a"}, {"edition": "steam", "path": "b"}]}`, validation: true, message: "more than one steam target"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, store.Path(FileName), tt.file)
+
+			_, err = store.Migrate()
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("Migrate error = %v, want %q", err, tt.message)
+			}
+			var validation *ValidationError
+			if errors.As(err, &validation) != tt.validation {
+				t.Errorf("Migrate error = %T, ValidationError %v", err, tt.validation)
+			}
+
+			// The file is left alone and no backup is made
+			data, _ := os.ReadFile(store.Path(FileName))
+			if string(data) != tt.file {
+				t.Errorf("config changed to %s", data)
+			}
+			if backups, _ := filepath.Glob(store.Path("*.bak")); len(backups) != 0 {
+				t.Errorf("backups = %v, want none", backups)
+			}
+		})
+	}
+}
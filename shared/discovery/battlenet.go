@@ -0,0 +1,198 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ProductInstall is one installed product from the Battle.net product database
+type ProductInstall struct {
+	UID         string
+	ProductCode string
+	InstallPath string
+}
+
+// BattleNetConfig holds the parts of Battle.net.config used for discovery
+type BattleNetConfig struct {
+	DefaultInstallPath string
+	Games              []string
+}
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// ParseProductDB reads the product installs from a Battle.net product.db file. The file
+// is a protobuf Database message; only product_install (1) and within it uid (1),
+// product_code (2) and settings.install_path (3.1) are read.
+func ParseProductDB(r io.Reader) ([]ProductInstall, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading product database: %w", err)
+	}
+
+	var installs []ProductInstall
+	err = walkMessage(data, func(field int, value []byte) error {
+		if field != 1 {
+			return nil
+		}
+
+		var install ProductInstall
+		err := walkMessage(value, func(field int, value []byte) error {
+			switch field {
+			case 1:
+				install.UID = string(value)
+			case 2:
+				install.ProductCode = string(value)
+			case 3:
+				return walkMessage(value, func(field int, value []byte) error {
+					if field == 1 {
+						install.InstallPath = string(value)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		installs = append(installs, install)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parsing product database: %w", err)
+	}
+	return installs, nil
+}
+
+// walkMessage calls fn for every length-delimited field of a protobuf message and skips
+// the other wire types
+func walkMessage(data []byte, fn func(field int, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncated
+		}
+		data = data[n:]
+
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return errTruncated
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			data = data[8:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errTruncated
+			}
+			value := data[n : n+int(length)]
+			data = data[n+int(length):]
+			if err := fn(int(key>>3), value); err != nil {
+				return err
+			}
+		case 5:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+	}
+	return nil
+}
+
+// ParseBattleNetConfig reads the default install path and the known games from Battle.net.config
+func ParseBattleNetConfig(r io.Reader) (BattleNetConfig, error) {
+	var raw struct {
+		Client struct {
+			Install struct {
+				DefaultInstallPath string `json:"DefaultInstallPath"`
+			} `json:"Install"`
+		} `json:"Client"`
+		Games map[string]json.RawMessage `json:"Games"`
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return BattleNetConfig{}, fmt.Errorf("parsing Battle.net config: %w", err)
+	}
+
+	cfg := BattleNetConfig{DefaultInstallPath: raw.Client.Install.DefaultInstallPath}
+	for game := range raw.Games {
+		cfg.Games = append(cfg.Games, game)
+	}
+	return cfg, nil
+}
+
+// battleNetProductDirs returns the install directories of the game listed in product.db
+func (env Environment) battleNetProductDirs(game Game) ([]string, error) {
+	file, err := env.open(winJoin(env.ProgramData, "Battle.net", "Agent", "product.db"))
+	if file == nil || err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	installs, err := ParseProductDB(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, install := range installs {
+		if containsFold(game.BattleNetUIDs, install.UID) || containsFold(game.BattleNetProductCodes, install.ProductCode) {
+			dirs = append(dirs, install.InstallPath)
+		}
+	}
+	return dirs, nil
+}
+
+// battleNetConfigDirs returns the game's directories below the default install path in
+// Battle.net.config, for a game the config knows about
+func (env Environment) battleNetConfigDirs(game Game) ([]string, error) {
+	if env.AppData == "" {
+		return nil, nil
+	}
+
+	file, err := env.open(winJoin(env.AppData, "Battle.net", "Battle.net.config"))
+	if file == nil || err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg, err := ParseBattleNetConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	known := false
+	for _, uid := range cfg.Games {
+		known = known || containsFold(game.BattleNetUIDs, uid)
+	}
+	if !known || cfg.DefaultInstallPath == "" {
+		return nil, nil
+	}
+
+	var dirs []string
+	for _, name := range game.InstallDirNames {
+		dirs = append(dirs, winJoin(cfg.DefaultInstallPath, name))
+	}
+	return dirs, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
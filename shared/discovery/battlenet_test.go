@@ -0,0 +1,75 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProductDB(t *testing.T) {
+	data, err := os.ReadFile(filepath.FromSlash("testdata/battlenet/C/ProgramData/Battle.net/Agent/product.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	installs, err := ParseProductDB(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProductInstall{
+		{UID: "battle.net", ProductCode: "bna", InstallPath: "C:/Program Files (x86)/Battle.net"},
+		{UID: "prometheus", ProductCode: "pro", InstallPath: "D:/Games/Overwatch"},
+	}
+	if !reflect.DeepEqual(installs, want) {
+		t.Errorf("installs = %+v, want %+v", installs, want)
+	}
+
+	// Every prefix that cuts a field short must fail rather than return garbage
+	for _, size := range []int{1, 3, 20, len(data) - 1} {
+		if _, err := ParseProductDB(strings.NewReader(string(data[:size]))); err == nil {
+			t.Errorf("ParseProductDB of the first %d bytes succeeded", size)
+		}
+	}
+
+	if _, err := ParseProductDB(strings.NewReader("\x0b")); err == nil || !strings.Contains(err.Error(), "wire type 3") {
+		t.Errorf("ParseProductDB with a group field error = %v", err)
+	}
+}
+
+func TestParseProductDBSkipsOtherWireTypes(t *testing.T) {
+	// varint 7 = 1, fixed64 8, fixed32 9, then product_install {uid "prometheus"}
+	data := "\x38\x01" + "\x41" + strings.Repeat("\x00", 8) + "\x4d" + strings.Repeat("\x00", 4) + "\x0a\x0c\x0a\x0aprometheus"
+
+	installs, err := ParseProductDB(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installs) != 1 || installs[0].UID != "prometheus" {
+		t.Errorf("installs = %+v, want prometheus only", installs)
+	}
+}
+
+func TestParseBattleNetConfig(t *testing.T) {
+	file, err := os.Open(filepath.FromSlash("testdata/battlenet/C/Users/user/AppData/Roaming/Battle.net/Battle.net.config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	cfg, err := ParseBattleNetConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DefaultInstallPath != `D:\Games` {
+		t.Errorf("default install path = %q", cfg.DefaultInstallPath)
+	}
+	if !containsFold(cfg.Games, "prometheus") || !containsFold(cfg.Games, "battle_net") || len(cfg.Games) != 2 {
+		t.Errorf("games = %v, want battle_net and prometheus", cfg.Games)
+	}
+
+	if _, err := ParseBattleNetConfig(strings.NewReader(`{"Client": `)); err == nil {
+		t.Error("ParseBattleNetConfig of truncated JSON succeeded")
+	}
+}
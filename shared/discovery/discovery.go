@@ -0,0 +1,262 @@
+// Package discovery finds installed copies of the game without it running, by checking
+// known install locations, the Battle.net product database and config, and Steam
+// library manifests. Every candidate carries a confidence score.
+//
+// The parsers take readers so they can be run against the fixture files in testdata,
+// and an Environment with a Root maps every Windows path below a fixture directory.
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+// Confidence scores per source. A candidate found by several sources gets the highest
+// score plus agreementBonus for each additional source.
+const (
+	ConfidenceProductDB       = 95
+	ConfidenceSteamManifest   = 90
+	ConfidenceBattleNetConfig = 70
+	ConfidenceKnownLocation   = 60
+
+	agreementBonus = 5
+
+	// MinConfidence is the score below which a candidate should be confirmed by the user
+	MinConfidence = 60
+)
+
+// Source names reported with each candidate
+const (
+	SourceProductDB       = "battle.net product database"
+	SourceBattleNetConfig = "battle.net config"
+	SourceSteamManifest   = "steam app manifest"
+	SourceKnownLocation   = "known install location"
+)
+
+// Game describes where a game's executable can be found
+type Game struct {
+	Name string
+
+	// ExecutablePaths are tried in order below an install directory
+	ExecutablePaths []string
+
+	// BattleNetUIDs and BattleNetProductCodes identify the game in product.db
+	BattleNetUIDs         []string
+	BattleNetProductCodes []string
+
+	SteamAppIDs []string
+
+	// InstallDirNames are the directory names used by default installs
+	InstallDirNames []string
+}
+
+// Overwatch is the Overwatch 2 game client on Battle.net and Steam
+var Overwatch = Game{
+	Name:                  "Overwatch",
+	ExecutablePaths:       []string{`_retail_\Overwatch.exe`, `Overwatch.exe`},
+	BattleNetUIDs:         []string{"prometheus"},
+	BattleNetProductCodes: []string{"pro"},
+	SteamAppIDs:           []string{"2357570"},
+	InstallDirNames:       []string{"Overwatch", "Overwatch 2"},
+}
+
+// Candidate is a possible game executable
+type Candidate struct {
+	Path       string   `json:"path" yaml:"path"`
+	Edition    string   `json:"edition" yaml:"edition"`
+	Confidence int      `json:"confidence" yaml:"confidence"`
+	Sources    []string `json:"sources" yaml:"sources"`
+}
+
+// Environment holds the Windows locations that are searched. When Root is set, every
+// Windows path is looked up below Root as <Root>/<drive letter>/<rest of path>.
+type Environment struct {
+	Root            string
+	ProgramData     string
+	AppData         string
+	ProgramFiles    string
+	ProgramFilesX86 string
+	SteamDirs       []string
+}
+
+// DefaultEnvironment returns the locations of the current Windows user
+func DefaultEnvironment() Environment {
+	env := Environment{
+		ProgramData:     envOr("ProgramData", `C:\ProgramData`),
+		AppData:         envOr("APPDATA", ""),
+		ProgramFiles:    envOr("ProgramFiles", `C:\Program Files`),
+		ProgramFilesX86: envOr("ProgramFiles(x86)", `C:\Program Files (x86)`),
+	}
+	env.SteamDirs = []string{winJoin(env.ProgramFilesX86, "Steam"), winJoin(env.ProgramFiles, "Steam")}
+	return env
+}
+
+// FixtureEnvironment returns the default locations of a C: drive user named "user",
+// mapped below root
+func FixtureEnvironment(root string) Environment {
+	env := Environment{
+		Root:            root,
+		ProgramData:     `C:\ProgramData`,
+		AppData:         `C:\Users\user\AppData\Roaming`,
+		ProgramFiles:    `C:\Program Files`,
+		ProgramFilesX86: `C:\Program Files (x86)`,
+	}
+	env.SteamDirs = []string{winJoin(env.ProgramFilesX86, "Steam"), winJoin(env.ProgramFiles, "Steam")}
+	return env
+}
+
+// Discover returns the game executables found in env, best candidate first. Problems
+// with individual sources, such as an unreadable product database, are returned as
+// warnings and do not stop the search.
+func Discover(env Environment, game Game) ([]Candidate, []error) {
+	found := make(map[string]*Candidate)
+	var order []string
+	var warnings []error
+
+	add := func(installDir, source string, confidence int) {
+		path, ok := env.findExecutable(installDir, game)
+		if !ok {
+			return
+		}
+
+		key := strings.ToLower(path)
+		candidate, seen := found[key]
+		if !seen {
+			found[key] = &Candidate{Path: path, Edition: configstore.EditionOf(path), Confidence: confidence, Sources: []string{source}}
+			order = append(order, key)
+			return
+		}
+
+		for _, existing := range candidate.Sources {
+			if existing == source {
+				return
+			}
+		}
+		candidate.Sources = append(candidate.Sources, source)
+		candidate.Confidence = min(max(candidate.Confidence, confidence)+agreementBonus, 100)
+	}
+
+	dirs, err := env.battleNetProductDirs(game)
+	if err != nil {
+		warnings = append(warnings, err)
+	}
+	for _, dir := range dirs {
+		add(dir, SourceProductDB, ConfidenceProductDB)
+	}
+
+	dirs, err = env.steamInstallDirs(game)
+	if err != nil {
+		warnings = append(warnings, err)
+	}
+	for _, dir := range dirs {
+		add(dir, SourceSteamManifest, ConfidenceSteamManifest)
+	}
+
+	dirs, err = env.battleNetConfigDirs(game)
+	if err != nil {
+		warnings = append(warnings, err)
+	}
+	for _, dir := range dirs {
+		add(dir, SourceBattleNetConfig, ConfidenceBattleNetConfig)
+	}
+
+	for _, dir := range env.knownInstallDirs(game) {
+		add(dir, SourceKnownLocation, ConfidenceKnownLocation)
+	}
+
+	candidates := make([]Candidate, 0, len(order))
+	for _, key := range order {
+		candidates = append(candidates, *found[key])
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	return candidates, warnings
+}
+
+// Best returns the highest scoring candidate with at least MinConfidence
+func Best(candidates []Candidate) (Candidate, bool) {
+	if len(candidates) == 0 || candidates[0].Confidence < MinConfidence {
+		return Candidate{}, false
+	}
+	return candidates[0], true
+}
+
+// knownInstallDirs are the default Battle.net and Steam install directories
+func (env Environment) knownInstallDirs(game Game) []string {
+	var dirs []string
+	for _, name := range game.InstallDirNames {
+		dirs = append(dirs, winJoin(env.ProgramFilesX86, name), winJoin(env.ProgramFiles, name))
+		for _, steamDir := range env.SteamDirs {
+			dirs = append(dirs, winJoin(steamDir, "steamapps", "common", name))
+		}
+	}
+	return dirs
+}
+
+// findExecutable returns the first of the game's executable paths that exists in installDir
+func (env Environment) findExecutable(installDir string, game Game) (string, bool) {
+	if installDir == "" {
+		return "", false
+	}
+	for _, relative := range game.ExecutablePaths {
+		path := winJoin(installDir, relative)
+		if info, err := os.Stat(env.Local(path)); err == nil && info.Mode().IsRegular() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Local returns the path to open for a Windows path, mapping it below Root when set
+func (env Environment) Local(path string) string {
+	if env.Root == "" {
+		return path
+	}
+
+	path = strings.ReplaceAll(path, `\`, "/")
+	if len(path) >= 2 && path[1] == ':' {
+		path = strings.ToUpper(path[:1]) + path[2:]
+	}
+	return filepath.Join(env.Root, filepath.FromSlash(path))
+}
+
+// open opens a Windows path, returning nil without error when the file does not exist
+func (env Environment) open(path string) (*os.File, error) {
+	file, err := os.Open(env.Local(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// winJoin joins Windows path elements, accepting either separator in the elements
+func winJoin(elements ...string) string {
+	var parts []string
+	for i, element := range elements {
+		element = strings.ReplaceAll(element, "/", `\`)
+		if i > 0 {
+			element = strings.TrimLeft(element, `\`)
+		}
+		element = strings.TrimRight(element, `\`)
+		if element != "" {
+			parts = append(parts, element)
+		}
+	}
+	return strings.Join(parts, `\`)
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
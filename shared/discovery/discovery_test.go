@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"quidque.no/ow-vpn-shared/configstore"
+)
+
+func TestDiscoverBattleNet(t *testing.T) {
+	candidates, warnings := Discover(FixtureEnvironment(filepath.Join("testdata", "battlenet")), Overwatch)
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	// Found by product.db and Battle.net.config, so it gets the agreement bonus
+	want := []Candidate{{
+		Path:       `D:\Games\Overwatch\_retail_\Overwatch.exe`,
+		Edition:    configstore.EditionBattleNet,
+		Confidence: ConfidenceProductDB + agreementBonus,
+		Sources:    []string{SourceProductDB, SourceBattleNetConfig},
+	}}
+	if !reflect.DeepEqual(candidates, want) {
+		t.Errorf("candidates = %+v, want %+v", candidates, want)
+	}
+
+	best, ok := Best(candidates)
+	if !ok || best.Path != want[0].Path {
+		t.Errorf("Best() = %+v, %v", best, ok)
+	}
+}
+
+func TestDiscoverSteam(t *testing.T) {
+	candidates, warnings := Discover(FixtureEnvironment(filepath.Join("testdata", "steam")), Overwatch)
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	want := []Candidate{{
+		Path:       `E:\SteamLibrary\steamapps\common\Overwatch\Overwatch.exe`,
+		Edition:    configstore.EditionSteam,
+		Confidence: ConfidenceSteamManifest,
+		Sources:    []string{SourceSteamManifest},
+	}}
+	if !reflect.DeepEqual(candidates, want) {
+		t.Errorf("candidates = %+v, want %+v", candidates, want)
+	}
+}
+
+func TestDiscoverKeepsSearchingAfterBrokenSource(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, "C/ProgramData/Battle.net/Agent/product.db", "\x0a\x7f")
+	writeFixture(t, root, "C/Program Files (x86)/Overwatch/_retail_/Overwatch.exe", "MZ")
+	writeFixture(t, root, "C/Program Files/Overwatch 2/Overwatch.exe", "MZ")
+
+	candidates, warnings := Discover(FixtureEnvironment(root), Overwatch)
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %v, want the broken product database", warnings)
+	}
+
+	want := []Candidate{
+		{Path: `C:\Program Files (x86)\Overwatch\_retail_\Overwatch.exe`, Edition: configstore.EditionBattleNet, Confidence: ConfidenceKnownLocation, Sources: []string{SourceKnownLocation}},
+		{Path: `C:\Program Files\Overwatch 2\Overwatch.exe`, Edition: configstore.EditionBattleNet, Confidence: ConfidenceKnownLocation, Sources: []string{SourceKnownLocation}},
+	}
+	if !reflect.DeepEqual(candidates, want) {
+		t.Errorf("candidates = %+v, want %+v", candidates, want)
+	}
+}
+
+func TestDiscoverNothing(t *testing.T) {
+	candidates, warnings := Discover(FixtureEnvironment(t.TempDir()), Overwatch)
+	if len(candidates) != 0 || len(warnings) != 0 {
+		t.Errorf("empty root found %v, warnings %v", candidates, warnings)
+	}
+	if _, ok := Best(candidates); ok {
+		t.Error("Best() of no candidates succeeded")
+	}
+	if _, ok := Best([]Candidate{{Path: "x", Confidence: MinConfidence - 1}}); ok {
+		t.Error("Best() accepted a candidate below MinConfidence")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	env := FixtureEnvironment("root")
+	if got, want := env.Local(`d:\Games\Overwatch`), filepath.Join("root", "D", "Games", "Overwatch"); got != want {
+		t.Errorf("Local() = %s, want %s", got, want)
+	}
+	if got := DefaultEnvironment().Local(`C:\x`); got != `C:\x` {
+		t.Errorf("Local() without root = %s", got)
+	}
+}
+
+func writeFixture(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
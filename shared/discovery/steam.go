@@ -0,0 +1,305 @@
+package discovery
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// VDFNode is a node of a Valve KeyValues (VDF/ACF) document. Leaves have a Value,
+// sections have Children in file order.
+type VDFNode struct {
+	Key      string
+	Value    string
+	Children []*VDFNode
+}
+
+// Child returns the first child with the given key, compared case-insensitively as Steam does
+func (n *VDFNode) Child(key string) *VDFNode {
+	if n == nil {
+		return nil
+	}
+	for _, child := range n.Children {
+		if strings.EqualFold(child.Key, key) {
+			return child
+		}
+	}
+	return nil
+}
+
+// String returns the value of the child with the given key, or "" if there is none
+func (n *VDFNode) String(key string) string {
+	if child := n.Child(key); child != nil {
+		return child.Value
+	}
+	return ""
+}
+
+// SteamLibrary is one library folder from libraryfolders.vdf
+type SteamLibrary struct {
+	Path string
+	Apps []string
+}
+
+// AppManifest holds the fields of an appmanifest_<appid>.acf file used for discovery
+type AppManifest struct {
+	AppID      string
+	Name       string
+	InstallDir string
+}
+
+// ParseVDF parses a VDF document into a root node whose children are the top-level keys
+func ParseVDF(r io.Reader) (*VDFNode, error) {
+	tokens, err := vdfTokens(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &VDFNode{}
+	stack := []*VDFNode{root}
+	for i := 0; i < len(tokens); i++ {
+		current := stack[len(stack)-1]
+
+		switch tokens[i] {
+		case "}":
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("parsing VDF: unexpected '}'")
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		case "{":
+			return nil, fmt.Errorf("parsing VDF: section without a key")
+		}
+
+		node := &VDFNode{Key: tokens[i]}
+		current.Children = append(current.Children, node)
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("parsing VDF: key '%s' has no value", node.Key)
+		}
+
+		i++
+		switch tokens[i] {
+		case "{":
+			stack = append(stack, node)
+		case "}":
+			return nil, fmt.Errorf("parsing VDF: key '%s' has no value", node.Key)
+		default:
+			node.Value = tokens[i]
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("parsing VDF: unclosed section '%s'", stack[len(stack)-1].Key)
+	}
+	return root, nil
+}
+
+// vdfTokens splits a VDF document into quoted strings, bare words and braces,
+// dropping // comments. Quoted strings support \\, \" and \n escapes.
+func vdfTokens(r io.Reader) ([]string, error) {
+	reader := bufio.NewReader(r)
+	var tokens []string
+
+	for {
+		c, _, err := reader.ReadRune()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading VDF: %w", err)
+		}
+
+		switch {
+		case c == '{' || c == '}':
+			tokens = append(tokens, string(c))
+
+		case c == '"':
+			var b strings.Builder
+			for {
+				c, _, err = reader.ReadRune()
+				if err != nil {
+					return nil, fmt.Errorf("parsing VDF: unterminated string")
+				}
+				if c == '"' {
+					break
+				}
+				if c == '\\' {
+					if c, _, err = reader.ReadRune(); err != nil {
+						return nil, fmt.Errorf("parsing VDF: unterminated string")
+					}
+					if c == 'n' {
+						c = '\n'
+					}
+				}
+				b.WriteRune(c)
+			}
+			tokens = append(tokens, b.String())
+
+		case c == '/':
+			if next, _ := reader.Peek(1); len(next) == 1 && next[0] == '/' {
+				reader.ReadString('\n')
+				continue
+			}
+			fallthrough
+
+		case !isVDFSpace(c):
+			word := []rune{c}
+			for {
+				next, _, err := reader.ReadRune()
+				if err != nil {
+					break
+				}
+				if isVDFSpace(next) || next == '{' || next == '}' || next == '"' {
+					reader.UnreadRune()
+					break
+				}
+				word = append(word, next)
+			}
+			tokens = append(tokens, string(word))
+		}
+	}
+}
+
+func isVDFSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// ParseLibraryFolders reads the library folders from steamapps/libraryfolders.vdf. Both
+// the current format (numbered sections with path and apps) and the old one (numbered
+// keys whose value is the path) are read.
+func ParseLibraryFolders(r io.Reader) ([]SteamLibrary, error) {
+	root, err := ParseVDF(r)
+	if err != nil {
+		return nil, err
+	}
+
+	folders := root.Child("libraryfolders")
+	if folders == nil {
+		return nil, fmt.Errorf("parsing library folders: no libraryfolders section")
+	}
+
+	var libraries []SteamLibrary
+	for _, entry := range folders.Children {
+		if !isNumber(entry.Key) {
+			continue
+		}
+		if entry.Children == nil {
+			libraries = append(libraries, SteamLibrary{Path: entry.Value})
+			continue
+		}
+
+		library := SteamLibrary{Path: entry.String("path")}
+		for _, app := range entry.Child("apps").childrenOrNil() {
+			library.Apps = append(library.Apps, app.Key)
+		}
+		libraries = append(libraries, library)
+	}
+	return libraries, nil
+}
+
+// ParseAppManifest reads an appmanifest_<appid>.acf file
+func ParseAppManifest(r io.Reader) (AppManifest, error) {
+	root, err := ParseVDF(r)
+	if err != nil {
+		return AppManifest{}, err
+	}
+
+	state := root.Child("AppState")
+	if state == nil {
+		return AppManifest{}, fmt.Errorf("parsing app manifest: no AppState section")
+	}
+	return AppManifest{
+		AppID:      state.String("appid"),
+		Name:       state.String("name"),
+		InstallDir: state.String("installdir"),
+	}, nil
+}
+
+func (n *VDFNode) childrenOrNil() []*VDFNode {
+	if n == nil {
+		return nil
+	}
+	return n.Children
+}
+
+// steamInstallDirs returns the game's install directories from the app manifests in
+// every Steam library
+func (env Environment) steamInstallDirs(game Game) ([]string, error) {
+	var dirs []string
+	var firstErr error
+	keep := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, steamDir := range env.SteamDirs {
+		libraries := []string{steamDir}
+
+		file, err := env.open(winJoin(steamDir, "steamapps", "libraryfolders.vdf"))
+		if err != nil {
+			keep(err)
+		}
+		if file != nil {
+			found, err := ParseLibraryFolders(file)
+			file.Close()
+			if err != nil {
+				keep(err)
+			}
+			for _, library := range found {
+				libraries = append(libraries, library.Path)
+			}
+		}
+
+		for _, library := range libraries {
+			key := strings.ToLower(winJoin(library))
+			if library == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			for _, appID := range game.SteamAppIDs {
+				dir, err := env.steamAppDir(library, appID)
+				if err != nil {
+					keep(err)
+				}
+				if dir != "" {
+					dirs = append(dirs, dir)
+				}
+			}
+		}
+	}
+	return dirs, firstErr
+}
+
+// steamAppDir returns the install directory of an app in a library, if its manifest exists
+func (env Environment) steamAppDir(library, appID string) (string, error) {
+	file, err := env.open(winJoin(library, "steamapps", "appmanifest_"+appID+".acf"))
+	if file == nil || err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	manifest, err := ParseAppManifest(file)
+	if err != nil {
+		return "", err
+	}
+	if manifest.InstallDir == "" {
+		return "", fmt.Errorf("app manifest %s has no installdir", appID)
+	}
+	return winJoin(library, "steamapps", "common", manifest.InstallDir), nil
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLibraryFolders(t *testing.T) {
+	file, err := os.Open(filepath.FromSlash("testdata/steam/C/Program Files (x86)/Steam/steamapps/libraryfolders.vdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	libraries, err := ParseLibraryFolders(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SteamLibrary{
+		{Path: `C:\Program Files (x86)\Steam`, Apps: []string{"228980"}},
+		{Path: `E:\SteamLibrary`, Apps: []string{"2357570"}},
+	}
+	if !reflect.DeepEqual(libraries, want) {
+		t.Errorf("libraries = %+v, want %+v", libraries, want)
+	}
+}
+
+func TestParseLibraryFoldersOldFormat(t *testing.T) {
+	old := `"LibraryFolders"
+{
+	"TimeNextStatsReport"	"1733850000"
+	"ContentStatsID"	"-4857237593827364"
+	"1"	"D:\\Games\\Steam"
+}`
+
+	libraries, err := ParseLibraryFolders(strings.NewReader(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SteamLibrary{{Path: `D:\Games\Steam`}}; !reflect.DeepEqual(libraries, want) {
+		t.Errorf("libraries = %+v, want %+v", libraries, want)
+	}
+}
+
+func TestParseVDFErrors(t *testing.T) {
+	tests := map[string]string{
+		"unexpected close":    `"a" "b" }`,
+		"section without key": `{ "a" "b" }`,
+		"missing value":       `"a"`,
+		"unclosed section":    `"a" { "b" "c"`,
+		"unterminated string": `"a" "b`,
+	}
+	for name, document := range tests {
+		if _, err := ParseVDF(strings.NewReader(document)); err == nil {
+			t.Errorf("%s: ParseVDF(%q) succeeded", name, document)
+		}
+	}
+
+	if _, err := ParseLibraryFolders(strings.NewReader(`"other" { }`)); err == nil {
+		t.Error("ParseLibraryFolders without a libraryfolders section succeeded")
+	}
+	if _, err := ParseAppManifest(strings.NewReader(`"other" { }`)); err == nil {
+		t.Error("ParseAppManifest without an AppState section succeeded")
+	}
+}
+
+func TestParseAppManifest(t *testing.T) {
+	file, err := os.Open(filepath.FromSlash("testdata/steam/E/SteamLibrary/steamapps/appmanifest_2357570.acf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	manifest, err := ParseAppManifest(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AppManifest{AppID: "2357570", Name: "Overwatch® 2", InstallDir: "Overwatch"}
+	if manifest != want {
+		t.Errorf("manifest = %+v, want %+v", manifest, want)
+	}
+}
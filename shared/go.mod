@@ -0,0 +1,5 @@
+module quidque.no/ow-vpn-shared
+
+go 1.24.1
+
+require golang.org/x/sys v0.30.0
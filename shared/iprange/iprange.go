@@ -0,0 +1,230 @@
+// Package iprange parses, validates and formats the IP ranges of the region lists, and
+// works with them as sets of addresses. It is the one place the IP Puller, the firewall
+// sidecar and the GUI decide what a valid range is, so they agree on every edge case:
+//
+//   - Surrounding whitespace is ignored, and so is whitespace around the dash of an a-b
+//     range and the slash of a prefix.
+//   - CIDR prefixes may have host bits set; 1.2.3.4/24 is read as 1.2.3.0/24.
+//   - Prefix lengths must fit the family: /33 is invalid for IPv4, /129 for IPv6.
+//   - IPv4 octets with leading zeros (01.2.3.4) are invalid, as they are ambiguous.
+//   - a-b ranges must not end before they start and both ends must be of one family.
+//   - Addresses with a zone (fe80::1%eth0) are invalid.
+//   - IPv4-mapped IPv6 addresses (::ffff:1.2.3.4) are read as IPv4.
+package iprange
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Range is an inclusive range of IPv4 or IPv6 addresses. Both ends are of the same family.
+type Range struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// ParseError describes why a range could not be parsed
+type ParseError struct {
+	Text   string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid range '%s': %s", e.Text, e.Reason)
+}
+
+// Parse reads a range in CIDR (1.2.3.0/24, 2001:db8::/32), dash (1.2.3.4-1.2.3.9) or
+// single address form. Errors are *ParseError.
+func Parse(text string) (Range, error) {
+	trimmed := strings.TrimSpace(text)
+	fail := func(format string, args ...interface{}) (Range, error) {
+		return Range{}, &ParseError{Text: text, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if trimmed == "" {
+		return fail("empty")
+	}
+
+	if address, length, ok := strings.Cut(trimmed, "/"); ok {
+		addr, err := parseAddr(address)
+		if err != nil {
+			return fail("%v", err)
+		}
+		length = strings.TrimSpace(length)
+		ones, err := strconv.Atoi(length)
+		if err != nil || length == "" || length[0] == '+' || length[0] == '-' || (len(length) > 1 && length[0] == '0') {
+			return fail("invalid prefix length '%s'", length)
+		}
+		if ones > addr.BitLen() {
+			return fail("prefix length %d is longer than the %d bits of %s", ones, addr.BitLen(), family(addr))
+		}
+
+		prefix := unmapPrefix(netip.PrefixFrom(addr, ones)).Masked()
+		return Range{First: prefix.Addr(), Last: lastAddr(prefix)}, nil
+	}
+
+	if first, last, ok := strings.Cut(trimmed, "-"); ok {
+		from, err := parseAddr(first)
+		if err != nil {
+			return fail("start: %v", err)
+		}
+		to, err := parseAddr(last)
+		if err != nil {
+			return fail("end: %v", err)
+		}
+		from, to = from.Unmap(), to.Unmap()
+		if from.Is4() != to.Is4() {
+			return fail("mixes %s and %s", family(from), family(to))
+		}
+		if to.Less(from) {
+			return fail("ends before it starts")
+		}
+		return Range{First: from, Last: to}, nil
+	}
+
+	addr, err := parseAddr(trimmed)
+	if err != nil {
+		return fail("%v", err)
+	}
+	addr = addr.Unmap()
+	return Range{First: addr, Last: addr}, nil
+}
+
+// String formats the range as a CIDR prefix when it is exactly one, and as first-last otherwise
+func (r Range) String() string {
+	if !r.First.IsValid() {
+		return "invalid range"
+	}
+	if prefixes := r.Prefixes(); len(prefixes) == 1 {
+		return prefixes[0].String()
+	}
+	return r.First.String() + "-" + r.Last.String()
+}
+
+// Is4 reports whether the range holds IPv4 addresses
+func (r Range) Is4() bool {
+	return r.First.Is4()
+}
+
+// Contains reports whether addr is in the range
+func (r Range) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.Is4() == r.Is4() && !addr.Less(r.First) && !r.Last.Less(addr)
+}
+
+// Overlaps reports whether the ranges share any address
+func (r Range) Overlaps(other Range) bool {
+	return r.Is4() == other.Is4() && !r.Last.Less(other.First) && !other.Last.Less(r.First)
+}
+
+// Size returns the number of addresses in the range. IPv6 ranges with more addresses
+// than fit in a uint64 return math.MaxUint64.
+func (r Range) Size() uint64 {
+	hi, lo := sub128(r.Last, r.First)
+	if hi != 0 || lo == math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return lo + 1
+}
+
+// Prefixes returns the fewest CIDR prefixes that cover exactly the range
+func (r Range) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	start := r.First
+
+	for {
+		// The largest block that is aligned at start and does not run past Last
+		var prefix netip.Prefix
+		for ones := 0; ones <= start.BitLen(); ones++ {
+			prefix = netip.PrefixFrom(start, ones)
+			if prefix.Masked().Addr() == start && !r.Last.Less(lastAddr(prefix)) {
+				break
+			}
+		}
+		prefixes = append(prefixes, prefix)
+
+		end := lastAddr(prefix)
+		if end == r.Last {
+			return prefixes
+		}
+		start = end.Next()
+	}
+}
+
+// Compare orders ranges by first and then by last address, IPv4 before IPv6
+func (r Range) Compare(other Range) int {
+	if c := r.First.Compare(other.First); c != 0 {
+		return c
+	}
+	return r.Last.Compare(other.Last)
+}
+
+// lastAddr returns the last address of a masked prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(addr)*8; bit++ {
+		addr[bit/8] |= 0x80 >> (bit % 8)
+	}
+	last, _ := netip.AddrFromSlice(addr)
+	return last
+}
+
+// parseAddr parses a single address without a zone
+func parseAddr(text string) (netip.Addr, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return netip.Addr{}, fmt.Errorf("missing address")
+	}
+
+	addr, err := netip.ParseAddr(text)
+	if err != nil {
+		// Keep the reason, not the ParseAddr("...") prefix netip puts in front of it
+		reason := err.Error()
+		if _, detail, ok := strings.Cut(reason, "): "); ok {
+			reason = detail
+		}
+		return netip.Addr{}, fmt.Errorf("invalid address '%s': %s", text, reason)
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("address '%s' has a zone", text)
+	}
+	return addr, nil
+}
+
+// unmapPrefix turns an IPv4-mapped IPv6 prefix such as ::ffff:1.2.3.0/120 into its IPv4 form
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	if !prefix.Addr().Is4In6() || prefix.Bits() < 96 {
+		return prefix
+	}
+	return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+}
+
+func family(addr netip.Addr) string {
+	if addr.Is4() {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// sub128 returns a-b of two addresses of the same family as a 128 bit number
+func sub128(a, b netip.Addr) (hi, lo uint64) {
+	aHi, aLo := split128(a)
+	bHi, bLo := split128(b)
+	lo, borrow := bits.Sub64(aLo, bLo, 0)
+	hi, _ = bits.Sub64(aHi, bHi, borrow)
+	return hi, lo
+}
+
+func split128(addr netip.Addr) (hi, lo uint64) {
+	if addr.Is4() {
+		b := addr.As4()
+		return 0, uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+	}
+	b := addr.As16()
+	return binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+}
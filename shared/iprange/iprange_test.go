@@ -0,0 +1,456 @@
+package iprange
+
+import (
+	"errors"
+	"math"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text  string
+		first string
+		last  string
+	}{
+		// Single addresses
+		{"1.2.3.4", "1.2.3.4", "1.2.3.4"},
+		{"2001:db8::1", "2001:db8::1", "2001:db8::1"},
+
+		// Whitespace around the range, the dash and the slash
+		{"  1.2.3.4\t", "1.2.3.4", "1.2.3.4"},
+		{"1.2.3.4 - 1.2.3.9", "1.2.3.4", "1.2.3.9"},
+		{"1.2.3.4-  1.2.3.9", "1.2.3.4", "1.2.3.9"},
+		{"1.2.3.0 / 24", "1.2.3.0", "1.2.3.255"},
+		{"2001:db8:: /32", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+
+		// CIDR prefixes, with host bits masked off
+		{"1.2.3.0/24", "1.2.3.0", "1.2.3.255"},
+		{"1.2.3.4/24", "1.2.3.0", "1.2.3.255"},
+		{"0.0.0.0/0", "0.0.0.0", "255.255.255.255"},
+		{"1.2.3.4/32", "1.2.3.4", "1.2.3.4"},
+		{"2001:db8::1/128", "2001:db8::1", "2001:db8::1"},
+
+		// Dash ranges, including ranges of one address
+		{"1.2.3.4-1.2.3.4", "1.2.3.4", "1.2.3.4"},
+		{"2001:db8::1-2001:db8::ff", "2001:db8::1", "2001:db8::ff"},
+
+		// IPv4-mapped IPv6 addresses are read as IPv4
+		{"::ffff:1.2.3.4", "1.2.3.4", "1.2.3.4"},
+		{"::ffff:1.2.3.0/120", "1.2.3.0", "1.2.3.255"},
+		{"::ffff:1.2.3.4-1.2.3.9", "1.2.3.4", "1.2.3.9"},
+		{"::ffff:0:0/96", "0.0.0.0", "255.255.255.255"},
+	}
+
+	for _, test := range tests {
+		r, err := Parse(test.text)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.text, err)
+			continue
+		}
+		want := Range{First: netip.MustParseAddr(test.first), Last: netip.MustParseAddr(test.last)}
+		if r != want {
+			t.Errorf("Parse(%q) = %v-%v, want %v-%v", test.text, r.First, r.Last, want.First, want.Last)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text   string
+		reason string
+	}{
+		{"", "empty"},
+		{"   ", "empty"},
+
+		// Prefix lengths that do not fit the family or are not plain numbers
+		{"1.2.3.0/33", "longer than the 32 bits of IPv4"},
+		{"2001:db8::/129", "longer than the 128 bits of IPv6"},
+		{"1.2.3.0/", "invalid prefix length ''"},
+		{"1.2.3.0/024", "invalid prefix length '024'"},
+		{"1.2.3.0/+24", "invalid prefix length '+24'"},
+		{"1.2.3.0/-1", "invalid prefix length '-1'"},
+		{"1.2.3.0/x", "invalid prefix length 'x'"},
+		{"/24", "missing address"},
+
+		// Leading zeros are ambiguous
+		{"01.2.3.4", "leading zero"},
+		{"1.2.3.04/32", "leading zero"},
+		{"1.2.3.4-1.2.3.09", "end: invalid address '1.2.3.09'"},
+
+		// Reversed and mixed dash ranges
+		{"1.2.3.9-1.2.3.4", "ends before it starts"},
+		{"2001:db8::ff-2001:db8::1", "ends before it starts"},
+		{"1.2.3.4-2001:db8::1", "mixes IPv4 and IPv6"},
+		{"2001:db8::1-1.2.3.4", "mixes IPv6 and IPv4"},
+		{"-1.2.3.4", "start: missing address"},
+		{"1.2.3.4-", "end: missing address"},
+		{"1.2.3.4-1.2.3.5-1.2.3.6", "end: invalid address"},
+
+		// Zones
+		{"fe80::1%eth0", "has a zone"},
+		{"fe80::1%eth0/64", "has a zone"},
+
+		// Not addresses at all
+		{"999.1.1.1", "value >255"},
+		{"1.2.3", "invalid address '1.2.3'"},
+		{"1.2.3.4 1.2.3.5", "invalid address"},
+		{"example.com", "invalid address"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.text)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error containing %q", test.text, test.reason)
+			continue
+		}
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q) returned %T, want *ParseError", test.text, err)
+			continue
+		}
+		if parseErr.Text != test.text {
+			t.Errorf("Parse(%q) error text = %q", test.text, parseErr.Text)
+		}
+		if !strings.Contains(parseErr.Reason, test.reason) {
+			t.Errorf("Parse(%q) reason = %q, want it to contain %q", test.text, parseErr.Reason, test.reason)
+		}
+	}
+}
+
+func TestStringAndPrefixes(t *testing.T) {
+	tests := []struct {
+		text     string
+		str      string
+		prefixes []string
+	}{
+		{"1.2.3.4", "1.2.3.4/32", []string{"1.2.3.4/32"}},
+		{"1.2.3.4/24", "1.2.3.0/24", []string{"1.2.3.0/24"}},
+		{"1.2.3.0-1.2.3.255", "1.2.3.0/24", []string{"1.2.3.0/24"}},
+		{"1.2.3.4 - 1.2.3.9", "1.2.3.4-1.2.3.9", []string{"1.2.3.4/30", "1.2.3.8/31"}},
+		{"10.0.0.5-10.0.2.255", "10.0.0.5-10.0.2.255", []string{
+			"10.0.0.5/32", "10.0.0.6/31", "10.0.0.8/29", "10.0.0.16/28", "10.0.0.32/27",
+			"10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/24", "10.0.2.0/24",
+		}},
+		{"0.0.0.0-255.255.255.255", "0.0.0.0/0", []string{"0.0.0.0/0"}},
+		{"255.255.255.254-255.255.255.255", "255.255.255.254/31", []string{"255.255.255.254/31"}},
+		{"::ffff:1.2.3.4", "1.2.3.4/32", []string{"1.2.3.4/32"}},
+		{"2001:db8::1", "2001:db8::1/128", []string{"2001:db8::1/128"}},
+		{"2001:db8::-2001:db8::3", "2001:db8::/126", []string{"2001:db8::/126"}},
+		{"2001:db8::1-2001:db8::4", "2001:db8::1-2001:db8::4", []string{"2001:db8::1/128", "2001:db8::2/127", "2001:db8::4/128"}},
+		{"::/0", "::/0", []string{"::/0"}},
+	}
+
+	for _, test := range tests {
+		r, err := Parse(test.text)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.text, err)
+		}
+
+		if got := r.String(); got != test.str {
+			t.Errorf("Parse(%q).String() = %q, want %q", test.text, got, test.str)
+		}
+
+		var prefixes []string
+		for _, prefix := range r.Prefixes() {
+			prefixes = append(prefixes, prefix.String())
+		}
+		if strings.Join(prefixes, " ") != strings.Join(test.prefixes, " ") {
+			t.Errorf("Parse(%q).Prefixes() = %v, want %v", test.text, prefixes, test.prefixes)
+		}
+
+		// Both forms must read back as the same range
+		again, err := Parse(r.String())
+		if err != nil || again != r {
+			t.Errorf("Parse(%q) does not round trip through %q: %v-%v, %v", test.text, r.String(), again.First, again.Last, err)
+		}
+		var ranges []Range
+		for _, prefix := range prefixes {
+			ranges = append(ranges, mustParse(t, prefix))
+		}
+		if merged := NewSet(ranges).Ranges(); len(merged) != 1 || merged[0] != r {
+			t.Errorf("prefixes of %q merge to %v, want %v", test.text, merged, r)
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		text string
+		size uint64
+	}{
+		{"1.2.3.4", 1},
+		{"1.2.3.0/24", 256},
+		{"0.0.0.0/0", 1 << 32},
+		{"2001:db8::/64", math.MaxUint64},
+		{"2001:db8::/65", 1 << 63},
+		{"::/0", math.MaxUint64},
+	}
+
+	for _, test := range tests {
+		if got := mustParse(t, test.text).Size(); got != test.size {
+			t.Errorf("Parse(%q).Size() = %d, want %d", test.text, got, test.size)
+		}
+	}
+}
+
+func TestContainsAndOverlaps(t *testing.T) {
+	r := mustParse(t, "1.2.3.0/24")
+
+	for _, addr := range []string{"1.2.3.0", "1.2.3.255", "::ffff:1.2.3.9"} {
+		if !r.Contains(netip.MustParseAddr(addr)) {
+			t.Errorf("%v does not contain %s", r, addr)
+		}
+	}
+	for _, addr := range []string{"1.2.2.255", "1.2.4.0", "::102:300"} {
+		if r.Contains(netip.MustParseAddr(addr)) {
+			t.Errorf("%v contains %s", r, addr)
+		}
+	}
+
+	overlapping := []string{"1.2.3.255-1.2.4.0", "1.2.0.0/16", "1.2.3.7"}
+	for _, text := range overlapping {
+		if !r.Overlaps(mustParse(t, text)) {
+			t.Errorf("%v does not overlap %s", r, text)
+		}
+	}
+	for _, text := range []string{"1.2.4.0/24", "1.2.2.0-1.2.2.255", "::/0"} {
+		if r.Overlaps(mustParse(t, text)) {
+			t.Errorf("%v overlaps %s", r, text)
+		}
+	}
+}
+
+func TestNewSet(t *testing.T) {
+	set := NewSet(mustParseAll(t,
+		"1.2.3.0/24",
+		"1.2.4.0/24",   // adjacent, merged
+		"1.2.3.128/25", // contained
+		"10.0.0.0-10.0.0.9",
+		"10.0.0.5-10.0.0.20", // overlapping
+		"2001:db8::/48",
+		"255.255.255.255", // last IPv4 address is not merged with IPv6
+		"::",
+	))
+
+	want := []string{"1.2.3.0-1.2.4.255", "10.0.0.0-10.0.0.20", "255.255.255.255/32", "::/128", "2001:db8::/48"}
+	var got []string
+	for _, r := range set.Ranges() {
+		got = append(got, r.String())
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("NewSet ranges = %v, want %v", got, want)
+	}
+
+	wantPrefixes := []string{"1.2.3.0/24", "1.2.4.0/24", "10.0.0.0/28", "10.0.0.16/30", "10.0.0.20/32", "255.255.255.255/32", "::/128", "2001:db8::/48"}
+	if got := set.Strings(); strings.Join(got, " ") != strings.Join(wantPrefixes, " ") {
+		t.Errorf("NewSet strings = %v, want %v", got, wantPrefixes)
+	}
+
+	if !set.Contains(netip.MustParseAddr("1.2.4.9")) || set.Contains(netip.MustParseAddr("1.2.5.0")) {
+		t.Error("Contains disagrees with the merged ranges")
+	}
+	if !set.Overlaps(mustParse(t, "10.0.0.20-10.0.0.30")) || set.Overlaps(mustParse(t, "10.0.0.21-10.0.0.30")) {
+		t.Error("Overlaps disagrees with the merged ranges")
+	}
+}
+
+func TestDifferenceAndIntersection(t *testing.T) {
+	a := NewSet(mustParseAll(t, "10.0.0.0/24", "10.0.2.0/24", "2001:db8::/32"))
+	b := NewSet(mustParseAll(t, "10.0.0.64/26", "10.0.0.200-10.0.2.9", "2001:db8::/33", "192.168.0.0/16"))
+
+	tests := []struct {
+		name string
+		set  *Set
+		want []string
+	}{
+		{"a-b", a.Difference(b), []string{"10.0.0.0/26", "10.0.0.128/26", "10.0.0.192/29", "10.0.2.10/31", "10.0.2.12/30", "10.0.2.16/28", "10.0.2.32/27", "10.0.2.64/26", "10.0.2.128/25", "2001:db8:8000::/33"}},
+		{"b-a", b.Difference(a), []string{"10.0.1.0/24", "192.168.0.0/16"}},
+		{"a&b", a.Intersection(b), []string{"10.0.0.64/26", "10.0.0.200/29", "10.0.0.208/28", "10.0.0.224/27", "10.0.2.0/29", "10.0.2.8/31", "2001:db8::/33"}},
+		{"a-a", a.Difference(a), nil},
+		{"a-empty", a.Difference(NewSet(nil)), a.Strings()},
+		{"empty-a", NewSet(nil).Difference(a), nil},
+	}
+
+	for _, tt := range tests {
+		if got := tt.set.Strings(); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseAllAndParseList(t *testing.T) {
+	ranges, err := ParseList("# header\n\n1.2.3.4\n  bad \n1.2.3.0/33\n2001:db8::/32\n")
+	if len(ranges) != 2 {
+		t.Errorf("ParseList kept %d ranges, want 2", len(ranges))
+	}
+
+	var listErr *ListError
+	if !errors.As(err, &listErr) || len(listErr.Errors) != 2 {
+		t.Fatalf("ParseList error = %v, want a *ListError with 2 errors", err)
+	}
+	want := "invalid ranges: bad (invalid address 'bad': unable to parse IP), 1.2.3.0/33 (prefix length 33 is longer than the 32 bits of IPv4)"
+	if err.Error() != want {
+		t.Errorf("ParseList error = %q, want %q", err.Error(), want)
+	}
+
+	if _, err := ParseAll([]string{"1.2.3.4", "2001:db8::1"}); err != nil {
+		t.Errorf("ParseAll of valid ranges failed: %v", err)
+	}
+}
+
+// FuzzParse checks that every range Parse accepts reads back the same from its String form
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"1.2.3.4", "1.2.3.0/24", "1.2.3.4 - 1.2.3.9", "::ffff:1.2.3.0/120", "2001:db8::/32",
+		"2001:db8::1-2001:db8::4", "1.2.3.0/33", "01.2.3.4", "fe80::1%eth0", "::ffff:0:0/95",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		r, err := Parse(text)
+		if err != nil {
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse(%q) returned %T, want *ParseError", text, err)
+			}
+			return
+		}
+
+		if r.First.Is4() != r.Last.Is4() || r.Last.Less(r.First) || r.First.Zone() != "" {
+			t.Fatalf("Parse(%q) = %v-%v, which is not a valid range", text, r.First, r.Last)
+		}
+
+		again, err := Parse(r.String())
+		if err != nil {
+			t.Fatalf("Parse(%q).String() = %q does not parse: %v", text, r.String(), err)
+		}
+		if again != r {
+			t.Fatalf("Parse(%q) = %v-%v, but its String %q reads as %v-%v", text, r.First, r.Last, r.String(), again.First, again.Last)
+		}
+	})
+}
+
+// FuzzNewSet checks that merging ranges into a set does not change which addresses
+// are in it
+func FuzzNewSet(f *testing.F) {
+	f.Add("1.2.3.0/24,1.2.4.0/24,1.2.3.128/25", "1.2.4.1")
+	f.Add("10.0.0.0-10.0.0.9,10.0.0.10,10.0.0.12", "10.0.0.11")
+	f.Add("255.255.255.255,::,2001:db8::/48", "::ffff:255.255.255.255")
+	f.Add("2001:db8::1-2001:db8::4,2001:db8::5/128", "2001:db8::5")
+
+	f.Fuzz(func(t *testing.T, list, probe string) {
+		var ranges []Range
+		for _, text := range strings.Split(list, ",") {
+			if r, err := Parse(text); err == nil {
+				ranges = append(ranges, r)
+			}
+		}
+		set := NewSet(ranges)
+
+		// Probe the given address and the edges of every range
+		addrs := []netip.Addr{}
+		if addr, err := netip.ParseAddr(probe); err == nil {
+			addrs = append(addrs, addr)
+		}
+		for _, r := range ranges {
+			addrs = append(addrs, r.First, r.Last, r.First.Prev(), r.Last.Next())
+		}
+
+		for _, addr := range addrs {
+			if !addr.IsValid() {
+				continue
+			}
+			want := false
+			for _, r := range ranges {
+				if r.Contains(addr) {
+					want = true
+					break
+				}
+			}
+			if got := set.Contains(addr); got != want {
+				t.Fatalf("set of %q contains %v = %v, ranges say %v", list, addr, got, want)
+			}
+		}
+
+		checkMerged(t, set)
+	})
+}
+
+func FuzzDifference(f *testing.F) {
+	f.Add("1.2.3.0/24,2001:db8::/32", "1.2.3.64/26,2001:db8::/48", "1.2.3.100")
+	f.Add("10.0.0.0-10.0.0.9", "10.0.0.0-10.0.0.9", "10.0.0.5")
+	f.Add("0.0.0.0/0,::/0", "255.255.255.255,::", "ffff::1")
+
+	f.Fuzz(func(t *testing.T, listA, listB, probe string) {
+		parse := func(list string) []Range {
+			var ranges []Range
+			for _, text := range strings.Split(list, ",") {
+				if r, err := Parse(text); err == nil {
+					ranges = append(ranges, r)
+				}
+			}
+			return ranges
+		}
+		rangesA, rangesB := parse(listA), parse(listB)
+		a, b := NewSet(rangesA), NewSet(rangesB)
+		difference, intersection := a.Difference(b), a.Intersection(b)
+
+		// Probe the given address and the edges of every range of either list
+		addrs := []netip.Addr{}
+		if addr, err := netip.ParseAddr(probe); err == nil {
+			addrs = append(addrs, addr)
+		}
+		for _, r := range append(rangesA, rangesB...) {
+			addrs = append(addrs, r.First, r.Last, r.First.Prev(), r.Last.Next())
+		}
+
+		for _, addr := range addrs {
+			if !addr.IsValid() {
+				continue
+			}
+			inA, inB := a.Contains(addr), b.Contains(addr)
+			if got := difference.Contains(addr); got != (inA && !inB) {
+				t.Fatalf("%q minus %q contains %v = %v, want %v", listA, listB, addr, got, inA && !inB)
+			}
+			if got := intersection.Contains(addr); got != (inA && inB) {
+				t.Fatalf("%q and %q both contain %v = %v, want %v", listA, listB, addr, got, inA && inB)
+			}
+		}
+
+		checkMerged(t, difference)
+		checkMerged(t, intersection)
+	})
+}
+
+// checkMerged fails when the ranges of set are not sorted, disjoint and apart
+func checkMerged(t *testing.T, set *Set) {
+	t.Helper()
+	merged := set.Ranges()
+	for i := 1; i < len(merged); i++ {
+		if adjacentOrOverlapping(merged[i-1], merged[i]) || merged[i].Compare(merged[i-1]) <= 0 {
+			t.Fatalf("set has ranges %v and %v that should have been merged", merged[i-1], merged[i])
+		}
+	}
+}
+
+func mustParse(t *testing.T, text string) Range {
+	t.Helper()
+	r, err := Parse(text)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %v", text, err)
+	}
+	return r
+}
+
+func mustParseAll(t *testing.T, texts ...string) []Range {
+	t.Helper()
+	ranges, err := ParseAll(texts)
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	return ranges
+}
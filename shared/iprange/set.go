@@ -0,0 +1,182 @@
+package iprange
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Set is a sorted list of ranges that neither overlap nor touch
+type Set struct {
+	ranges []Range
+}
+
+// NewSet builds the set of every address in ranges
+func NewSet(ranges []Range) *Set {
+	sorted := append([]Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Compare(sorted[j]) < 0
+	})
+
+	set := &Set{}
+	for _, r := range sorted {
+		n := len(set.ranges)
+		if n == 0 || !adjacentOrOverlapping(set.ranges[n-1], r) {
+			set.ranges = append(set.ranges, r)
+			continue
+		}
+		if set.ranges[n-1].Last.Less(r.Last) {
+			set.ranges[n-1].Last = r.Last
+		}
+	}
+	return set
+}
+
+// ListError lists the ranges of a list that did not parse
+type ListError struct {
+	Errors []*ParseError
+}
+
+func (e *ListError) Error() string {
+	invalid := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		invalid = append(invalid, fmt.Sprintf("%s (%s)", strings.TrimSpace(err.Text), err.Reason))
+	}
+	return "invalid ranges: " + strings.Join(invalid, ", ")
+}
+
+// ParseAll parses every range of a list, returning the ranges that parsed and a
+// *ListError for the ones that did not
+func ParseAll(texts []string) ([]Range, error) {
+	ranges := make([]Range, 0, len(texts))
+	var invalid []*ParseError
+	for _, text := range texts {
+		r, err := Parse(text)
+		if err != nil {
+			invalid = append(invalid, err.(*ParseError))
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+	if len(invalid) > 0 {
+		return ranges, &ListError{Errors: invalid}
+	}
+	return ranges, nil
+}
+
+// ParseList reads a region list file: one range per line, skipping blank lines and
+// lines starting with #. It returns the ranges that parsed, in file order, and a
+// *ListError for the ones that did not.
+func ParseList(content string) ([]Range, error) {
+	var texts []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			texts = append(texts, line)
+		}
+	}
+	return ParseAll(texts)
+}
+
+// Ranges returns the merged ranges of the set in address order
+func (s *Set) Ranges() []Range {
+	return append([]Range(nil), s.ranges...)
+}
+
+// Prefixes returns the smallest list of CIDR prefixes covering the set, in address order
+func (s *Set) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, r := range s.ranges {
+		prefixes = append(prefixes, r.Prefixes()...)
+	}
+	return prefixes
+}
+
+// Strings returns the prefixes of the set formatted for a region list
+func (s *Set) Strings() []string {
+	prefixes := s.Prefixes()
+	texts := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		texts = append(texts, prefix.String())
+	}
+	return texts
+}
+
+// Contains reports whether addr is in the set
+func (s *Set) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return !s.ranges[i].Last.Less(addr)
+	})
+	return i < len(s.ranges) && s.ranges[i].Contains(addr)
+}
+
+// Overlaps reports whether any address of r is in the set
+func (s *Set) Overlaps(r Range) bool {
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return !s.ranges[i].Last.Less(r.First)
+	})
+	return i < len(s.ranges) && s.ranges[i].Overlaps(r)
+}
+
+// Difference returns the set of the addresses that are in s but not in other
+func (s *Set) Difference(other *Set) *Set {
+	result := &Set{}
+	j := 0
+	for _, r := range s.ranges {
+		// Ranges of other that end before r also end before every later range of s
+		for j < len(other.ranges) && other.ranges[j].Last.Less(r.First) {
+			j++
+		}
+
+		rest, covered := r, false
+		for k := j; k < len(other.ranges) && !r.Last.Less(other.ranges[k].First); k++ {
+			cut := other.ranges[k]
+			if rest.First.Less(cut.First) {
+				result.ranges = append(result.ranges, Range{First: rest.First, Last: cut.First.Prev()})
+			}
+			if !cut.Last.Less(rest.Last) {
+				covered = true
+				break
+			}
+			rest.First = cut.Last.Next()
+		}
+		if !covered {
+			result.ranges = append(result.ranges, rest)
+		}
+	}
+	return result
+}
+
+// Intersection returns the set of the addresses that are in both s and other
+func (s *Set) Intersection(other *Set) *Set {
+	return s.Difference(s.Difference(other))
+}
+
+// Size returns the number of addresses in the set, or math.MaxUint64 if there are more
+func (s *Set) Size() uint64 {
+	var size uint64
+	for _, r := range s.ranges {
+		var carry uint64
+		size, carry = bits.Add64(size, r.Size(), 0)
+		if carry != 0 {
+			return math.MaxUint64
+		}
+	}
+	return size
+}
+
+// adjacentOrOverlapping reports whether next, which does not start before prev, can be
+// merged into prev
+func adjacentOrOverlapping(prev, next Range) bool {
+	if prev.Is4() != next.Is4() {
+		return false
+	}
+	if !prev.Last.Less(next.First) {
+		return true
+	}
+	return prev.Last.Next() == next.First
+}
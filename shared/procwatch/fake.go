@@ -0,0 +1,134 @@
+package procwatch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Fake is a source whose processes are started and stopped by hand, for driving a
+// Watcher in tests and development without the game. It reports starts and exits to
+// watchers unless NoEvents is set, in which case watchers poll it.
+type Fake struct {
+	NoEvents bool
+
+	mutex       sync.Mutex
+	processes   map[int]Process
+	nextPID     int
+	subscribers []chan Notification
+}
+
+// NewFake creates a fake source without processes
+func NewFake() *Fake {
+	return &Fake{processes: make(map[int]Process), nextPID: 1000}
+}
+
+// Start adds a process running the executable at path and returns its PID
+func (f *Fake) Start(path string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.nextPID++
+	f.add(f.nextPID, path)
+	return f.nextPID
+}
+
+// Reuse replaces the process with the given PID by one running the executable at path,
+// as if the PID was reused between two snapshots, and reports the exit and the start
+func (f *Fake) Reuse(pid int, path string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.processes[pid]; ok {
+		f.remove(pid)
+	}
+	f.add(pid, path)
+}
+
+// Exit removes a process
+func (f *Fake) Exit(pid int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.remove(pid)
+}
+
+// EndEvents closes the notification channels, as when an event source fails
+func (f *Fake) EndEvents() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, subscriber := range f.subscribers {
+		close(subscriber)
+	}
+	f.subscribers = nil
+}
+
+func (f *Fake) add(pid int, path string) {
+	f.processes[pid] = Process{PID: pid, Name: baseName(path), Path: path}
+	f.notify(Notification{Type: Started, PID: pid, Name: baseName(path)})
+}
+
+func (f *Fake) remove(pid int) {
+	delete(f.processes, pid)
+	f.notify(Notification{Type: Exited, PID: pid})
+}
+
+func (f *Fake) notify(n Notification) {
+	for _, subscriber := range f.subscribers {
+		subscriber <- n
+	}
+}
+
+func (f *Fake) Processes() ([]Process, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	processes := make([]Process, 0, len(f.processes))
+	for _, process := range f.processes {
+		processes = append(processes, Process{PID: process.PID, Name: process.Name})
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].PID < processes[j].PID
+	})
+	return processes, nil
+}
+
+func (f *Fake) ExecutablePath(pid int) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	process, ok := f.processes[pid]
+	if !ok {
+		return "", fmt.Errorf("no process %d", pid)
+	}
+	return process.Path, nil
+}
+
+// Watch reports starts and exits until stop is closed. The channel is buffered, so the
+// methods that change processes do not wait for the watcher.
+func (f *Fake) Watch(stop <-chan struct{}) (<-chan Notification, error) {
+	if f.NoEvents {
+		return nil, errors.New("fake events disabled")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	subscriber := make(chan Notification, 64)
+	f.subscribers = append(f.subscribers, subscriber)
+
+	go func() {
+		<-stop
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		for i, s := range f.subscribers {
+			if s == subscriber {
+				f.subscribers = append(f.subscribers[:i], f.subscribers[i+1:]...)
+				close(subscriber)
+				break
+			}
+		}
+	}()
+	return subscriber, nil
+}
@@ -0,0 +1,133 @@
+package procwatch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Process connector constants from linux/connector.h and linux/cn_proc.h
+const (
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+	procEventExec     = 0x00000002
+	procEventExit     = 0x80000000
+
+	// cn_msg is idx, val, seq, ack (4 bytes each), len and flags (2 bytes each)
+	cnMsgSize = 20
+)
+
+// Watch subscribes to the netlink process connector. Joining its multicast group needs
+// CAP_NET_ADMIN, so unprivileged watchers get an error and poll instead. Processes are
+// reported when they exec, since that is when they get their executable name; the name
+// is read from /proc right away and a process that is already gone by then is missed.
+func (systemSource) Watch(stop <-chan struct{}) (<-chan Notification, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, fmt.Errorf("opening process connector: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("binding process connector: %w", err)
+	}
+	if err := sendListen(fd); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("subscribing to process events: %w", err)
+	}
+	// The timeout lets the reader notice stop
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("setting process connector timeout: %w", err)
+	}
+
+	notifications := make(chan Notification, 64)
+	go func() {
+		defer close(notifications)
+		defer unix.Close(fd)
+
+		buf := make([]byte, 8192)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if errors.Is(err, unix.ENOBUFS) {
+				// The kernel dropped events, the next resync snapshot catches up
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			messages, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, message := range messages {
+				notification, ok := parseProcEvent(message.Data)
+				if !ok {
+					continue
+				}
+				select {
+				case notifications <- notification:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return notifications, nil
+}
+
+// sendListen asks the kernel to start multicasting process events
+func sendListen(fd int) error {
+	buf := make([]byte, unix.NLMSG_HDRLEN+cnMsgSize+4)
+	binary.NativeEndian.PutUint32(buf[0:], uint32(len(buf)))
+	binary.NativeEndian.PutUint16(buf[4:], unix.NLMSG_DONE)
+
+	msg := buf[unix.NLMSG_HDRLEN:]
+	binary.NativeEndian.PutUint32(msg[0:], cnIdxProc)
+	binary.NativeEndian.PutUint32(msg[4:], cnValProc)
+	binary.NativeEndian.PutUint16(msg[16:], 4)
+	binary.NativeEndian.PutUint32(msg[cnMsgSize:], procCnMcastListen)
+
+	return unix.Sendto(fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+// parseProcEvent turns the exec or exit of a process (not of a single thread) into a
+// notification. proc_event is what, cpu and a timestamp, then for both events the pid
+// and tgid of the thread.
+func parseProcEvent(data []byte) (Notification, bool) {
+	if len(data) < cnMsgSize+24 {
+		return Notification{}, false
+	}
+	event := data[cnMsgSize:]
+	what := binary.NativeEndian.Uint32(event[0:])
+	pid := int(binary.NativeEndian.Uint32(event[16:]))
+	tgid := int(binary.NativeEndian.Uint32(event[20:]))
+	if pid != tgid {
+		return Notification{}, false
+	}
+
+	switch what {
+	case procEventExec:
+		name := commandName(pid)
+		if name == "" {
+			return Notification{}, false
+		}
+		return Notification{Type: Started, PID: pid, Name: name}, true
+	case procEventExit:
+		return Notification{Type: Exited, PID: pid}, true
+	}
+	return Notification{}, false
+}
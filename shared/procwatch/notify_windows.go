@@ -0,0 +1,336 @@
+//go:build windows && (amd64 || arm64)
+
+package procwatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// The process events come from a real-time ETW session with the
+// Microsoft-Windows-Kernel-Process provider. Starting a session needs administrator
+// rights, so watchers without them get an error and poll instead. The structures below
+// follow evntrace.h and evntcons.h for 64-bit Windows.
+
+const etwSessionName = "OW-VPN procwatch"
+
+var kernelProcessProvider = windows.GUID{
+	Data1: 0x22fb2cd6,
+	Data2: 0x0e7b,
+	Data3: 0x422b,
+	Data4: [8]byte{0xa0, 0xc7, 0x2f, 0xad, 0x1f, 0xd0, 0xe7, 0x16},
+}
+
+const (
+	wnodeFlagTracedGUID           = 0x00020000
+	eventTraceRealTimeMode        = 0x00000100
+	eventTraceControlStop         = 1
+	eventControlCodeEnable        = 1
+	traceLevelInformation         = 4
+	winEventKeywordProcess        = 0x10
+	processTraceModeRealTime      = 0x00000100
+	processTraceModeEventRecord   = 0x10000000
+	invalidProcessTraceHandle     = ^uint64(0)
+	eventIDProcessStart           = 1
+	eventIDProcessStop            = 2
+	processStartImageNameOffsetV0 = 20
+	processStartImageNameOffset   = 24
+)
+
+var (
+	advapi32          = windows.NewLazySystemDLL("advapi32.dll")
+	procStartTraceW   = advapi32.NewProc("StartTraceW")
+	procControlTraceW = advapi32.NewProc("ControlTraceW")
+	procEnableTraceEx = advapi32.NewProc("EnableTraceEx2")
+	procOpenTraceW    = advapi32.NewProc("OpenTraceW")
+	procProcessTrace  = advapi32.NewProc("ProcessTrace")
+	procCloseTrace    = advapi32.NewProc("CloseTrace")
+)
+
+type wnodeHeader struct {
+	BufferSize        uint32
+	ProviderID        uint32
+	HistoricalContext uint64
+	TimeStamp         int64
+	GUID              windows.GUID
+	ClientContext     uint32
+	Flags             uint32
+}
+
+type eventTraceProperties struct {
+	Wnode               wnodeHeader
+	BufferSize          uint32
+	MinimumBuffers      uint32
+	MaximumBuffers      uint32
+	MaximumFileSize     uint32
+	LogFileMode         uint32
+	FlushTimer          uint32
+	EnableFlags         uint32
+	AgeLimit            int32
+	NumberOfBuffers     uint32
+	FreeBuffers         uint32
+	EventsLost          uint32
+	BuffersWritten      uint32
+	LogBuffersLost      uint32
+	RealTimeBuffersLost uint32
+	LoggerThreadID      windows.Handle
+	LogFileNameOffset   uint32
+	LoggerNameOffset    uint32
+}
+
+// sessionProperties is EVENT_TRACE_PROPERTIES followed by room for the session name
+type sessionProperties struct {
+	eventTraceProperties
+	name [len(etwSessionName) + 1]uint16
+}
+
+type eventTraceHeader struct {
+	Size           uint16
+	FieldTypeFlags uint16
+	Version        uint32
+	ThreadID       uint32
+	ProcessID      uint32
+	TimeStamp      int64
+	GUID           windows.GUID
+	ProcessorTime  uint64
+}
+
+type eventTrace struct {
+	Header           eventTraceHeader
+	InstanceID       uint32
+	ParentInstanceID uint32
+	ParentGUID       windows.GUID
+	MofData          uintptr
+	MofLength        uint32
+	ClientContext    uint32
+}
+
+type traceLogfileHeader struct {
+	BufferSize         uint32
+	Version            uint32
+	ProviderVersion    uint32
+	NumberOfProcessors uint32
+	EndTime            int64
+	TimerResolution    uint32
+	MaximumFileSize    uint32
+	LogFileMode        uint32
+	BuffersWritten     uint32
+	LogInstanceGUID    windows.GUID
+	LoggerName         uintptr
+	LogFileName        uintptr
+	TimeZone           windows.Timezoneinformation
+	BootTime           int64
+	PerfFreq           int64
+	StartTime          int64
+	ReservedFlags      uint32
+	BuffersLost        uint32
+}
+
+type eventTraceLogfile struct {
+	LogFileName         *uint16
+	LoggerName          *uint16
+	CurrentTime         int64
+	BuffersRead         uint32
+	ProcessTraceMode    uint32
+	CurrentEvent        eventTrace
+	LogfileHeader       traceLogfileHeader
+	BufferCallback      uintptr
+	BufferSize          uint32
+	Filled              uint32
+	EventsLost          uint32
+	EventRecordCallback uintptr
+	IsKernelTrace       uint32
+	Context             uintptr
+}
+
+type eventDescriptor struct {
+	ID      uint16
+	Version uint8
+	Channel uint8
+	Level   uint8
+	Opcode  uint8
+	Task    uint16
+	Keyword uint64
+}
+
+type eventRecord struct {
+	Size              uint16
+	HeaderType        uint16
+	Flags             uint16
+	EventProperty     uint16
+	ThreadID          uint32
+	ProcessID         uint32
+	TimeStamp         int64
+	ProviderID        windows.GUID
+	Descriptor        eventDescriptor
+	ProcessorTime     uint64
+	ActivityID        windows.GUID
+	BufferContext     uint32
+	ExtendedDataCount uint16
+	UserDataLength    uint16
+	ExtendedData      *byte
+	UserData          *byte
+	UserContext       uintptr
+}
+
+// The callback is created once, as Windows callbacks are never freed. It finds the
+// channel of its session through the context number the session was opened with.
+var (
+	sessionsMutex sync.Mutex
+	sessions      = make(map[uintptr]chan<- Notification)
+	nextSession   uintptr
+	eventCallback = windows.NewCallback(onEvent)
+)
+
+// Watch starts a real-time ETW session for process starts and stops. There is one
+// session per machine: a session left behind by a watcher that crashed is replaced, and
+// a second watcher takes the session over, after which the first one polls.
+func (systemSource) Watch(stop <-chan struct{}) (<-chan Notification, error) {
+	props := newSessionProperties()
+	var session uint64
+	err := startTrace(&session, props)
+	if errors.Is(err, windows.ERROR_ALREADY_EXISTS) {
+		controlTrace(0, newSessionProperties(), eventTraceControlStop)
+		err = startTrace(&session, props)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("starting process event session: %w", err)
+	}
+
+	r, _, _ := procEnableTraceEx.Call(uintptr(session), uintptr(unsafe.Pointer(&kernelProcessProvider)),
+		eventControlCodeEnable, traceLevelInformation, winEventKeywordProcess, 0, 0, 0)
+	if r != 0 {
+		controlTrace(session, newSessionProperties(), eventTraceControlStop)
+		return nil, fmt.Errorf("enabling process events: %w", windows.Errno(r))
+	}
+
+	notifications := make(chan Notification, 256)
+	sessionsMutex.Lock()
+	nextSession++
+	context := nextSession
+	sessions[context] = notifications
+	sessionsMutex.Unlock()
+
+	logfile := &eventTraceLogfile{
+		LoggerName:          &props.name[0],
+		ProcessTraceMode:    processTraceModeRealTime | processTraceModeEventRecord,
+		EventRecordCallback: eventCallback,
+		Context:             context,
+	}
+	r, _, _ = procOpenTraceW.Call(uintptr(unsafe.Pointer(logfile)))
+	trace := uint64(r)
+	if trace == invalidProcessTraceHandle {
+		sessionsMutex.Lock()
+		delete(sessions, context)
+		sessionsMutex.Unlock()
+		controlTrace(session, newSessionProperties(), eventTraceControlStop)
+		return nil, fmt.Errorf("opening process event session: %w", windows.GetLastError())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Blocks delivering events until the session stops
+		procProcessTrace.Call(uintptr(unsafe.Pointer(&trace)), 1, 0, 0)
+		procCloseTrace.Call(uintptr(trace))
+
+		sessionsMutex.Lock()
+		delete(sessions, context)
+		sessionsMutex.Unlock()
+		close(notifications)
+	}()
+
+	go func() {
+		select {
+		case <-stop:
+			controlTrace(session, newSessionProperties(), eventTraceControlStop)
+		case <-done:
+		}
+	}()
+
+	return notifications, nil
+}
+
+func newSessionProperties() *sessionProperties {
+	props := &sessionProperties{}
+	props.Wnode.BufferSize = uint32(unsafe.Sizeof(*props))
+	props.Wnode.Flags = wnodeFlagTracedGUID
+	props.Wnode.ClientContext = 1 // QueryPerformanceCounter timestamps
+	props.LogFileMode = eventTraceRealTimeMode
+	props.LoggerNameOffset = uint32(unsafe.Offsetof(props.name))
+	copy(props.name[:], windows.StringToUTF16(etwSessionName))
+	return props
+}
+
+func startTrace(session *uint64, props *sessionProperties) error {
+	r, _, _ := procStartTraceW.Call(uintptr(unsafe.Pointer(session)),
+		uintptr(unsafe.Pointer(&props.name[0])), uintptr(unsafe.Pointer(props)))
+	if r != 0 {
+		return windows.Errno(r)
+	}
+	return nil
+}
+
+func controlTrace(session uint64, props *sessionProperties, code uintptr) {
+	procControlTraceW.Call(uintptr(session), uintptr(unsafe.Pointer(&props.name[0])),
+		uintptr(unsafe.Pointer(props)), code)
+}
+
+// onEvent runs on the thread of ProcessTrace. It must not block, so notifications are
+// dropped when the channel is full; the next resync snapshot catches up.
+func onEvent(record *eventRecord) uintptr {
+	if record.ProviderID != kernelProcessProvider || record.UserData == nil {
+		return 0
+	}
+	data := unsafe.Slice(record.UserData, record.UserDataLength)
+	if len(data) < 4 {
+		return 0
+	}
+	pid := int(uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24)
+
+	var notification Notification
+	switch record.Descriptor.ID {
+	case eventIDProcessStart:
+		offset := processStartImageNameOffset
+		if record.Descriptor.Version == 0 {
+			offset = processStartImageNameOffsetV0
+		}
+		name := baseName(imageName(data, offset))
+		if name == "" || name == "." {
+			return 0
+		}
+		notification = Notification{Type: Started, PID: pid, Name: name}
+	case eventIDProcessStop:
+		notification = Notification{Type: Exited, PID: pid}
+	default:
+		return 0
+	}
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	if notifications, ok := sessions[record.UserContext]; ok {
+		select {
+		case notifications <- notification:
+		default:
+		}
+	}
+	return 0
+}
+
+// imageName reads the NUL-terminated UTF-16 ImageName at offset, a kernel path like
+// \Device\HarddiskVolume3\Games\Overwatch\_retail_\Overwatch.exe
+func imageName(data []byte, offset int) string {
+	var name []uint16
+	for i := offset; i+1 < len(data); i += 2 {
+		c := uint16(data[i]) | uint16(data[i+1])<<8
+		if c == 0 {
+			break
+		}
+		name = append(name, c)
+	}
+	return windows.UTF16ToString(name)
+}
@@ -0,0 +1,319 @@
+// Package procwatch reports when processes of interest start and exit. Where the system
+// source can, it is told about every process start and exit as it happens (ETW on
+// Windows, the netlink process connector on Linux), so short-lived processes are seen
+// as well. Otherwise it falls back to native process snapshots (Toolhelp on Windows,
+// /proc on Linux) taken every interval, which miss processes that start and exit
+// between two snapshots.
+package procwatch
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often a started watcher takes a snapshot when its source cannot
+// report process starts and exits
+const DefaultInterval = time.Second
+
+// ResyncInterval is how often a watcher that receives notifications still takes a
+// snapshot, to pick up notifications that were lost
+const ResyncInterval = 30 * time.Second
+
+// ErrUnsupported is returned by the system source on platforms without a native implementation
+var ErrUnsupported = errors.New("process listing is not supported on this platform")
+
+// EventType says whether a process started or exited
+type EventType string
+
+const (
+	Started EventType = "started"
+	Exited  EventType = "exited"
+)
+
+// Process is a running process. Path is empty when it could not be read, for example
+// for processes of another user.
+type Process struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+}
+
+// Event reports a matching process that started or exited
+type Event struct {
+	Type    EventType `json:"type"`
+	Process Process   `json:"process"`
+	Time    time.Time `json:"time"`
+}
+
+// Source lists processes. Processes only needs to fill in PID and Name; ExecutablePath is
+// only called for processes that match, since it is much more expensive.
+type Source interface {
+	Processes() ([]Process, error)
+	ExecutablePath(pid int) (string, error)
+}
+
+// Notification is a process start or exit reported by an event source as it happens.
+// Name is the executable name for starts and may be empty for exits.
+type Notification struct {
+	Type EventType
+	PID  int
+	Name string
+}
+
+// EventSource is a source that can also report processes starting and exiting. Watch
+// returns an error when notifications are not available, for example without the
+// privileges they need; the watcher then polls. Otherwise it sends notifications until
+// stop is closed, and closes the channel when it ends for any reason.
+type EventSource interface {
+	Source
+	Watch(stop <-chan struct{}) (<-chan Notification, error)
+}
+
+// MatchNames returns a matcher for executable names, compared case-insensitively
+func MatchNames(names ...string) func(name string) bool {
+	return func(name string) bool {
+		for _, candidate := range names {
+			if strings.EqualFold(name, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Watcher tracks the matching processes of a source
+type Watcher struct {
+	source   Source
+	match    func(name string) bool
+	interval time.Duration
+
+	mutex       sync.Mutex
+	running     map[int]Process
+	eventDriven bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a watcher for the processes of source whose name matches
+func New(source Source, match func(name string) bool, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Watcher{
+		source:   source,
+		match:    match,
+		interval: interval,
+		running:  make(map[int]Process),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Poll takes one snapshot and returns the changes since the previous one. On the first
+// poll every matching process that is already running is reported as started.
+func (w *Watcher) Poll() ([]Event, error) {
+	processes, err := w.source.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	var events []Event
+	seen := make(map[int]bool)
+
+	for _, process := range processes {
+		if !w.match(process.Name) {
+			continue
+		}
+		seen[process.PID] = true
+
+		if known, ok := w.running[process.PID]; ok {
+			if strings.EqualFold(known.Name, process.Name) {
+				continue
+			}
+			// The PID was reused by another matching process between snapshots
+			events = append(events, Event{Type: Exited, Process: known, Time: now})
+		}
+
+		if path, err := w.source.ExecutablePath(process.PID); err == nil {
+			process.Path = path
+		}
+		w.running[process.PID] = process
+		events = append(events, Event{Type: Started, Process: process, Time: now})
+	}
+
+	for pid, process := range w.running {
+		if !seen[pid] {
+			delete(w.running, pid)
+			events = append(events, Event{Type: Exited, Process: process, Time: now})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Type == Exited && events[j].Type == Started
+	})
+	return events, nil
+}
+
+// notify applies a notification from an event source and returns the resulting events.
+// A start for a PID that is already known under the same name was seen by a snapshot
+// first and is ignored.
+func (w *Watcher) notify(n Notification) []Event {
+	now := time.Now()
+
+	switch n.Type {
+	case Started:
+		if !w.match(n.Name) {
+			return nil
+		}
+		// Read the path first, the process may be gone by the time the lock is held
+		process := Process{PID: n.PID, Name: n.Name}
+		if path, err := w.source.ExecutablePath(n.PID); err == nil {
+			process.Path = path
+		}
+
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+
+		var events []Event
+		if known, ok := w.running[n.PID]; ok {
+			if strings.EqualFold(known.Name, n.Name) {
+				return nil
+			}
+			// The exit of the previous process was lost
+			events = append(events, Event{Type: Exited, Process: known, Time: now})
+		}
+		w.running[n.PID] = process
+		return append(events, Event{Type: Started, Process: process, Time: now})
+
+	case Exited:
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+
+		known, ok := w.running[n.PID]
+		if !ok {
+			return nil
+		}
+		delete(w.running, n.PID)
+		return []Event{{Type: Exited, Process: known, Time: now}}
+	}
+	return nil
+}
+
+// Start takes a first snapshot and then reports every change to the returned channel
+// until Stop. If the source is an EventSource that can deliver notifications, changes are
+// reported as they happen and a snapshot is only taken every ResyncInterval; otherwise,
+// or once the notifications end, the watcher polls every interval. The first snapshot's
+// error is returned, for example ErrUnsupported; later errors skip that poll.
+func (w *Watcher) Start() (<-chan Event, error) {
+	// Subscribe before the first snapshot, so no process falls between the two
+	var notifications <-chan Notification
+	if source, ok := w.source.(EventSource); ok {
+		if ch, err := source.Watch(w.stop); err == nil {
+			notifications = ch
+		}
+	}
+	w.mutex.Lock()
+	w.eventDriven = notifications != nil
+	w.mutex.Unlock()
+
+	initial, err := w.Poll()
+	if err != nil {
+		w.Stop()
+		return nil, err
+	}
+
+	events := make(chan Event, len(initial)+16)
+	for _, event := range initial {
+		events <- event
+	}
+
+	go func() {
+		defer close(events)
+
+		interval := w.interval
+		if notifications != nil {
+			interval = ResyncInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			var changes []Event
+			select {
+			case <-w.stop:
+				return
+			case n, ok := <-notifications:
+				if !ok {
+					// Notifications ended, fall back to polling
+					notifications = nil
+					w.mutex.Lock()
+					w.eventDriven = false
+					w.mutex.Unlock()
+					ticker.Reset(w.interval)
+					continue
+				}
+				changes = w.notify(n)
+			case <-ticker.C:
+				changes, err = w.Poll()
+				if err != nil {
+					continue
+				}
+			}
+
+			for _, event := range changes {
+				select {
+				case events <- event:
+				case <-w.stop:
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// EventDriven reports whether a started watcher receives process notifications, rather
+// than polling
+func (w *Watcher) EventDriven() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.eventDriven
+}
+
+// Stop ends watching and closes the event channel
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+// Running returns the matching processes that are running as far as the watcher knows,
+// ordered by PID
+func (w *Watcher) Running() []Process {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	processes := make([]Process, 0, len(w.running))
+	for _, process := range w.running {
+		processes = append(processes, process)
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].PID < processes[j].PID
+	})
+	return processes
+}
+
+// baseName returns the file name of a Windows or Unix path
+func baseName(path string) string {
+	if i := strings.LastIndex(path, `\`); i >= 0 {
+		path = path[i+1:]
+	}
+	return filepath.Base(path)
+}
@@ -0,0 +1,186 @@
+package procwatch
+
+import (
+	"testing"
+	"time"
+)
+
+const overwatch = `C:\Games\Overwatch\_retail_\Overwatch.exe`
+
+func newWatcher(source *Fake) *Watcher {
+	return New(source, MatchNames("Overwatch.exe"), 10*time.Millisecond)
+}
+
+// checkEvents compares the types and PIDs of events
+func checkEvents(t *testing.T, got []Event, want ...Event) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Type != want[i].Type || got[i].Process.PID != want[i].Process.PID {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+}
+
+func event(typ EventType, pid int) Event {
+	return Event{Type: typ, Process: Process{PID: pid}}
+}
+
+// next returns the next event of a started watcher
+func next(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("event channel closed")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event within 5s")
+	}
+	return Event{}
+}
+
+func TestPollStartAndExit(t *testing.T) {
+	source := NewFake()
+	source.Start(`C:\Windows\explorer.exe`)
+	running := source.Start(overwatch)
+	w := newWatcher(source)
+
+	// Processes that already run are reported by the first poll
+	events, err := w.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEvents(t, events, event(Started, running))
+	if events[0].Process.Path != overwatch {
+		t.Errorf("path = %q, want %q", events[0].Process.Path, overwatch)
+	}
+
+	events, _ = w.Poll()
+	checkEvents(t, events)
+
+	started := source.Start(overwatch)
+	source.Exit(running)
+	events, _ = w.Poll()
+	checkEvents(t, events, event(Exited, running), event(Started, started))
+
+	if got := w.Running(); len(got) != 1 || got[0].PID != started {
+		t.Errorf("Running() = %v, want only %d", got, started)
+	}
+}
+
+func TestPollPIDReuse(t *testing.T) {
+	source := NewFake()
+	pid := source.Start(overwatch)
+	w := New(source, MatchNames("Overwatch.exe", "Overwatch Launcher.exe"), time.Second)
+	w.Poll()
+
+	// Another matching executable under the same PID is an exit and a start
+	source.Reuse(pid, `C:\Games\Overwatch\Overwatch Launcher.exe`)
+	events, _ := w.Poll()
+	checkEvents(t, events, event(Exited, pid), event(Started, pid))
+	if events[1].Process.Name != "Overwatch Launcher.exe" {
+		t.Errorf("started %q, want the launcher", events[1].Process.Name)
+	}
+
+	// A PID reused by a process that does not match is an exit
+	source.Reuse(pid, `C:\Windows\notepad.exe`)
+	events, _ = w.Poll()
+	checkEvents(t, events, event(Exited, pid))
+}
+
+func TestStartPolling(t *testing.T) {
+	source := NewFake()
+	source.NoEvents = true
+	running := source.Start(overwatch)
+
+	w := newWatcher(source)
+	events, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if w.EventDriven() {
+		t.Error("watcher of a source without events is event driven")
+	}
+	checkEvents(t, []Event{next(t, events)}, event(Started, running))
+
+	source.Exit(running)
+	checkEvents(t, []Event{next(t, events)}, event(Exited, running))
+}
+
+func TestStartEvents(t *testing.T) {
+	source := NewFake()
+	running := source.Start(overwatch)
+
+	// A long interval, so every change below has to come from a notification
+	w := New(source, MatchNames("Overwatch.exe"), time.Hour)
+	events, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	if !w.EventDriven() {
+		t.Error("watcher of the fake is not event driven")
+	}
+	checkEvents(t, []Event{next(t, events)}, event(Started, running))
+
+	// A process that exits before any snapshot could see it
+	short := source.Start(overwatch)
+	source.Exit(short)
+	checkEvents(t, []Event{next(t, events), next(t, events)}, event(Started, short), event(Exited, short))
+
+	// PID reuse arrives as an exit and a start
+	source.Reuse(running, overwatch)
+	checkEvents(t, []Event{next(t, events), next(t, events)}, event(Exited, running), event(Started, running))
+
+	source.Start(`C:\Windows\notepad.exe`)
+	source.Exit(running)
+	checkEvents(t, []Event{next(t, events)}, event(Exited, running))
+
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e)
+	default:
+	}
+}
+
+func TestStartFallsBackToPolling(t *testing.T) {
+	source := NewFake()
+	w := newWatcher(source)
+	events, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	source.EndEvents()
+	started := source.Start(overwatch)
+	checkEvents(t, []Event{next(t, events)}, event(Started, started))
+	if w.EventDriven() {
+		t.Error("watcher is still event driven after the notifications ended")
+	}
+}
+
+func TestStopClosesEvents(t *testing.T) {
+	w := newWatcher(NewFake())
+	events, err := w.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("event after Stop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event channel not closed after Stop")
+	}
+}
@@ -0,0 +1,77 @@
+package procwatch
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type systemSource struct{}
+
+// NewSystemSource returns the source for the processes of this machine, read from /proc
+func NewSystemSource() Source {
+	return systemSource{}
+}
+
+func (systemSource) Processes() ([]Process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("listing processes: %w", err)
+	}
+
+	var processes []Process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		name := commandName(pid)
+		if name == "" {
+			// The process exited while listing
+			continue
+		}
+		processes = append(processes, Process{PID: pid, Name: name})
+	}
+	return processes, nil
+}
+
+// commandName prefers the first command line argument, since comm is cut to 15
+// characters and Wine processes run as wine-preloader with the Windows path as argv[0]
+func commandName(pid int) string {
+	if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil && len(cmdline) > 0 {
+		argv0, _, _ := bytes.Cut(cmdline, []byte{0})
+		if name := baseName(string(argv0)); name != "" && name != "." {
+			return name
+		}
+	}
+
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+func (systemSource) ExecutablePath(pid int) (string, error) {
+	// Under Wine /proc/pid/exe is the preloader, while argv[0] is the Windows path
+	if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil {
+		argv0, _, _ := bytes.Cut(cmdline, []byte{0})
+		if isWindowsPath(string(argv0)) {
+			return string(argv0), nil
+		}
+	}
+
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return "", fmt.Errorf("reading executable of process %d: %w", pid, err)
+	}
+	return path, nil
+}
+
+// isWindowsPath reports whether path is an absolute path with a drive letter, like C:\Games
+func isWindowsPath(path string) bool {
+	return len(path) > 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+}
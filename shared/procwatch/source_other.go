@@ -0,0 +1,18 @@
+//go:build !linux && !windows
+
+package procwatch
+
+type systemSource struct{}
+
+// NewSystemSource returns a source that reports ErrUnsupported
+func NewSystemSource() Source {
+	return systemSource{}
+}
+
+func (systemSource) Processes() ([]Process, error) {
+	return nil, ErrUnsupported
+}
+
+func (systemSource) ExecutablePath(pid int) (string, error) {
+	return "", ErrUnsupported
+}